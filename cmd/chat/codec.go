@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+    "io"
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "bluetooth-chat/internal/delivery"
+    "bluetooth-chat/internal/framing"
+    "bluetooth-chat/internal/handshake"
+)
+
+// codec is the message format chosen in the handshake. send may split a message the
+// format cannot carry in one piece; feed returns the messages and receipts completed by p.
+// Messages from a peer without envelopes get a local timestamp and no ID. send and
+// sendReceipt may be called concurrently.
+type codec interface {
+    send(m delivery.Message) error
+    sendReceipt(r delivery.Receipt) error
+    feed(p []byte) ([]delivery.Message, []delivery.Receipt, error)
+    // receipts reports whether the peer acknowledges messages.
+    receipts() bool
+}
+
+// newCodec picks binary frames if both ends support them, LF-delimited lines otherwise.
+// Envelopes and receipts are used if both ends also announced delivery.Capability.
+func newCodec(rw io.Writer, hs handshake.Result) codec {
+    if hs.Has(framing.BinaryCapability) {
+        opts := framing.FrameOptions{MaxPayload: hs.MaxFrame, CRC: true}
+        return &frameCodec{
+            enc:      framing.NewFrameEncoder(rw, opts),
+            dec:      framing.NewFrameDecoder(opts),
+            envelope: hs.Has(delivery.Capability),
+        }
+    }
+    return &lineCodec{
+        enc: framing.NewEncoder(rw),
+        dec: framing.NewDecoder(framing.DecoderOptions{MaxLineLength: hs.MaxFrame, StripCR: true}),
+    }
+}
+
+// lineCodec sends each line of a multi-line message as a message of its own.
+type lineCodec struct {
+    enc *framing.Encoder // Encode is a single Write, safe for concurrent use
+    dec *framing.Decoder
+}
+
+func (c *lineCodec) send(m delivery.Message) error {
+    for _, line := range strings.Split(m.Text, "\n") {
+        if err := c.enc.Encode(line); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (c *lineCodec) sendReceipt(delivery.Receipt) error { return nil }
+
+func (c *lineCodec) feed(p []byte) ([]delivery.Message, []delivery.Receipt, error) {
+    lines, err := c.dec.Feed(p)
+    msgs := make([]delivery.Message, len(lines))
+    for i, line := range lines {
+        msgs[i] = delivery.Message{Time: time.Now(), Text: line}
+    }
+    return msgs, nil, err
+}
+
+func (c *lineCodec) receipts() bool { return false }
+
+// frameCodec carries each message in one text frame, LFs included, wrapped in an envelope
+// if negotiated; receipts go in control frames.
+type frameCodec struct {
+    envelope bool
+
+    mu     sync.Mutex // serializes the encoder
+    enc    *framing.FrameEncoder
+    nextID uint32
+    buf    []byte
+
+    dec *framing.FrameDecoder
+}
+
+func (c *frameCodec) send(m delivery.Message) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !c.envelope {
+        return c.writeLocked(framing.FrameText, []byte(m.Text))
+    }
+    p, err := delivery.AppendMessage(c.buf[:0], m)
+    if err != nil {
+        return err
+    }
+    c.buf = p
+    return c.writeLocked(framing.FrameText, p)
+}
+
+func (c *frameCodec) sendReceipt(r delivery.Receipt) error {
+    if !c.envelope {
+        return nil
+    }
+    p, err := delivery.AppendReceipt(nil, r)
+    if err != nil {
+        return err
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.writeLocked(framing.FrameControl, p)
+}
+
+func (c *frameCodec) writeLocked(t framing.FrameType, p []byte) error {
+    c.nextID++
+    return c.enc.Encode(framing.Frame{Type: t, ID: c.nextID, Payload: p})
+}
+
+func (c *frameCodec) feed(p []byte) ([]delivery.Message, []delivery.Receipt, error) {
+    frames, err := c.dec.Feed(p)
+    var (
+        msgs     []delivery.Message
+        receipts []delivery.Receipt
+    )
+    for _, f := range frames {
+        switch {
+        case f.Type == framing.FrameText && !c.envelope:
+            msgs = append(msgs, delivery.Message{Time: time.Now(), Text: string(f.Payload)})
+        case f.Type == framing.FrameText:
+            m, perr := delivery.ParseMessage(f.Payload)
+            if perr != nil {
+                log.Printf("receive: %v", perr)
+                continue
+            }
+            msgs = append(msgs, m)
+        case f.Type == framing.FrameControl && c.envelope:
+            r, perr := delivery.ParseReceipt(f.Payload)
+            if perr != nil {
+                log.Printf("receive: %v", perr)
+                continue
+            }
+            receipts = append(receipts, r)
+        default:
+            log.Printf("receive: ignored %s frame (%d bytes)", f.Type, len(f.Payload))
+        }
+    }
+    return msgs, receipts, err
+}
+
+func (c *frameCodec) receipts() bool { return c.envelope }
+
+// formatName describes the codec for the connection banner.
+func formatName(c codec) string {
+    switch c := c.(type) {
+    case *frameCodec:
+        if c.envelope {
+            return "binary frames with receipts"
+        }
+        return "binary frames"
+    }
+    return "text lines"
+}
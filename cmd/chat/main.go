@@ -0,0 +1,678 @@
+//go:build linux
+//
+// chat: 1-to-1 chat over Bluetooth RFCOMM (DESIGN.md layer D).
+//
+// Usage
+//   Server (registers the SPP service and waits for one peer):
+//     sudo go run ./cmd/chat -role=server -name=MyChatService
+//   Client (scans, lists "ServiceName <MAC>", connects to the chosen device):
+//     sudo go run ./cmd/chat -role=client
+//   Each stdin line is sent to the peer; received messages are printed as they arrive.
+//   End a line with '\' to continue the message on the next line.
+//   Pairing needs an agent (e.g. `bluetoothctl` with `agent on`) unless already paired.
+//   Both ends first exchange a hello (protocol version, -nick, capabilities, frame limit);
+//   a peer that does not answer like a chat client is disconnected. Messages travel as binary
+//   frames when both ends support them (multi-line messages stay whole), as text lines otherwise.
+//   With binary frames, each message also carries a unique ID and the time it was written, and
+//   the peer confirms it: "(delivered ...)" and, unless it runs with -read-receipts=false,
+//   "(read ...)" are printed under a message once confirmed, "(not delivered ...)" if no
+//   confirmation arrives within -ack-timeout. Unconfirmed messages are listed on exit.
+//   Messages are kept in an outbox per peer address (-outbox) until the peer confirms them
+//   (or, without receipts, until written). When the link drops, the chat keeps trying for
+//   -reconnect (a client connects again, backing off between attempts; a server accepts the
+//   next peer). Messages typed meanwhile are queued for the peer that was lost; when that
+//   device is connected again, now or in a later run, all its queued messages are sent first,
+//   in order. The peer shows a message sent again only once.
+//   With -e2e=on (the default) and a peer that supports it, the session is end-to-end encrypted:
+//   both ends prove their long-term identity (-identity, created on first run) and the peer's
+//   fingerprint is pinned to its address on first contact (-known-peers). Compare the printed
+//   fingerprints out of band (`chat -fingerprint` shows your own) to rule out a man in the middle.
+//   Once a peer is pinned, it is only talked to encrypted; -e2e=require refuses unencrypted
+//   sessions with any peer, -e2e=off never encrypts.
+//
+// Exit codes
+//   0  local end: stdin EOF (Ctrl-D) or Ctrl-C
+//   1  setup failed: server registration, scan, pairing or ConnectProfile error, no device found
+//   2  usage error (unknown -role, server without -name, invalid -nick or -e2e)
+//   3  connection lost and not re-established within -reconnect: peer closed the connection
+//      or an I/O error occurred
+//   4  incompatible or untrusted peer: not a chat client, no protocol version in common,
+//      an identity other than the one pinned for its address, or no end-to-end encryption
+//      where it is required (pinned peer or -e2e=require)
+//
+// On exit the profile is unregistered (UnregisterProfile) by closing the manager.
+//
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/delivery"
+    "bluetooth-chat/internal/framing"
+    "bluetooth-chat/internal/handshake"
+    "bluetooth-chat/internal/outbox"
+    "bluetooth-chat/internal/secure"
+    "bluetooth-chat/internal/transport"
+)
+
+const (
+    exitOK       = 0
+    exitSetup    = 1
+    exitUsage    = 2
+    exitPeerLost = 3
+    exitPeer     = 4
+)
+
+func main() {
+    os.Exit(run())
+}
+
+// run returns the exit code so deferred cleanup (profile unregistration) runs before os.Exit.
+func run() int {
+    role := flag.String("role", "", "server|client")
+    name := flag.String("name", "", "SPP service name (server, required)")
+    channel := flag.Uint("channel", uint(connmgr.DefaultRFCOMMChannel), "RFCOMM channel (server); 0 = let BlueZ choose")
+    uuid := flag.String("uuid", "", "service UUID; empty = SPP, \"chat\" = "+connmgr.ChatServiceUUID)
+    adapter := flag.String("adapter", "", "local adapter to use (hci name or address); empty = any")
+    nick := flag.String("nick", defaultNick(), "nickname shown to the peer")
+    scanTime := flag.Duration("scan", 15*time.Second, "scan duration (client)")
+    connectTimeout := flag.Duration("timeout", 60*time.Second, "pairing and connection timeout (client)")
+    ackTimeout := flag.Duration("ack-timeout", delivery.DefaultTimeout, "mark a sent message failed without a delivery receipt within this time")
+    readReceipts := flag.Bool("read-receipts", true, "tell the peer when its messages were shown")
+    reconnectWindow := flag.Duration("reconnect", 2*time.Minute, "how long to try re-establishing a lost connection; 0 = exit")
+    outboxDir := flag.String("outbox", configPath("outbox"), "directory keeping unconfirmed messages per peer; empty = none")
+    e2e := flag.String("e2e", e2eOn, "end-to-end encryption: on (when the peer supports it), require or off")
+    identityPath := flag.String("identity", configPath("identity.pem"), "long-term identity key (created if missing)")
+    knownPath := flag.String("known-peers", configPath("known_peers"), "peer fingerprints pinned per address")
+    showFingerprint := flag.Bool("fingerprint", false, "print the local identity fingerprint and exit")
+    flag.Parse()
+
+    if *uuid == "chat" {
+        *uuid = connmgr.ChatServiceUUID
+    }
+    if *channel > 255 {
+        log.Print("-channel out of range")
+        return exitUsage
+    }
+    if _, err := handshake.Encode(handshake.Hello{Nickname: *nick}); err != nil {
+        log.Printf("-nick: %v", err)
+        return exitUsage
+    }
+    if *e2e != e2eOn && *e2e != e2eRequire && *e2e != e2eOff {
+        log.Printf("-e2e must be on, require or off, got %q", *e2e)
+        return exitUsage
+    }
+
+    var (
+        id    *secure.Identity
+        known *secure.KnownPeers
+    )
+    if *e2e != e2eOff || *showFingerprint {
+        var (
+            created bool
+            err     error
+        )
+        if id, created, err = secure.LoadIdentity(*identityPath); err != nil {
+            log.Printf("identity: %v", err)
+            return exitSetup
+        }
+        if created {
+            fmt.Printf("created identity %s\n", *identityPath)
+        }
+        if *showFingerprint {
+            fmt.Println(id.Fingerprint())
+            return exitOK
+        }
+        if known, err = secure.OpenKnownPeers(*knownPath); err != nil {
+            log.Printf("known peers: %v", err)
+            return exitSetup
+        }
+    }
+
+    // Ctrl-C / SIGTERM cancel everything; the deferred Close still runs.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    m := connmgr.New()
+    defer func() {
+        if err := m.Close(); err != nil {
+            log.Printf("close: %v", err)
+        }
+    }()
+
+    stdin := bufio.NewReader(os.Stdin)
+    var (
+        fd   int
+        peer connmgr.Device
+        code int
+    )
+    switch *role {
+    case "server":
+        if *name == "" {
+            log.Print("-name is required for -role=server")
+            flag.Usage()
+            return exitUsage
+        }
+        fd, peer, code = serve(ctx, m, connmgr.ServerOptions{
+            ServiceName: *name,
+            Channel:     uint8(*channel),
+            UUID:        *uuid,
+            Adapter:     *adapter,
+            MaxPeers:    1,
+        })
+    case "client":
+        // SPP is BR/EDR only; skipping LE scanning finds classic devices sooner.
+        opts := connmgr.ScanOptions{Adapter: *adapter, UUID: *uuid, Transport: "bredr"}
+        fd, peer, code = dial(ctx, m, stdin, opts, *scanTime, *connectTimeout)
+    default:
+        log.Printf("-role must be server or client, got %q", *role)
+        flag.Usage()
+        return exitUsage
+    }
+    if code != exitOK || fd < 0 {
+        return code
+    }
+
+    hello := handshake.Hello{Nickname: *nick, Caps: []string{framing.BinaryCapability, delivery.Capability}}
+    if *e2e != e2eOff {
+        hello.Caps = append(hello.Caps, secure.Capability)
+    }
+    opts := chatOptions{
+        hello:      hello,
+        id:         id,
+        known:      known,
+        requireE2E: *e2e == e2eRequire,
+        outboxes:   openOutboxes(*outboxDir),
+        session:    sessionOptions{ackTimeout: *ackTimeout, readReceipts: *readReceipts},
+    }
+    defer opts.outboxes.close()
+    input := readMessages(stdin)
+    events := m.Events()
+    stopLink := func() {}
+    for {
+        code = converse(ctx, events, fd, peer, input, opts)
+        stopLink()
+        if code != exitPeerLost || *reconnectWindow <= 0 {
+            return code
+        }
+        if *role == "server" {
+            _ = m.Release(peer)
+        } else if events != nil {
+            // From here on a Reconnector holds the link with a client profile of its own,
+            // which the one registered by m would compete with.
+            if err := m.Close(); err != nil {
+                log.Printf("close: %v", err)
+            }
+            events = nil
+        }
+        fd, peer, stopLink, code = reconnect(ctx, m, *role == "server", peer, input, opts.outboxes, *reconnectWindow, *connectTimeout)
+        if code != exitOK || fd < 0 {
+            stopLink()
+            return code
+        }
+    }
+}
+
+// Values of -e2e.
+const (
+    e2eOn      = "on"
+    e2eRequire = "require"
+    e2eOff     = "off"
+)
+
+// chatOptions configures converse.
+type chatOptions struct {
+    hello      handshake.Hello
+    id         *secure.Identity   // nil with -e2e=off
+    known      *secure.KnownPeers // nil with -e2e=off
+    requireE2E bool               // refuse peers without end-to-end encryption
+    outboxes   *outboxes
+    session    sessionOptions // maxFrame is set per connection
+}
+
+// converse runs one connection: hello, optional key exchange, then the chat session, which
+// also ends on an EventDisconnected for peer from events (nil if there are none). It closes fd.
+func converse(ctx context.Context, events <-chan connmgr.Event, fd int, peer connmgr.Device, input *messages, opts chatOptions) int {
+    conn, err := transport.Open(fd)
+    if err != nil {
+        _ = syscall.Close(fd)
+        log.Printf("open connection: %v", err)
+        return exitSetup
+    }
+    defer conn.Close()
+    hs, err := handshake.Run(ctx, conn, opts.hello, 0)
+    if err != nil {
+        if ctx.Err() != nil {
+            return exitOK
+        }
+        switch {
+        case errors.Is(err, handshake.ErrNotChat):
+            log.Printf("%s is not a compatible chat client: %v", label(peer), err)
+            return exitPeer
+        case errors.Is(err, handshake.ErrVersion):
+            log.Printf("%s runs an incompatible chat version: %v", label(peer), err)
+            return exitPeer
+        }
+        log.Printf("handshake: %v", err)
+        return exitPeerLost
+    }
+    who := label(peer)
+    if hs.Peer.Nickname != "" {
+        who = hs.Peer.Nickname + " via " + who
+    }
+    var rw io.ReadWriter = conn
+    mode := "unencrypted"
+    if hs.Has(secure.Capability) {
+        sc, code := secureSession(ctx, conn, hs, opts.id, opts.known, peer)
+        if code != exitOK || sc == nil {
+            return code
+        }
+        rw, mode = sc, "encrypted"
+    } else if opts.id != nil {
+        // The hello is not authenticated: a peer that seems to have lost e2e support may be
+        // someone stripping the capability to read along.
+        if pin, ok := opts.known.Lookup(peer.MAC); ok {
+            log.Printf("WARNING: %s did not offer end-to-end encryption, but its identity %s is pinned", label(peer), pin)
+            log.Print("someone may be downgrading the connection; if the peer now runs without")
+            log.Print("-e2e, remove its line from the known peers file to talk to it unencrypted")
+            return exitPeer
+        }
+        if opts.requireE2E {
+            log.Printf("%s does not support end-to-end encryption, which -e2e=require demands", label(peer))
+            return exitPeer
+        }
+        log.Printf("%s does not support end-to-end encryption", label(peer))
+    }
+    c := newCodec(rw, hs)
+    fmt.Printf("connected to %s (%s, %s); type messages, Ctrl-D or Ctrl-C to quit\n", who, formatName(c), mode)
+    sopts := opts.session
+    sopts.maxFrame = hs.MaxFrame
+    sopts.peerPath = peer.Path
+    return session(ctx, rw, input, events, c, opts.outboxes.peer(peer.MAC), sopts)
+}
+
+// secureSession runs the key exchange, authenticating the hellos of hs with it, and checks the
+// peer's identity against the one pinned for its address. The session is nil if ctx ended.
+func secureSession(ctx context.Context, conn *transport.Conn, hs handshake.Result, id *secure.Identity, known *secure.KnownPeers, peer connmgr.Device) (*secure.Conn, int) {
+    sc, err := secure.Handshake(ctx, conn, id, []byte(hs.LocalLine), []byte(hs.PeerLine), 0)
+    if err != nil {
+        if ctx.Err() != nil {
+            return nil, exitOK
+        }
+        if errors.Is(err, secure.ErrHandshake) {
+            log.Printf("%s: %v", label(peer), err)
+            return nil, exitPeer
+        }
+        log.Printf("key exchange: %v", err)
+        return nil, exitPeerLost
+    }
+    fp := sc.PeerFingerprint()
+    pinned, err := known.Check(peer.MAC, fp)
+    switch {
+    case errors.Is(err, secure.ErrKeyChanged):
+        pin, _ := known.Lookup(peer.MAC)
+        log.Printf("WARNING: %s presented a different identity than before", label(peer))
+        log.Printf("  pinned:    %s", pin)
+        log.Printf("  presented: %s", fp)
+        log.Print("someone may be impersonating the peer; if it was reinstalled, verify the new")
+        log.Print("fingerprint out of band and remove its line from the known peers file")
+        return nil, exitPeer
+    case err != nil:
+        // Still safe to talk; only the pin could not be stored.
+        fmt.Printf("peer identity %s (not pinned: %v)\n", fp, err)
+    case pinned:
+        fmt.Printf("new peer identity %s pinned; verify it out of band\n", fp)
+    default:
+        fmt.Printf("peer identity %s (matches pinned)\n", fp)
+    }
+    fmt.Printf("your identity     %s\n", id.Fingerprint())
+    return sc, exitOK
+}
+
+// configPath returns name under the user's configuration directory for this tool.
+func configPath(name string) string {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        dir = "."
+    }
+    return filepath.Join(dir, "bluetooth-chat", name)
+}
+
+// defaultNick is the login name (of the sudo caller, if any), or the host name.
+func defaultNick() string {
+    for _, env := range []string{"SUDO_USER", "USER"} {
+        if u := os.Getenv(env); u != "" {
+            return u
+        }
+    }
+    h, _ := os.Hostname()
+    return h
+}
+
+// serve registers the server profile and waits for the first peer. Further connections are
+// rejected by the manager (MaxPeers 1), keeping the existing one. fd is -1 if none arrived.
+func serve(ctx context.Context, m connmgr.Mgr, opts connmgr.ServerOptions) (int, connmgr.Device, int) {
+    if err := m.StartServer(ctx, opts); err != nil {
+        log.Printf("start server: %v", err)
+        return -1, connmgr.Device{}, exitSetup
+    }
+    ch := "auto"
+    if opts.Channel != 0 {
+        ch = strconv.Itoa(int(opts.Channel))
+    }
+    fmt.Printf("service %q registered on channel %s; waiting for a peer...\n", opts.ServiceName, ch)
+    fd, peer, err := m.Accept(ctx)
+    if err != nil {
+        if ctx.Err() != nil {
+            return -1, connmgr.Device{}, exitOK
+        }
+        log.Printf("accept: %v", err)
+        return -1, connmgr.Device{}, exitSetup
+    }
+    return fd, peer, exitOK
+}
+
+// dial scans, lets the user pick a device and connects to it. fd is -1 if the user quit.
+func dial(ctx context.Context, m connmgr.Mgr, stdin *bufio.Reader, opts connmgr.ScanOptions, scanTime, timeout time.Duration) (int, connmgr.Device, int) {
+    fmt.Printf("scanning for %s...\n", scanTime)
+    scanCtx, cancel := context.WithTimeout(ctx, scanTime)
+    devs, err := m.ScanSPP(scanCtx, opts)
+    cancel()
+    if ctx.Err() != nil {
+        return -1, connmgr.Device{}, exitOK
+    }
+    if err != nil {
+        log.Printf("scan: %v", err)
+        return -1, connmgr.Device{}, exitSetup
+    }
+    if len(devs) == 0 {
+        log.Print("no SPP devices found")
+        return -1, connmgr.Device{}, exitSetup
+    }
+    for i, d := range devs {
+        fmt.Printf("[%d] %s\n", i, label(d))
+    }
+    dev, ok := choose(stdin, devs)
+    if !ok {
+        return -1, connmgr.Device{}, exitOK
+    }
+
+    fmt.Printf("connecting to %s...\n", label(dev))
+    connCtx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    fd, err := m.Connect(connCtx, dev)
+    if err != nil {
+        if ctx.Err() != nil {
+            return -1, connmgr.Device{}, exitOK
+        }
+        log.Printf("connect: %v", err)
+        return -1, connmgr.Device{}, exitSetup
+    }
+    return fd, dev, exitOK
+}
+
+// choose reads a device index from stdin; ok is false on EOF.
+func choose(stdin *bufio.Reader, devs []connmgr.Device) (connmgr.Device, bool) {
+    for {
+        fmt.Printf("choose 0..%d: ", len(devs)-1)
+        line, err := stdin.ReadString('\n')
+        if i, convErr := strconv.Atoi(strings.TrimSpace(line)); convErr == nil && i >= 0 && i < len(devs) {
+            return devs[i], true
+        }
+        if err != nil {
+            return connmgr.Device{}, false
+        }
+    }
+}
+
+// sessionOptions configures session.
+type sessionOptions struct {
+    peerPath     string        // D-Bus path of the peer; manager events for others are ignored
+    maxFrame     int           // largest message payload the peer accepts
+    ackTimeout   time.Duration // delivery receipt timeout for sent messages
+    readReceipts bool          // tell the peer when its messages were shown
+}
+
+// session runs the full-duplex chat until input ends, ctx is canceled or the peer goes away
+// (EOF, I/O error, or BlueZ requesting the disconnection). With receipts, delivery state
+// changes of sent messages are printed as they happen and messages still unconfirmed at the
+// end are listed.
+//
+// With an outbox, every message is stored before it is sent and removed once the peer
+// confirms it (or once written, if the peer sends no receipts); messages left over from
+// earlier connections are sent first, in order. Messages the peer sends again are
+// acknowledged but not shown twice.
+//
+// A message taken from input but not sent because the session ended is put back, for the
+// reconnection or the next session.
+func session(ctx context.Context, conn io.Reader, input *messages, events <-chan connmgr.Event, c codec, ob *outbox.Outbox, opts sessionOptions) int {
+    tracker := delivery.NewTracker(opts.ackTimeout, func(u delivery.Update) {
+        if ob != nil && (u.State == delivery.StateDelivered || u.State == delivery.StateRead) {
+            if err := ob.Ack(u.ID); err != nil {
+                log.Printf("outbox: %v", err)
+            }
+        }
+        printUpdate(u)
+    })
+    defer func() {
+        if pending := tracker.Close(); len(pending) > 0 {
+            where := "they are kept in the outbox"
+            if ob == nil {
+                where = "they were not delivered"
+            }
+            log.Printf("%d message(s) not confirmed by the peer; %s:", len(pending), where)
+            for _, m := range pending {
+                log.Printf("  [%s] %s", m.Time.Format(time.TimeOnly), preview(m.Text))
+            }
+        }
+    }()
+
+    peerDone := make(chan error, 1)
+    go func() {
+        buf := make([]byte, 4096)
+        for {
+            n, err := conn.Read(buf)
+            msgs, receipts, ferr := c.feed(buf[:n])
+            for _, m := range msgs {
+                if m.ID != "" {
+                    ack(c, delivery.Delivered, m.ID)
+                    if ob != nil {
+                        if dup, oerr := ob.Seen(m.ID); oerr != nil {
+                            log.Printf("outbox: %v", oerr)
+                        } else if dup {
+                            continue
+                        }
+                    }
+                }
+                fmt.Printf("[%s] peer> %s\n", m.Time.Format(time.TimeOnly), strings.ReplaceAll(m.Text, "\n", "\n                 "))
+                if m.ID != "" && opts.readReceipts {
+                    ack(c, delivery.Read, m.ID)
+                }
+            }
+            for _, r := range receipts {
+                tracker.Receipt(r)
+            }
+            if ferr != nil {
+                log.Printf("receive: %v (dropped)", ferr)
+            }
+            if err != nil {
+                peerDone <- err
+                return
+            }
+        }
+    }()
+
+    limit := opts.maxFrame
+    if c.receipts() {
+        limit -= delivery.MessageOverhead
+    }
+    // send writes m; it was stored in the outbox (if any) before. The tracker learns of m
+    // first: the peer's receipt may be processed before c.send returns.
+    send := func(m delivery.Message) error {
+        if c.receipts() {
+            tracker.Sent(m)
+        }
+        if err := c.send(m); err != nil {
+            tracker.Unsent(m.ID)
+            return err
+        }
+        if !c.receipts() && ob != nil {
+            if err := ob.Ack(m.ID); err != nil {
+                log.Printf("outbox: %v", err)
+            }
+        }
+        return nil
+    }
+    quit := make(chan struct{})
+    defer close(quit)
+    localDone := make(chan error, 1)
+    go func() {
+        if ob != nil {
+            if pending := ob.Pending(); len(pending) > 0 {
+                fmt.Printf("sending %d queued message(s)\n", len(pending))
+                for _, m := range pending {
+                    if len(m.Text) > limit {
+                        log.Printf("queued message too long for this peer (%d bytes, accepts %d); dropped: %s", len(m.Text), limit, preview(m.Text))
+                        _ = ob.Ack(m.ID)
+                        continue
+                    }
+                    if err := send(m); err != nil {
+                        localDone <- err
+                        return
+                    }
+                }
+            }
+        }
+        for {
+            var (
+                text string
+                ok   bool
+            )
+            select {
+            case <-quit:
+                return
+            case text = <-input.back:
+                ok = true
+            case text, ok = <-input.typed:
+            }
+            if !ok {
+                localDone <- nil // stdin EOF
+                return
+            }
+            // Both may have been ready: a message for a session that has ended goes back.
+            select {
+            case <-quit:
+                input.putBack(text)
+                return
+            default:
+            }
+            m := delivery.NewMessage(text)
+            if len(m.Text) > limit {
+                log.Printf("message too long (%d bytes, peer accepts %d); not sent", len(m.Text), limit)
+                continue
+            }
+            stored := false
+            if ob != nil {
+                if err := ob.Add(m); err != nil {
+                    log.Printf("outbox: %v", err)
+                } else {
+                    stored = true
+                }
+            }
+            if err := send(m); err != nil {
+                if !stored {
+                    input.putBack(text)
+                }
+                localDone <- err
+                return
+            }
+        }
+    }()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return exitOK
+        case ev, ok := <-events:
+            if !ok {
+                events = nil
+            } else if ev.Type == connmgr.EventDisconnected && ev.Device.Path == opts.peerPath {
+                log.Print("disconnect requested by BlueZ")
+                return exitPeerLost
+            }
+        case err := <-peerDone:
+            if errors.Is(err, io.EOF) {
+                log.Print("peer disconnected")
+            } else {
+                log.Printf("connection error: %v", err)
+            }
+            return exitPeerLost
+        case err := <-localDone:
+            if err != nil {
+                log.Printf("send: %v", err)
+                return exitPeerLost
+            }
+            return exitOK
+        }
+    }
+}
+
+// ack sends a receipt for the peer's message id. A failed write also ends the read loop, so
+// the error is only logged.
+func ack(c codec, kind delivery.Kind, id string) {
+    if err := c.sendReceipt(delivery.Receipt{Kind: kind, ID: id, Time: time.Now()}); err != nil {
+        log.Printf("send %s receipt: %v", kind, err)
+    }
+}
+
+// printUpdate shows the delivery state of a sent message once the peer confirmed it (or did
+// not in time); that it was sent is implied by the prompt returning.
+func printUpdate(u delivery.Update) {
+    switch u.State {
+    case delivery.StateDelivered, delivery.StateRead:
+        fmt.Printf("  (%s %s: %s)\n", u.State, u.At.Format(time.TimeOnly), preview(u.Text))
+    case delivery.StateFailed:
+        fmt.Printf("  (not delivered within the timeout: %s)\n", preview(u.Text))
+    }
+}
+
+// preview shortens a message to its first line, at most 32 characters.
+func preview(text string) string {
+    line, _, more := strings.Cut(text, "\n")
+    if r := []rune(line); len(r) > 32 {
+        line, more = string(r[:32]), true
+    }
+    if more {
+        line += "..."
+    }
+    return strconv.Quote(line)
+}
+
+// label renders "ServiceName <MAC>", falling back to Alias/Name (DESIGN.md 2.D).
+func label(d connmgr.Device) string {
+    name := d.ServiceName
+    if name == "" {
+        name = d.Alias
+    }
+    if name == "" {
+        name = d.Name
+    }
+    if name == "" {
+        return d.MAC
+    }
+    return name + " <" + d.MAC + ">"
+}
@@ -0,0 +1,240 @@
+//go:build linux
+
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "syscall"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/delivery"
+    "bluetooth-chat/internal/outbox"
+)
+
+// messages delivers the messages typed on stdin. It outlives single connections, so nothing
+// typed while reconnecting is lost.
+type messages struct {
+    typed <-chan string // closed at stdin EOF
+    back  chan string   // a message taken by a session that ended before sending it
+}
+
+// readMessages reads stdin, joining lines continued with a trailing '\'.
+func readMessages(stdin *bufio.Reader) *messages {
+    ch := make(chan string)
+    go func() {
+        defer close(ch)
+        var pending []string // earlier lines of a message continued with a trailing '\'
+        for {
+            line, err := stdin.ReadString('\n')
+            text := strings.TrimRight(line, "\r\n")
+            if err == nil && strings.HasSuffix(text, `\`) {
+                pending = append(pending, strings.TrimSuffix(text, `\`))
+                continue
+            }
+            if line != "" || len(pending) > 0 {
+                ch <- strings.Join(append(pending, text), "\n")
+                pending = nil
+            }
+            if err != nil {
+                return
+            }
+        }
+    }()
+    return &messages{typed: ch, back: make(chan string, 1)}
+}
+
+// putBack returns a message that was taken but not sent, for whoever reads next. Only one
+// message can be waiting; the session that held it has ended, so there is no second.
+func (in *messages) putBack(text string) {
+    select {
+    case in.back <- text:
+    default:
+        log.Printf("message not sent: %s", preview(text))
+    }
+}
+
+// reconnect waits up to window for a new connection after the link to peer dropped. A server
+// accepts the next peer on m, whichever device it is. A client connects to peer again through
+// a connmgr.Reconnector, which backs off between attempts and manages the connection it
+// returns; the caller calls stop once that connection is closed (stop is also safe to call
+// when none was obtained). Messages typed meanwhile are queued in the outbox of peer, and
+// sent when that device is connected again. fd is -1 if no connection was obtained.
+func reconnect(ctx context.Context, m connmgr.Mgr, server bool, peer connmgr.Device, in *messages, obs *outboxes, window, connectTimeout time.Duration) (fd int, dev connmgr.Device, stop func(), code int) {
+    ob := obs.peer(peer.MAC)
+    note, held := "", ""
+    if ob != nil {
+        if server {
+            // The next peer may be another device; the queue stays with this one.
+            held = " for " + label(peer)
+        }
+        note = " (messages are queued" + held + ")"
+    }
+    if server {
+        fmt.Printf("connection lost; waiting up to %s for a peer%s\n", window, note)
+    } else {
+        fmt.Printf("connection lost; reconnecting for up to %s%s\n", window, note)
+    }
+    rctx, cancel := context.WithCancel(ctx)
+    timer := time.NewTimer(window)
+    defer timer.Stop()
+
+    type result struct {
+        fd  int
+        dev connmgr.Device
+        err error
+    }
+    var (
+        accepted chan result
+        states   <-chan connmgr.ReconnectEvent
+        events   <-chan connmgr.Event
+        lastErr  error
+    )
+    if server {
+        accepted = make(chan result, 1)
+        go func() {
+            fd, dev, err := m.Accept(rctx)
+            accepted <- result{fd, dev, err}
+        }()
+        // Events still coming for the lost connection (e.g. its EventDisconnected) are
+        // consumed here, so they cannot end the next session with the same device.
+        events = m.Events()
+    } else {
+        r := connmgr.NewReconnector(peer, connmgr.ReconnectOptions{AttemptTimeout: connectTimeout})
+        states = r.Run(rctx)
+    }
+    // giveUp stops waiting; a connection accepted in the meantime is closed.
+    giveUp := func(code int) (int, connmgr.Device, func(), int) {
+        cancel()
+        if accepted != nil {
+            if r := <-accepted; r.err == nil {
+                _ = syscall.Close(r.fd)
+                _ = m.Release(r.dev)
+            }
+        }
+        return -1, peer, cancel, code
+    }
+
+    queue := func(text string) {
+        if ob == nil {
+            log.Printf("not connected; message not sent: %s", preview(text))
+            return
+        }
+        if err := ob.Add(delivery.NewMessage(text)); err != nil {
+            log.Printf("outbox: %v", err)
+            return
+        }
+        fmt.Printf("  (queued%s)\n", held)
+    }
+    for {
+        select {
+        case r := <-accepted:
+            accepted = nil
+            if r.err != nil {
+                if ctx.Err() != nil {
+                    return giveUp(exitOK)
+                }
+                log.Printf("accept: %v", r.err)
+                return giveUp(exitPeerLost)
+            }
+            if ob != nil && r.dev.MAC != peer.MAC {
+                if n := len(ob.Pending()); n > 0 {
+                    fmt.Printf("%d queued message(s) stay held for %s until it connects again\n", n, label(peer))
+                }
+            }
+            return r.fd, r.dev, cancel, exitOK
+        case st, ok := <-states:
+            if !ok {
+                // Run ended with ctx.
+                return giveUp(exitOK)
+            }
+            switch st.State {
+            case connmgr.StateConnected:
+                return st.FD, peer, cancel, exitOK
+            case connmgr.StateBackoff:
+                lastErr = st.Err
+                log.Printf("reconnect: %v; retrying in %s", st.Err, st.Delay.Round(100*time.Millisecond))
+            case connmgr.StateGaveUp:
+                log.Printf("reconnect: %v", st.Err)
+                return giveUp(exitPeerLost)
+            }
+        case <-timer.C:
+            if lastErr != nil {
+                log.Printf("could not reconnect within %s: %v", window, lastErr)
+            } else {
+                log.Printf("could not reconnect within %s", window)
+            }
+            return giveUp(exitPeerLost)
+        case <-ctx.Done():
+            return giveUp(exitOK)
+        case _, ok := <-events:
+            if !ok {
+                events = nil
+            }
+        case text := <-in.back:
+            queue(text)
+        case text, ok := <-in.typed:
+            if !ok {
+                // stdin EOF: stop trying, keeping the queued messages for the next run.
+                select {
+                case text := <-in.back:
+                    queue(text)
+                default:
+                }
+                return giveUp(exitOK)
+            }
+            queue(text)
+        }
+    }
+}
+
+// outboxes keeps the outbox of each peer open for the whole run.
+type outboxes struct {
+    store *outbox.Store
+    open  map[string]*outbox.Outbox
+}
+
+// openOutboxes returns the outboxes in dir; with an empty dir (or if it cannot be used) no
+// messages are kept.
+func openOutboxes(dir string) *outboxes {
+    o := &outboxes{open: make(map[string]*outbox.Outbox)}
+    if dir == "" {
+        return o
+    }
+    store, err := outbox.OpenStore(dir)
+    if err != nil {
+        log.Printf("%v; unconfirmed messages will not be kept", err)
+        return o
+    }
+    o.store = store
+    return o
+}
+
+// peer returns the outbox of the peer with the given address, or nil if there is none.
+func (o *outboxes) peer(mac string) *outbox.Outbox {
+    if o.store == nil || mac == "" {
+        return nil
+    }
+    if ob, ok := o.open[mac]; ok {
+        return ob
+    }
+    ob, err := o.store.Peer(mac)
+    if err != nil {
+        log.Printf("%v; unconfirmed messages to %s will not be kept", err, mac)
+        return nil
+    }
+    o.open[mac] = ob
+    return ob
+}
+
+func (o *outboxes) close() {
+    for _, ob := range o.open {
+        if err := ob.Close(); err != nil {
+            log.Printf("%v", err)
+        }
+    }
+}
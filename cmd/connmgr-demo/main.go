@@ -16,17 +16,45 @@
 //     go run ./cmd/connmgr-demo -mode=start -name MyChatService -timeout=60s
 //   Verify in another terminal:
 //     sdptool browse local          (see Serial Port: Service Name=MyChatService, Channel=22)
+//   Profile options: -channel (0 = let BlueZ choose), -psm, -auth, -authz, -autoconnect,
+//   -uuid (custom service UUID) and -record (file with a complete SDP record in BlueZ XML).
+//   -uuid=chat registers connmgr.ChatServiceUUID (still advertised as SPP too); pass the same
+//   -uuid=chat to scan/watch/connect so only chat servers are listed.
+//   Two services on one host need distinct channels, e.g. -channel=23 for the second.
 //     dbus-monitor --system "type='method_call',interface='org.bluez.ProfileManager1',member='RegisterProfile'"
 //
-// 2) Accept one connection (server):
-//     sudo go run ./cmd/connmgr-demo -mode=server -name MyChatService -timeout=120s
-//   Then connect from another device (client) to SPP service "MyChatService"; observe:
+// 2) Accept connections (server):
+//     sudo go run ./cmd/connmgr-demo -mode=server -name MyChatService -peers=2 -timeout=120s
+//   Then connect from other devices (clients) to SPP service "MyChatService"; observe:
 //     dbus-monitor --system "type='method_call',interface='org.bluez.Profile1',member='NewConnection'"
-//   The CLI prints the accepted FD and peer info.
+//   The CLI prints each accepted FD and peer info until the timeout. Accepted FDs are kept open,
+//   so connections beyond -peers are rejected by the manager.
+//   Restrict who may connect with -allow/-deny (comma-separated MACs) or -trusted-only:
+//     sudo go run ./cmd/connmgr-demo -mode=server -name MyChatService -allow AA:BB:CC:DD:EE:FF
+//   -peer-name queries each peer's own SDP ServiceName before printing it (delays the accept).
 //
 // 3) Scan for SPP devices:
 //     go run ./cmd/connmgr-demo -mode=scan -timeout=15s
-//   Lists devices with Path/MAC/Name/Alias (Path is always non-empty).
+//   Lists devices with Path/MAC/Name/Alias (Path is always non-empty), plus the SPP ServiceName
+//   and RFCOMM channel read from each device's SDP record when it answered during the scan.
+//   Results are sorted by RSSI. Narrow discovery with -transport (auto|bredr|le), -min-rssi or
+//   -pathloss, -pattern (address or name prefix), -duplicates and -max, e.g.:
+//     go run ./cmd/connmgr-demo -mode=scan -transport=bredr -min-rssi=-75 -max=5
+//
+// 3b) Watch SPP devices live (added/updated/removed as they happen):
+//     go run ./cmd/connmgr-demo -mode=watch -timeout=60s
+//
+// 3c) Adapters: list local adapters with Powered/Discoverable/Pairable state, or change them:
+//     go run ./cmd/connmgr-demo -mode=adapters
+//     sudo go run ./cmd/connmgr-demo -mode=adapters -adapter hci1 -power=on -discoverable=180s -pairable=on
+//   -discoverable takes a duration (0s = no timeout) or "off". Use -adapter (hci name or address)
+//   with scan/watch/server/connect to pin a specific radio, e.g. a USB dongle next to a built-in one.
+//
+// 3d) Known devices: list devices BlueZ remembers (no discovery), or manage one of them:
+//     go run ./cmd/connmgr-demo -mode=devices                 (SPP devices; -all for any, -paired for paired only)
+//     go run ./cmd/connmgr-demo -mode=devices -device /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX -trusted=on -blocked=off
+//     go run ./cmd/connmgr-demo -mode=devices -device /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX -remove
+//   Trusted devices are accepted by -trusted-only servers; -remove also deletes the pairing keys.
 //
 // 4) Connect to a device (client):
 //   a) Interactive (scan then choose):
@@ -34,9 +62,19 @@
 //   b) Direct by object path:
 //       sudo go run ./cmd/connmgr-demo -mode=connect -device /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX -timeout=120s
 //   If not paired, an Agent must be registered; pairing is attempted automatically.
+//   c) With the built-in agent prompting on this terminal (no bluetoothctl agent needed):
+//       sudo go run ./cmd/connmgr-demo -mode=connect -agent=KeyboardDisplay -timeout=120s
+//   -agent also works in server mode to confirm incoming pairing requests.
+//
+// 5) Keep a client connection alive (reconnect with backoff):
+//     sudo go run ./cmd/connmgr-demo -mode=reconnect -device /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX -timeout=10m
+//   Prints state events (connecting/connected/disconnected/backoff/gave-up). Turn the peer's
+//   adapter off and on to observe a reconnect.
 //
 // Notes
 // - Exit/Ctrl‑C cancels via context.
+// - In server mode, a peer's slot is freed with Mgr.Release once its FD is closed; the demo never
+//   releases, so it accepts at most -peers connections.
 // - The printed FD is owned by the caller; wrap with os.NewFile and close yourself.
 // - WSL is generally unsupported unless you pass through a USB BT adapter and run bluetoothd in WSL2.
 //
@@ -52,6 +90,7 @@ import (
     "os/signal"
     "strconv"
     "strings"
+    "sync"
     "syscall"
     "time"
 
@@ -59,9 +98,37 @@ import (
 )
 
 func main() {
-    mode := flag.String("mode", "scan", "mode: scan|start|server|connect")
+    mode := flag.String("mode", "scan", "mode: scan|watch|adapters|devices|start|server|connect|reconnect")
     name := flag.String("name", "MyChatService", "SPP service name (server mode)")
+    peers := flag.Int("peers", connmgr.DefaultMaxPeers, "maximum concurrent peers (server mode)")
     devPath := flag.String("device", "", "Device object path to connect (connect mode). If empty, scan and prompt.")
+    channel := flag.Uint("channel", uint(connmgr.DefaultRFCOMMChannel), "RFCOMM channel (server modes); 0 = let BlueZ choose")
+    psm := flag.Uint("psm", 0, "optional L2CAP PSM (server modes)")
+    auth := flag.Bool("auth", false, "RequireAuthentication (server modes)")
+    authz := flag.Bool("authz", false, "RequireAuthorization (server modes)")
+    autoConnect := flag.Bool("autoconnect", false, "AutoConnect (server modes)")
+    uuid := flag.String("uuid", "", "service UUID to register (server modes) or scan for (scan/watch/connect); empty = SPP, \"chat\" = "+connmgr.ChatServiceUUID)
+    recordFile := flag.String("record", "", "file with a custom SDP ServiceRecord XML (server modes)")
+    adapter := flag.String("adapter", "", "local adapter to use (hci name or address); empty = any")
+    power := flag.String("power", "", "adapters mode: on|off")
+    discoverable := flag.String("discoverable", "", "adapters mode: duration (0s = no timeout) or off")
+    pairable := flag.String("pairable", "", "adapters mode: on|off")
+    allow := flag.String("allow", "", "comma-separated MACs allowed to connect (server mode)")
+    deny := flag.String("deny", "", "comma-separated MACs refused (server mode)")
+    trustedOnly := flag.Bool("trusted-only", false, "accept only paired and trusted devices (server mode)")
+    peerName := flag.Bool("peer-name", false, "look up the SDP ServiceName of accepted peers (server mode)")
+    trusted := flag.String("trusted", "", "devices mode: on|off")
+    blocked := flag.String("blocked", "", "devices mode: on|off")
+    remove := flag.Bool("remove", false, "devices mode: remove -device from BlueZ")
+    all := flag.Bool("all", false, "devices mode: list devices with any service, not only -uuid/SPP")
+    pairedOnly := flag.Bool("paired", false, "devices mode: list paired devices only")
+    transport := flag.String("transport", "", "scan/watch: discovery transport auto|bredr|le")
+    minRSSI := flag.Int("min-rssi", 0, "scan/watch: drop devices weaker than this RSSI in dBm (e.g. -80); 0 = off")
+    pathloss := flag.Uint("pathloss", 0, "scan/watch: drop devices with a higher path loss in dB; 0 = off")
+    duplicates := flag.Bool("duplicates", false, "scan/watch: report repeated discovery data (fresher RSSI)")
+    pattern := flag.String("pattern", "", "scan/watch: address or name prefix")
+    maxResults := flag.Int("max", 0, "scan: keep only the N strongest devices; 0 = all")
+    agentCap := flag.String("agent", "", "register a pairing agent with this capability: NoInputNoOutput|DisplayYesNo|KeyboardDisplay")
     timeout := flag.Duration("timeout", 15*time.Second, "operation timeout")
     flag.Parse()
 
@@ -82,22 +149,79 @@ func main() {
         }
     }()
 
+    if *agentCap != "" {
+        if err := m.RegisterAgent(ctx, terminalAgent(connmgr.AgentCapability(*agentCap))); err != nil {
+            log.Fatalf("RegisterAgent error: %v", err)
+        }
+        log.Printf("pairing agent registered: Capability=%s", *agentCap)
+    }
+
+    if *uuid == "chat" {
+        *uuid = connmgr.ChatServiceUUID
+    }
+    scanOpts := connmgr.ScanOptions{
+        Adapter:       *adapter,
+        UUID:          *uuid,
+        Transport:     *transport,
+        MinRSSI:       int16(*minRSSI),
+        MaxPathloss:   uint16(*pathloss),
+        DuplicateData: *duplicates,
+        NamePattern:   *pattern,
+        MaxResults:    *maxResults,
+    }
+    if *channel > 255 || *psm > 0xffff {
+        log.Fatalf("-channel/-psm out of range")
+    }
+    if *minRSSI < -128 || *minRSSI > 0 || *pathloss > 0xffff {
+        log.Fatalf("-min-rssi/-pathloss out of range")
+    }
+    srvOpts := connmgr.ServerOptions{
+        ServiceName:           *name,
+        MaxPeers:              *peers,
+        Adapter:               *adapter,
+        Channel:               uint8(*channel),
+        PSM:                   uint16(*psm),
+        RequireAuthentication: *auth,
+        RequireAuthorization:  *authz,
+        AutoConnect:           *autoConnect,
+        UUID:                  *uuid,
+        AllowMACs:             splitList(*allow),
+        DenyMACs:              splitList(*deny),
+        TrustedOnly:           *trustedOnly,
+        LookupServiceName:     *peerName,
+    }
+    if *recordFile != "" {
+        b, err := os.ReadFile(*recordFile)
+        if err != nil {
+            log.Fatalf("-record: %v", err)
+        }
+        srvOpts.ServiceRecord = string(b)
+    }
     switch strings.ToLower(*mode) {
     case "scan":
-        runScan(ctx, m)
+        runScan(ctx, m, scanOpts)
+    case "watch":
+        runWatch(ctx, m, scanOpts)
+    case "adapters":
+        runAdapters(ctx, m, *adapter, *power, *discoverable, *pairable)
     case "start", "startserver":
-        runStartServer(ctx, m, *name)
+        runStartServer(ctx, m, srvOpts)
     case "server":
-        runServer(ctx, m, *name)
+        runServer(ctx, m, srvOpts)
+    case "devices":
+        listOpts := connmgr.DeviceListOptions{Adapter: *adapter, UUID: *uuid, AllServices: *all, PairedOnly: *pairedOnly}
+        runDevices(ctx, m, *devPath, listOpts, *trusted, *blocked, *remove)
     case "connect":
-        runConnect(ctx, m, *devPath)
+        runConnect(ctx, m, *devPath, scanOpts)
+    case "reconnect":
+        runReconnect(ctx, *devPath)
     default:
         log.Fatalf("unknown mode: %s", *mode)
     }
 }
 
-func runScan(ctx context.Context, m connmgr.Mgr) {
-    devs, err := m.ScanSPP(ctx)
+func runScan(ctx context.Context, m connmgr.Mgr, opts connmgr.ScanOptions) {
+    devs, err := m.ScanSPP(ctx, opts)
     if err != nil {
         log.Fatalf("ScanSPP error: %v", err)
     }
@@ -106,18 +230,132 @@ func runScan(ctx context.Context, m connmgr.Mgr) {
         return
     }
     for i, d := range devs {
-        fmt.Printf("[%d] Path=%s MAC=%s Name=%s Alias=%s\n", i, d.Path, d.MAC, d.Name, d.Alias)
+        fmt.Printf("[%d] %s Path=%s MAC=%s Name=%s Alias=%s RSSI=%d Class=0x%06x Icon=%s Paired=%t Connected=%t Channel=%d LastSeen=%s\n",
+            i, displayName(d), d.Path, d.MAC, d.Name, d.Alias, d.RSSI, d.Class, d.Icon, d.Paired, d.Connected, d.Channel, lastSeenStr(d.LastSeen))
+    }
+}
+
+func runWatch(ctx context.Context, m connmgr.Mgr, opts connmgr.ScanOptions) {
+    events, err := m.WatchSPP(ctx, opts)
+    if err != nil {
+        log.Fatalf("WatchSPP error: %v", err)
+    }
+    for ev := range events {
+        d := ev.Device
+        fmt.Printf("%-7s %s Path=%s MAC=%s RSSI=%d Channel=%d\n", ev.Type, displayName(d), d.Path, d.MAC, d.RSSI, d.Channel)
+    }
+}
+
+func runAdapters(ctx context.Context, m connmgr.Mgr, adapter, power, discoverable, pairable string) {
+    if power != "" || discoverable != "" || pairable != "" {
+        if adapter == "" {
+            log.Fatal("-adapter is required to change adapter state")
+        }
+        if power != "" {
+            if err := m.SetAdapterPowered(ctx, adapter, parseOnOff("-power", power)); err != nil {
+                log.Fatalf("SetAdapterPowered error: %v", err)
+            }
+        }
+        if pairable != "" {
+            if err := m.SetAdapterPairable(ctx, adapter, parseOnOff("-pairable", pairable)); err != nil {
+                log.Fatalf("SetAdapterPairable error: %v", err)
+            }
+        }
+        if discoverable != "" {
+            on, timeout := false, time.Duration(0)
+            if discoverable != "off" {
+                d, err := time.ParseDuration(discoverable)
+                if err != nil {
+                    log.Fatalf("-discoverable: %v", err)
+                }
+                on, timeout = true, d
+            }
+            if err := m.SetAdapterDiscoverable(ctx, adapter, on, timeout); err != nil {
+                log.Fatalf("SetAdapterDiscoverable error: %v", err)
+            }
+        }
+    }
+    adapters, err := m.Adapters(ctx)
+    if err != nil {
+        log.Fatalf("Adapters error: %v", err)
+    }
+    if len(adapters) == 0 {
+        fmt.Println("no adapters found")
+        return
+    }
+    for _, a := range adapters {
+        fmt.Printf("%s Address=%s Alias=%s Powered=%t Discoverable=%t DiscoverableTimeout=%s Pairable=%t Discovering=%t\n",
+            a.Name, a.Address, a.Alias, a.Powered, a.Discoverable, a.DiscoverableTimeout, a.Pairable, a.Discovering)
+    }
+}
+
+func runDevices(ctx context.Context, m connmgr.Mgr, path string, opts connmgr.DeviceListOptions, trusted, blocked string, remove bool) {
+    if trusted != "" || blocked != "" || remove {
+        if path == "" {
+            log.Fatal("-device is required to change a device")
+        }
+        dev := connmgr.Device{Path: path}
+        if trusted != "" {
+            if err := m.SetTrusted(ctx, dev, parseOnOff("-trusted", trusted)); err != nil {
+                log.Fatalf("SetTrusted error: %v", err)
+            }
+        }
+        if blocked != "" {
+            if err := m.SetBlocked(ctx, dev, parseOnOff("-blocked", blocked)); err != nil {
+                log.Fatalf("SetBlocked error: %v", err)
+            }
+        }
+        if remove {
+            if err := m.RemoveDevice(ctx, dev); err != nil {
+                log.Fatalf("RemoveDevice error: %v", err)
+            }
+            log.Printf("removed %s", path)
+        }
+    }
+    devs, err := m.KnownDevices(ctx, opts)
+    if err != nil {
+        log.Fatalf("KnownDevices error: %v", err)
+    }
+    if len(devs) == 0 {
+        fmt.Println("no known devices")
+        return
+    }
+    for _, d := range devs {
+        fmt.Printf("%s Path=%s MAC=%s Name=%s Alias=%s Paired=%t Trusted=%t Blocked=%t\n",
+            displayName(d), d.Path, d.MAC, d.Name, d.Alias, d.Paired, d.Trusted, d.Blocked)
+    }
+}
+
+func parseOnOff(flagName, v string) bool {
+    switch strings.ToLower(v) {
+    case "on", "true", "1":
+        return true
+    case "off", "false", "0":
+        return false
     }
+    log.Fatalf("%s: want on|off, got %q", flagName, v)
+    return false
 }
 
-func runStartServer(ctx context.Context, m connmgr.Mgr, serviceName string) {
-    if serviceName == "" {
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+    var out []string
+    for _, item := range strings.Split(s, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            out = append(out, item)
+        }
+    }
+    return out
+}
+
+func runStartServer(ctx context.Context, m connmgr.Mgr, opts connmgr.ServerOptions) {
+    if opts.ServiceName == "" {
         log.Fatal("-name is required in start mode")
     }
-    if err := m.StartServer(ctx, connmgr.ServerOptions{ServiceName: serviceName}); err != nil {
+    if err := m.StartServer(ctx, opts); err != nil {
         log.Fatalf("StartServer error: %v", err)
     }
-    log.Printf("SPP server registered: Name=%s Channel=22", serviceName)
+    log.Printf("SPP server registered: Name=%s Channel=%s", opts.ServiceName, channelStr(opts.Channel))
     log.Printf("Now waiting (no Accept). Use sdptool/dbus-monitor to verify. Timeout=%s", deadlineStr(ctx))
     <-ctx.Done()
     if ctx.Err() != nil {
@@ -125,28 +363,35 @@ func runStartServer(ctx context.Context, m connmgr.Mgr, serviceName string) {
     }
 }
 
-func runServer(ctx context.Context, m connmgr.Mgr, serviceName string) {
-    if serviceName == "" {
+func runServer(ctx context.Context, m connmgr.Mgr, opts connmgr.ServerOptions) {
+    if opts.ServiceName == "" {
         log.Fatal("-name is required in server mode")
     }
-    if err := m.StartServer(ctx, connmgr.ServerOptions{ServiceName: serviceName}); err != nil {
+    if err := m.StartServer(ctx, opts); err != nil {
         log.Fatalf("StartServer error: %v", err)
     }
-    log.Printf("SPP server started: Name=%s Channel=22", serviceName)
-    log.Printf("Waiting for incoming connection (timeout=%s)...", deadlineStr(ctx))
-    fd, peer, err := m.Accept(ctx)
-    if err != nil {
-        log.Fatalf("Accept error: %v", err)
+    log.Printf("SPP server started: Name=%s Channel=%s MaxPeers=%d", opts.ServiceName, channelStr(opts.Channel), opts.MaxPeers)
+    log.Printf("Waiting for incoming connections (timeout=%s)...", deadlineStr(ctx))
+    for {
+        fd, peer, err := m.Accept(ctx)
+        if err != nil {
+            if ctx.Err() != nil {
+                log.Printf("context done: %v", ctx.Err())
+                return
+            }
+            log.Fatalf("Accept error: %v", err)
+        }
+        fmt.Printf("ACCEPTED: fd=%d peer.Path=%s peer.MAC=%s peer.Name=%s peer.Alias=%s peer.ServiceName=%s peer.Paired=%t peer.Trusted=%t peer.Adapter=%s peer.Channel=%d peer.Version=0x%04x\n",
+            fd, peer.Path, peer.MAC, peer.Name, peer.Alias, peer.ServiceName, peer.Paired, peer.Trusted, peer.Adapter, peer.Channel, peer.Version)
     }
-    fmt.Printf("ACCEPTED: fd=%d peer.Path=%s peer.MAC=%s peer.Name=%s peer.Alias=%s\n", fd, peer.Path, peer.MAC, peer.Name, peer.Alias)
 }
 
-func runConnect(ctx context.Context, m connmgr.Mgr, path string) {
+func runConnect(ctx context.Context, m connmgr.Mgr, path string, scanOpts connmgr.ScanOptions) {
     var dev connmgr.Device
     if path == "" {
         // Scan and interactively choose
         fmt.Println("Scanning for SPP devices to choose...")
-        devs, err := m.ScanSPP(ctx)
+        devs, err := m.ScanSPP(ctx, scanOpts)
         if err != nil {
             log.Fatalf("ScanSPP error: %v", err)
         }
@@ -155,7 +400,7 @@ func runConnect(ctx context.Context, m connmgr.Mgr, path string) {
             return
         }
         for i, d := range devs {
-            fmt.Printf("[%d] Path=%s MAC=%s Name=%s Alias=%s\n", i, d.Path, d.MAC, d.Name, d.Alias)
+            fmt.Printf("[%d] %s Path=%s MAC=%s Name=%s Alias=%s Channel=%d\n", i, displayName(d), d.Path, d.MAC, d.Name, d.Alias, d.Channel)
         }
         fmt.Print("Choose index: ")
         idx := readIndex(len(devs))
@@ -171,10 +416,112 @@ func runConnect(ctx context.Context, m connmgr.Mgr, path string) {
     fmt.Printf("CONNECTED: fd=%d dev.Path=%s\n", fd, dev.Path)
 }
 
+func runReconnect(ctx context.Context, path string) {
+    if path == "" {
+        log.Fatal("-device is required in reconnect mode")
+    }
+    r := connmgr.NewReconnector(connmgr.Device{Path: path}, connmgr.ReconnectOptions{})
+    var f *os.File
+    for ev := range r.Run(ctx) {
+        switch ev.State {
+        case connmgr.StateConnected:
+            f = os.NewFile(uintptr(ev.FD), "rfcomm")
+            fmt.Printf("CONNECTED: fd=%d dev.Path=%s\n", ev.FD, path)
+        case connmgr.StateDisconnected:
+            if f != nil {
+                _ = f.Close()
+                f = nil
+            }
+            log.Printf("%s: %v", ev.State, ev.Err)
+        case connmgr.StateBackoff:
+            log.Printf("%s: attempt=%d delay=%s err=%v", ev.State, ev.Attempt, ev.Delay.Truncate(time.Millisecond), ev.Err)
+        case connmgr.StateGaveUp:
+            log.Printf("%s: attempt=%d err=%v", ev.State, ev.Attempt, ev.Err)
+        default:
+            log.Printf("%s: attempt=%d", ev.State, ev.Attempt)
+        }
+    }
+    if f != nil {
+        _ = f.Close()
+    }
+}
+
+// stdin is shared by all prompts so buffered input is not lost between readers.
+var (
+    stdin    = bufio.NewReader(os.Stdin)
+    promptMu sync.Mutex
+)
+
+// terminalAgent answers pairing requests by prompting on the terminal.
+func terminalAgent(capability connmgr.AgentCapability) connmgr.AgentOptions {
+    return connmgr.AgentOptions{
+        Capability: capability,
+        ConfirmPasskey: func(dev connmgr.Device, passkey uint32) bool {
+            return promptYesNo(fmt.Sprintf("Confirm passkey %s for %s?", connmgr.FormatPasskey(passkey), deviceLabel(dev)))
+        },
+        RequestPinCode: func(dev connmgr.Device) (string, bool) {
+            pin := promptLine(fmt.Sprintf("Enter PIN for %s: ", deviceLabel(dev)))
+            return pin, pin != ""
+        },
+        RequestPasskey: func(dev connmgr.Device) (uint32, bool) {
+            v, err := strconv.ParseUint(promptLine(fmt.Sprintf("Enter passkey for %s: ", deviceLabel(dev))), 10, 32)
+            return uint32(v), err == nil && v <= 999999
+        },
+        DisplayPinCode: func(dev connmgr.Device, pin string) {
+            fmt.Printf("PIN for %s: %s\n", deviceLabel(dev), pin)
+        },
+        DisplayPasskey: func(dev connmgr.Device, passkey uint32, entered uint16) {
+            fmt.Printf("Passkey for %s: %s (entered %d)\n", deviceLabel(dev), connmgr.FormatPasskey(passkey), entered)
+        },
+        Authorize: func(dev connmgr.Device) bool {
+            return promptYesNo(fmt.Sprintf("Allow pairing with %s?", deviceLabel(dev)))
+        },
+        AuthorizeService: func(dev connmgr.Device, uuid string) bool {
+            return promptYesNo(fmt.Sprintf("Allow %s to use service %s?", deviceLabel(dev), uuid))
+        },
+        Canceled: func(dev connmgr.Device) {
+            // The prompt still owns stdin; its answer is discarded.
+            fmt.Printf("\nrequest from %s canceled; press Enter\n", deviceLabel(dev))
+        },
+    }
+}
+
+// displayName prefers the SDP ServiceName, falling back to Alias/Name (DESIGN.md 2.D).
+func displayName(d connmgr.Device) string {
+    switch {
+    case d.ServiceName != "":
+        return d.ServiceName
+    case d.Alias != "":
+        return d.Alias
+    case d.Name != "":
+        return d.Name
+    }
+    return d.MAC
+}
+
+func deviceLabel(d connmgr.Device) string {
+    if d.Alias != "" {
+        return d.Alias + " (" + d.MAC + ")"
+    }
+    return d.MAC
+}
+
+func promptLine(prompt string) string {
+    promptMu.Lock()
+    defer promptMu.Unlock()
+    fmt.Print(prompt)
+    line, _ := stdin.ReadString('\n')
+    return strings.TrimSpace(line)
+}
+
+func promptYesNo(question string) bool {
+    answer := strings.ToLower(promptLine(question + " [y/N]: "))
+    return answer == "y" || answer == "yes"
+}
+
 func readIndex(n int) int {
-    r := bufio.NewReader(os.Stdin)
     for {
-        line, _ := r.ReadString('\n')
+        line, _ := stdin.ReadString('\n')
         line = strings.TrimSpace(line)
         i, err := strconv.Atoi(line)
         if err == nil && i >= 0 && i < n {
@@ -184,6 +531,20 @@ func readIndex(n int) int {
     }
 }
 
+func lastSeenStr(t time.Time) string {
+    if t.IsZero() {
+        return "never"
+    }
+    return t.Format("15:04:05")
+}
+
+func channelStr(ch uint8) string {
+    if ch == 0 {
+        return "auto"
+    }
+    return strconv.Itoa(int(ch))
+}
+
 func deadlineStr(ctx context.Context) string {
     if d, ok := ctx.Deadline(); ok {
         return time.Until(d).Truncate(time.Second).String()
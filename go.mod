@@ -2,4 +2,8 @@ module bluetooth-chat
 
 go 1.25.1
 
-require github.com/godbus/dbus/v5 v5.1.0 // indirect
+require github.com/godbus/dbus/v5 v5.1.0
+
+require golang.org/x/sys v0.36.0
+
+require golang.org/x/crypto v0.42.0
@@ -0,0 +1,144 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "fmt"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+func (m *mgr) Adapters(ctx context.Context) ([]Adapter, error) {
+    bus, err := m.busForCall()
+    if err != nil {
+        return nil, err
+    }
+    objs, err := m.managedObjects(ctx, bus)
+    if err != nil {
+        return nil, err
+    }
+    var out []Adapter
+    for p, ifaces := range objs {
+        if props, ok := ifaces[adapterIface]; ok {
+            out = append(out, adapterFromProps(p, props))
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+    return out, nil
+}
+
+func (m *mgr) SetAdapterPowered(ctx context.Context, adapter string, on bool) error {
+    return m.setAdapterProps(ctx, adapter, map[string]interface{}{"Powered": on})
+}
+
+func (m *mgr) SetAdapterDiscoverable(ctx context.Context, adapter string, on bool, timeout time.Duration) error {
+    if timeout < 0 {
+        return fmt.Errorf("connmgr: invalid discoverable timeout %s", timeout)
+    }
+    // The timeout must be set first: BlueZ arms the timer when Discoverable turns on.
+    return m.setAdapterProps(ctx, adapter, map[string]interface{}{
+        "DiscoverableTimeout": uint32(timeout / time.Second),
+        "Discoverable":        on,
+    })
+}
+
+func (m *mgr) SetAdapterPairable(ctx context.Context, adapter string, on bool) error {
+    return m.setAdapterProps(ctx, adapter, map[string]interface{}{"Pairable": on})
+}
+
+// setAdapterProps sets Adapter1 properties in a fixed order (DiscoverableTimeout before Discoverable).
+func (m *mgr) setAdapterProps(ctx context.Context, adapter string, props map[string]interface{}) error {
+    bus, err := m.busForCall()
+    if err != nil {
+        return err
+    }
+    p, err := m.resolveAdapter(ctx, bus, adapter)
+    if err != nil {
+        return err
+    }
+    obj := bus.Object(m.cfg.service, p)
+    for _, name := range []string{"Powered", "Pairable", "DiscoverableTimeout", "Discoverable"} {
+        v, ok := props[name]
+        if !ok {
+            continue
+        }
+        if call := obj.CallWithContext(ctx, propsIface+".Set", 0, adapterIface, name, dbus.MakeVariant(v)); call.Err != nil {
+            return opError("Set "+path.Base(string(p))+"."+name, call.Err)
+        }
+    }
+    return nil
+}
+
+// busForCall returns the bus for a one-shot call, connecting if needed.
+func (m *mgr) busForCall() (*dbus.Conn, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.closed {
+        return nil, ErrClosed
+    }
+    if err := m.ensureBusLocked(); err != nil {
+        return nil, err
+    }
+    return m.bus, nil
+}
+
+// resolveAdapter maps an adapter selector to its object path. The selector may be the
+// hci name ("hci1"), the adapter address (case-insensitive) or the full object path.
+// An empty selector returns "" (no pinning).
+func (m *mgr) resolveAdapter(ctx context.Context, bus *dbus.Conn, sel string) (dbus.ObjectPath, error) {
+    if sel == "" {
+        return "", nil
+    }
+    objs, err := m.managedObjects(ctx, bus)
+    if err != nil {
+        return "", err
+    }
+    for p, ifaces := range objs {
+        props, ok := ifaces[adapterIface]
+        if !ok {
+            continue
+        }
+        a := adapterFromProps(p, props)
+        if sel == a.Path || sel == a.Name || strings.EqualFold(sel, a.Address) {
+            return p, nil
+        }
+    }
+    return "", fmt.Errorf("%w: adapter %q not found", ErrNoAdapter, sel)
+}
+
+// onAdapter reports whether the device object at dev belongs to adapter (always true if adapter is "").
+func onAdapter(dev, adapter dbus.ObjectPath) bool {
+    return adapter == "" || strings.HasPrefix(string(dev), string(adapter)+"/")
+}
+
+func adapterFromProps(p dbus.ObjectPath, props map[string]dbus.Variant) Adapter {
+    a := Adapter{Path: string(p), Name: path.Base(string(p))}
+    if v, ok := props["Address"]; ok {
+        a.Address, _ = v.Value().(string)
+    }
+    if v, ok := props["Alias"]; ok {
+        a.Alias, _ = v.Value().(string)
+    }
+    if v, ok := props["Powered"]; ok {
+        a.Powered, _ = v.Value().(bool)
+    }
+    if v, ok := props["Discoverable"]; ok {
+        a.Discoverable, _ = v.Value().(bool)
+    }
+    if v, ok := props["DiscoverableTimeout"]; ok {
+        t, _ := v.Value().(uint32)
+        a.DiscoverableTimeout = time.Duration(t) * time.Second
+    }
+    if v, ok := props["Pairable"]; ok {
+        a.Pairable, _ = v.Value().(bool)
+    }
+    if v, ok := props["Discovering"]; ok {
+        a.Discovering, _ = v.Value().(bool)
+    }
+    return a
+}
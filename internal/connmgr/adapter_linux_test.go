@@ -0,0 +1,103 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+)
+
+func TestAdapters(t *testing.T) {
+    f := newFixture(t)
+    if _, err := f.bz.AddAdapter("hci1", "66:77:88:99:AA:BB"); err != nil {
+        t.Fatal(err)
+    }
+    got, err := f.m.Adapters(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []connmgr.Adapter{
+        {Path: "/org/bluez/hci0", Name: "hci0", Address: "00:11:22:33:44:55", Alias: "hci0", Powered: true, DiscoverableTimeout: 180 * time.Second, Pairable: true},
+        {Path: "/org/bluez/hci1", Name: "hci1", Address: "66:77:88:99:AA:BB", Alias: "hci1", Powered: true, DiscoverableTimeout: 180 * time.Second, Pairable: true},
+    }
+    if len(got) != len(want) {
+        t.Fatalf("got %+v, want %+v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Errorf("adapter %d: got %+v, want %+v", i, got[i], want[i])
+        }
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := f.m.Adapters(ctx); !errors.Is(err, context.Canceled) {
+        t.Errorf("canceled ctx: got %v, want context.Canceled", err)
+    }
+}
+
+func TestAdapterSetters(t *testing.T) {
+    f := newFixture(t)
+    ctx := context.Background()
+    prop := func(name string) interface{} {
+        v, _ := f.bz.Property(f.hci0, "org.bluez.Adapter1", name)
+        return v
+    }
+
+    // The adapter may be named by hci name, address (any case) or path.
+    if err := f.m.SetAdapterPowered(ctx, "hci0", false); err != nil {
+        t.Fatal(err)
+    }
+    if prop("Powered") != false {
+        t.Error("Powered still on")
+    }
+    if err := f.m.SetAdapterPairable(ctx, "00:11:22:33:44:55", false); err != nil {
+        t.Fatal(err)
+    }
+    if prop("Pairable") != false {
+        t.Error("Pairable still on")
+    }
+    if err := f.m.SetAdapterDiscoverable(ctx, string(f.hci0), true, 90*time.Second); err != nil {
+        t.Fatal(err)
+    }
+    if prop("Discoverable") != true || prop("DiscoverableTimeout") != uint32(90) {
+        t.Errorf("Discoverable=%v DiscoverableTimeout=%v, want true and 90", prop("Discoverable"), prop("DiscoverableTimeout"))
+    }
+    if err := f.m.SetAdapterDiscoverable(ctx, "hci0", true, 0); err != nil {
+        t.Fatal(err)
+    }
+    if prop("DiscoverableTimeout") != uint32(0) {
+        t.Errorf("DiscoverableTimeout=%v, want 0", prop("DiscoverableTimeout"))
+    }
+    if err := f.m.SetAdapterDiscoverable(ctx, "hci0", true, -time.Second); err == nil {
+        t.Error("negative timeout accepted")
+    }
+
+    ctx2, cancel := context.WithCancel(ctx)
+    cancel()
+    if err := f.m.SetAdapterPowered(ctx2, "hci0", true); !errors.Is(err, context.Canceled) {
+        t.Errorf("canceled ctx: got %v, want context.Canceled", err)
+    }
+}
+
+func TestAdapterUnknown(t *testing.T) {
+    f := newFixture(t)
+    ctx := context.Background()
+    for name, err := range map[string]error{
+        "SetAdapterPowered":      f.m.SetAdapterPowered(ctx, "hci7", true),
+        "SetAdapterPairable":     f.m.SetAdapterPairable(ctx, "AA:AA:AA:AA:AA:AA", true),
+        "SetAdapterDiscoverable": f.m.SetAdapterDiscoverable(ctx, "/org/bluez/hci7", true, 0),
+        "StartServer":            f.m.StartServer(ctx, connmgr.ServerOptions{ServiceName: "test", Adapter: "hci7"}),
+    } {
+        if !errors.Is(err, connmgr.ErrNoAdapter) {
+            t.Errorf("%s: got %v, want ErrNoAdapter", name, err)
+        }
+    }
+    if _, err := f.m.KnownDevices(ctx, connmgr.DeviceListOptions{Adapter: "hci7"}); !errors.Is(err, connmgr.ErrNoAdapter) {
+        t.Errorf("KnownDevices: got %v, want ErrNoAdapter", err)
+    }
+}
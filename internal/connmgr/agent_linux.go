@@ -0,0 +1,235 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "fmt"
+    "sync"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+    agentIface        = "org.bluez.Agent1"
+    agentManagerIface = "org.bluez.AgentManager1"
+)
+
+// agent implements org.bluez.Agent1 by delegating to AgentOptions callbacks.
+// Methods are invoked on D-Bus handler goroutines; callbacks may block (e.g. prompt a user).
+type agent struct {
+    bus     *dbus.Conn
+    service string
+    opts    AgentOptions
+
+    mu      sync.Mutex
+    next    uint64
+    pending map[uint64]pendingRequest // prompts waiting for an answer, by request number
+}
+
+// pendingRequest is a prompt that Cancel may interrupt.
+type pendingRequest struct {
+    dev    Device
+    cancel context.CancelFunc
+}
+
+// Release is called when BlueZ unregisters the agent.
+func (a *agent) Release() *dbus.Error { return nil }
+
+// Cancel is called when a pending request was canceled by BlueZ or the remote side.
+// Pending prompts are answered with org.bluez.Error.Canceled at once and AgentOptions.Canceled
+// is told; whatever their callbacks return later is ignored.
+func (a *agent) Cancel() *dbus.Error {
+    a.mu.Lock()
+    reqs := a.pending
+    a.pending = nil
+    a.mu.Unlock()
+    for _, r := range reqs {
+        r.cancel()
+        if a.opts.Canceled != nil {
+            a.opts.Canceled(r.dev)
+        }
+    }
+    return nil
+}
+
+// ask runs a prompt callback for dev until it answers or Cancel interrupts it, and returns
+// the callback's verdict. The callback keeps running after an interruption; its result is
+// then dropped, so values it stores may only be read when ask returned no error.
+func (a *agent) ask(dev Device, prompt func(Device) bool) (bool, *dbus.Error) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    a.mu.Lock()
+    if a.pending == nil {
+        a.pending = make(map[uint64]pendingRequest)
+    }
+    a.next++
+    id := a.next
+    a.pending[id] = pendingRequest{dev: dev, cancel: cancel}
+    a.mu.Unlock()
+    defer func() {
+        a.mu.Lock()
+        delete(a.pending, id)
+        a.mu.Unlock()
+    }()
+
+    answer := make(chan bool, 1)
+    go func() { answer <- prompt(dev) }()
+    select {
+    case ok := <-answer:
+        return ok, nil
+    case <-ctx.Done():
+        return false, &dbus.Error{Name: "org.bluez.Error.Canceled", Body: []interface{}{"request canceled"}}
+    }
+}
+
+func (a *agent) RequestPinCode(dev dbus.ObjectPath) (string, *dbus.Error) {
+    if a.opts.RequestPinCode == nil {
+        return "", rejected("PIN entry not supported")
+    }
+    var pin string
+    ok, derr := a.ask(a.device(dev), func(d Device) (ok bool) {
+        pin, ok = a.opts.RequestPinCode(d)
+        return ok
+    })
+    if derr != nil {
+        return "", derr
+    }
+    if !ok {
+        return "", rejected("PIN entry refused")
+    }
+    return pin, nil
+}
+
+func (a *agent) DisplayPinCode(dev dbus.ObjectPath, pin string) *dbus.Error {
+    if a.opts.DisplayPinCode != nil {
+        a.opts.DisplayPinCode(a.device(dev), pin)
+    }
+    return nil
+}
+
+func (a *agent) RequestPasskey(dev dbus.ObjectPath) (uint32, *dbus.Error) {
+    if a.opts.RequestPasskey == nil {
+        return 0, rejected("passkey entry not supported")
+    }
+    var key uint32
+    ok, derr := a.ask(a.device(dev), func(d Device) (ok bool) {
+        key, ok = a.opts.RequestPasskey(d)
+        return ok
+    })
+    if derr != nil {
+        return 0, derr
+    }
+    if !ok {
+        return 0, rejected("passkey entry refused")
+    }
+    return key, nil
+}
+
+func (a *agent) DisplayPasskey(dev dbus.ObjectPath, passkey uint32, entered uint16) *dbus.Error {
+    if a.opts.DisplayPasskey != nil {
+        a.opts.DisplayPasskey(a.device(dev), passkey, entered)
+    }
+    return nil
+}
+
+func (a *agent) RequestConfirmation(dev dbus.ObjectPath, passkey uint32) *dbus.Error {
+    if a.opts.ConfirmPasskey == nil {
+        return nil
+    }
+    ok, derr := a.ask(a.device(dev), func(d Device) bool { return a.opts.ConfirmPasskey(d, passkey) })
+    if derr != nil {
+        return derr
+    }
+    if !ok {
+        return rejected("passkey not confirmed")
+    }
+    return nil
+}
+
+func (a *agent) RequestAuthorization(dev dbus.ObjectPath) *dbus.Error {
+    if a.opts.Authorize == nil {
+        return nil
+    }
+    ok, derr := a.ask(a.device(dev), a.opts.Authorize)
+    if derr != nil {
+        return derr
+    }
+    if !ok {
+        return rejected("pairing not authorized")
+    }
+    return nil
+}
+
+func (a *agent) AuthorizeService(dev dbus.ObjectPath, uuid string) *dbus.Error {
+    if a.opts.AuthorizeService == nil {
+        return nil
+    }
+    ok, derr := a.ask(a.device(dev), func(d Device) bool { return a.opts.AuthorizeService(d, uuid) })
+    if derr != nil {
+        return derr
+    }
+    if !ok {
+        return rejected("service not authorized")
+    }
+    return nil
+}
+
+// device resolves display information for callbacks (best-effort).
+func (a *agent) device(path dbus.ObjectPath) Device {
+    d := Device{Path: string(path), MAC: macFromPath(path)}
+    var props map[string]dbus.Variant
+    if err := a.bus.Object(a.service, path).Call(propsIface+".GetAll", 0, deviceIface).Store(&props); err == nil {
+        d = deviceFromProps(path, props)
+    }
+    return d
+}
+
+// FormatPasskey renders a passkey the way it is shown on the remote side (six digits).
+func FormatPasskey(passkey uint32) string {
+    return fmt.Sprintf("%06d", passkey)
+}
+
+func (m *mgr) RegisterAgent(ctx context.Context, opts AgentOptions) error {
+    _ = ctx // ctx reserved; registration is fast.
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.closed {
+        return ErrClosed
+    }
+    if m.agentRegistered {
+        return fmt.Errorf("%w: agent already registered", ErrAlreadyUsed)
+    }
+    switch opts.Capability {
+    case "":
+        opts.Capability = AgentNoInputNoOutput
+    case AgentNoInputNoOutput, AgentDisplayYesNo, AgentKeyboardDisplay:
+    default:
+        return fmt.Errorf("connmgr: unsupported agent capability %q", opts.Capability)
+    }
+    if err := m.ensureBusLocked(); err != nil {
+        return err
+    }
+
+    path := m.objectPath("agent", "a")
+    if err := m.bus.Export(&agent{bus: m.bus, service: m.cfg.service, opts: opts}, path, agentIface); err != nil {
+        return fmt.Errorf("connmgr: export agent: %w", err)
+    }
+    am := m.bus.Object(m.cfg.service, dbus.ObjectPath("/org/bluez"))
+    if call := am.Call(agentManagerIface+".RegisterAgent", 0, path, string(opts.Capability)); call.Err != nil {
+        _ = m.bus.Export(nil, path, agentIface)
+        return opError("RegisterAgent", call.Err)
+    }
+    // Become the default agent so pairing requests not initiated by us (e.g. incoming) reach it too.
+    if call := am.Call(agentManagerIface+".RequestDefaultAgent", 0, path); call.Err != nil {
+        _ = am.Call(agentManagerIface+".UnregisterAgent", 0, path).Err
+        _ = m.bus.Export(nil, path, agentIface)
+        return opError("RequestDefaultAgent", call.Err)
+    }
+    m.cleanup = append(m.cleanup, func() {
+        _ = am.Call(agentManagerIface+".UnregisterAgent", 0, path).Err
+        _ = m.bus.Export(nil, path, agentIface)
+    })
+    m.agentRegistered = true
+    return nil
+}
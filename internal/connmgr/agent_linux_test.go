@@ -0,0 +1,108 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "errors"
+    "syscall"
+    "testing"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+func TestRegisterAgent(t *testing.T) {
+    f := newFixture(t)
+    if err := f.m.RegisterAgent(context.Background(), connmgr.AgentOptions{Capability: connmgr.AgentDisplayYesNo}); err != nil {
+        t.Fatal(err)
+    }
+    agents := f.bz.Agents()
+    if len(agents) != 1 || agents[0].Capability != "DisplayYesNo" || !agents[0].Default {
+        t.Fatalf("agents %+v, want one default DisplayYesNo agent", agents)
+    }
+    if err := f.m.RegisterAgent(context.Background(), connmgr.AgentOptions{}); !errors.Is(err, connmgr.ErrAlreadyUsed) {
+        t.Errorf("second RegisterAgent: got %v, want ErrAlreadyUsed", err)
+    }
+    if err := f.newMgr(t).RegisterAgent(context.Background(), connmgr.AgentOptions{Capability: "Telepathy"}); err == nil {
+        t.Error("unknown capability accepted")
+    }
+
+    f.m.Close()
+    if agents := f.bz.Agents(); len(agents) != 0 {
+        t.Errorf("agents after Close: %+v", agents)
+    }
+}
+
+func TestAgentConfirm(t *testing.T) {
+    for _, accept := range []bool{true, false} {
+        f := newFixture(t)
+        asked := make(chan uint32, 1)
+        err := f.m.RegisterAgent(context.Background(), connmgr.AgentOptions{
+            Capability: connmgr.AgentDisplayYesNo,
+            ConfirmPasskey: func(dev connmgr.Device, passkey uint32) bool {
+                asked <- passkey
+                return accept
+            },
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Passkey: 123456})
+        m := f.newMgr(t)
+        if accept {
+            fd, _ := f.connect(t, m, connmgr.Device{Path: string(p)})
+            syscall.Close(fd)
+        } else if _, err := m.Connect(context.Background(), connmgr.Device{Path: string(p)}); !errors.Is(err, connmgr.ErrAuthFailed) {
+            t.Errorf("rejected passkey: got %v, want ErrAuthFailed", err)
+        }
+        if got := <-asked; got != 123456 {
+            t.Errorf("asked to confirm %d, want 123456", got)
+        }
+        if v, _ := f.bz.Property(p, "org.bluez.Device1", "Paired"); v != accept {
+            t.Errorf("accept=%v: Paired=%v", accept, v)
+        }
+    }
+}
+
+func TestAgentCancel(t *testing.T) {
+    f := newFixture(t)
+    asked := make(chan struct{})
+    canceled := make(chan connmgr.Device, 1)
+    release := make(chan struct{})
+    defer close(release)
+    err := f.m.RegisterAgent(context.Background(), connmgr.AgentOptions{
+        Capability: connmgr.AgentDisplayYesNo,
+        ConfirmPasskey: func(connmgr.Device, uint32) bool {
+            close(asked)
+            <-release // the user never answers
+            return true
+        },
+        Canceled: func(dev connmgr.Device) { canceled <- dev },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Passkey: 123456})
+
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-asked
+        cancel()
+    }()
+    if _, err := f.newMgr(t).Connect(ctx, connmgr.Device{Path: string(p)}); !errors.Is(err, context.Canceled) {
+        t.Fatalf("got %v, want context.Canceled", err)
+    }
+    select {
+    case dev := <-canceled:
+        if dev.Path != string(p) {
+            t.Errorf("canceled for %s, want %s", dev.Path, p)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("prompt not canceled")
+    }
+    if v, _ := f.bz.Property(p, "org.bluez.Device1", "Paired"); v != false {
+        t.Error("device paired after cancellation")
+    }
+}
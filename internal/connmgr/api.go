@@ -1,21 +1,33 @@
 // Package connmgr defines the public interfaces,
 // responsible for preparing Unix FDs for RFCOMM SPP connections via BlueZ D-Bus.
 //
-// Thread-safety: except for Close(), methods are not safe for concurrent use.
-// Callers must serialize StartServer, Accept, ScanSPP, and Connect. Close is
-// safe to call concurrently and is idempotent.
+// Errors can be tested against the Err* sentinel values (ErrClosed, ErrNoAdapter, ...) with errors.Is.
+//
+// Thread-safety: except for Close() and Release(), methods are not safe for concurrent use.
+// Callers must serialize StartServer, Accept, ScanSPP, and Connect. Close and Release are
+// safe to call concurrently; Close is idempotent.
 package connmgr
 
 import (
     "context"
+    "time"
 )
 
 const (
     // SPPUUID is the Serial Port Profile UUID used for RFCOMM connections.
     SPPUUID = "00001101-0000-1000-8000-00805f9b34fb"
 
-    // DefaultRFCOMMChannel is the fixed RFCOMM channel for the server-side profile.
+    // ChatServiceUUID identifies bluetooth-chat servers. Registering and scanning for it instead
+    // of SPPUUID keeps unrelated serial devices (printers, GPS, OBD dongles) out of scan results.
+    ChatServiceUUID = "b30c9db7-1827-4607-9398-96a2fac3a9f1"
+
+    // DefaultRFCOMMChannel is the conventional RFCOMM channel for the chat server
+    // (see ServerOptions.Channel; the CLIs use it as their default).
     DefaultRFCOMMChannel uint8 = 22
+
+    // DefaultMaxPeers is the number of concurrent peers a server accepts when
+    // ServerOptions.MaxPeers is zero.
+    DefaultMaxPeers = 1
 )
 
 // Device represents the minimum information needed to display and connect.
@@ -23,59 +35,367 @@ const (
 // Path is required (BlueZ Device1 object path as string). Other fields are optional
 // and may be empty depending on discovery results.
 type Device struct {
-    Path        string // required: D-Bus object path of the device (e.g. /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX)
-    MAC         string // optional: Bluetooth device address
-    Name        string // optional: Device1.Name
-    Alias       string // optional: Device1.Alias
-    ServiceName string // optional: SDP ServiceName (0x0100) if available
+    Path        string    // required: D-Bus object path of the device (e.g. /org/bluez/hci0/dev_XX_XX_XX_XX_XX_XX)
+    MAC         string    // optional: Bluetooth device address
+    Name        string    // optional: Device1.Name
+    Alias       string    // optional: Device1.Alias
+    ServiceName string    // optional: SDP ServiceName (0x0100) if available
+    Channel     uint8     // optional: RFCOMM channel from the remote SDP record if available; for Accept, the channel the connection arrived on
+    RSSI        int16     // optional: Device1.RSSI in dBm from discovery; 0 if unknown
+    UUID        string    // optional: service UUID matched by the scan; Connect uses it (default SPPUUID)
+    Adapter     string    // optional: D-Bus object path of the local adapter the device is known to (e.g. /org/bluez/hci0)
+    Paired      bool      // optional: Device1.Paired
+    Trusted     bool      // optional: Device1.Trusted
+    Blocked     bool      // optional: Device1.Blocked
+    Connected   bool      // optional: Device1.Connected (any profile, not only this package's)
+    Class       uint32    // optional: Device1.Class (Class of Device); 0 if unknown
+    Icon        string    // optional: Device1.Icon, e.g. "phone" or "computer"
+    Version     uint16    // optional: peer's profile version from NewConnection fd_properties (Accept only); 0 if not reported
+    LastSeen    time.Time // optional: when ScanSPP/WatchSPP last received a discovery result (RSSI) during the scan; zero if not heard yet
+}
+
+// DeviceEventType distinguishes WatchSPP events.
+type DeviceEventType int
+
+const (
+    DeviceAdded   DeviceEventType = iota // device advertises SPP and is reported for the first time
+    DeviceUpdated                        // Name, Alias, RSSI, pairing/connection state or SDP information changed
+    DeviceRemoved                        // device vanished or no longer advertises SPP
+)
+
+func (t DeviceEventType) String() string {
+    switch t {
+    case DeviceAdded:
+        return "added"
+    case DeviceUpdated:
+        return "updated"
+    case DeviceRemoved:
+        return "removed"
+    }
+    return "unknown"
+}
+
+// DeviceEvent is a change in the live device list produced by WatchSPP.
+// For DeviceRemoved, Device holds the last reported state.
+type DeviceEvent struct {
+    Type   DeviceEventType
+    Device Device
+}
+
+// EventType distinguishes lifecycle notifications delivered by Mgr.Events.
+type EventType int
+
+const (
+    // EventDisconnected: BlueZ asked to tear down the link to Device (Profile1.RequestDisconnection).
+    // The caller should close that device's FD (and Release it on a server).
+    EventDisconnected EventType = iota
+    // EventReleased: the profile is no longer registered, because BlueZ released it
+    // (Profile1.Release) or bluetoothd went away. No connections arrive until EventReregistered.
+    EventReleased
+    // EventReregistered: bluetoothd (re)appeared and the profile was registered again.
+    // Err is set if registration failed; the profile then stays unregistered.
+    EventReregistered
+)
+
+func (t EventType) String() string {
+    switch t {
+    case EventDisconnected:
+        return "disconnected"
+    case EventReleased:
+        return "released"
+    case EventReregistered:
+        return "reregistered"
+    }
+    return "unknown"
+}
+
+// Event is a lifecycle notification for a registered profile.
+type Event struct {
+    Type   EventType
+    Role   string // "server" or "client"
+    Device Device // EventDisconnected only: Path and MAC of the peer
+    Err    error  // EventReregistered only: registration failure
 }
 
 // ServerOptions controls server-side profile registration.
 type ServerOptions struct {
     // ServiceName is required and will be used for RegisterProfile options["Name"].
     ServiceName string
+
+    // Channel is the RFCOMM server channel (1-30). 0 lets BlueZ choose: its per-UUID
+    // default (channel 3 for SPP) or a free channel. A non-zero channel already bound by
+    // another RFCOMM server makes StartServer fail.
+    Channel uint8
+
+    // PSM optionally also exposes the service on this L2CAP PSM (0 = none).
+    // A valid PSM is odd with bit 8 clear (e.g. 0x1001).
+    PSM uint16
+
+    // RequireAuthentication, RequireAuthorization and AutoConnect are passed to RegisterProfile
+    // when true; false keeps BlueZ's default for the UUID (SPP requires authorization by default).
+    RequireAuthentication bool
+    RequireAuthorization  bool
+    AutoConnect           bool
+
+    // UUID is the service UUID to register, in canonical 128-bit form. Empty means SPPUUID.
+    // For any other UUID (e.g. ChatServiceUUID) without a ServiceRecord, a record is generated that
+    // lists both UUID and SPP as service classes on the same channel, so plain SPP clients can
+    // still connect. Because that record names its channel, Channel 0 then picks a free channel
+    // locally instead of leaving it to BlueZ.
+    UUID string
+
+    // ServiceRecord is an optional complete SDP record in BlueZ XML format. It replaces the record
+    // BlueZ would generate, so it must carry the RFCOMM channel itself (and Channel should match).
+    ServiceRecord string
+
+    // MaxPeers limits the number of concurrent peers (connections waiting in Accept plus
+    // accepted connections not yet released). Incoming connections beyond the limit are
+    // rejected with org.bluez.Error.Rejected. Zero means DefaultMaxPeers; negative is an error.
+    MaxPeers int
+
+    // Adapter pins the server to one local adapter, by hci name ("hci1") or address.
+    // BlueZ registers profiles for all adapters, so the SDP record is still visible on every
+    // adapter; connections arriving through other adapters are rejected. Empty means any adapter.
+    Adapter string
+
+    // Authorization policy for incoming connections, applied in this order: DenyMACs, AllowMACs,
+    // TrustedOnly, Authorize. A connection failing any check is closed and rejected with
+    // org.bluez.Error.Rejected; it does not take a peer slot.
+
+    // DenyMACs rejects these peer addresses ("AA:BB:CC:DD:EE:FF", case-insensitive).
+    DenyMACs []string
+    // AllowMACs, if non-empty, accepts only these peer addresses.
+    AllowMACs []string
+    // TrustedOnly accepts only devices that are both paired and trusted (Device1.Paired and
+    // Device1.Trusted; see Mgr.SetTrusted).
+    TrustedOnly bool
+    // Authorize, if set, decides last. It runs on a D-Bus handler goroutine while BlueZ waits
+    // for the answer, so it should return promptly.
+    Authorize func(dev Device) bool
+
+    // LookupServiceName makes Accept query an accepted peer's SDP record for its ServiceName
+    // when no earlier scan or lookup cached it. The query delays Accept by up to 2 s and pages
+    // the peer, so it is off by default and ServiceName comes from the cache only.
+    LookupServiceName bool
+}
+
+// ScanOptions controls discovery for ScanSPP and WatchSPP.
+type ScanOptions struct {
+    // Adapter restricts discovery and results to one local adapter, by hci name or address.
+    // Empty means all adapters. Devices returned carry the adapter in their Path, so a
+    // subsequent Connect uses the same adapter.
+    Adapter string
+
+    // UUID is the service UUID devices must advertise in Device1.UUIDs (canonical 128-bit form).
+    // Empty means SPPUUID; use ChatServiceUUID to list only chat servers.
+    UUID string
+
+    // Discovery filter, passed to Adapter1.SetDiscoveryFilter on every adapter scanned and
+    // cleared when the scan ends. BlueZ merges the filters of all its discovery clients, so
+    // the RSSI, pathloss and name filters are also applied to the results here.
+
+    // Transport selects the radio: "auto" (or empty), "bredr" or "le". SPP runs over
+    // BR/EDR only, so "bredr" skips LE scanning.
+    Transport string
+    // MinRSSI drops devices received weaker than this many dBm (e.g. -80), and devices
+    // without a current RSSI. 0 means no threshold.
+    MinRSSI int16
+    // MaxPathloss drops devices whose path loss (advertised TxPower minus RSSI) exceeds this
+    // many dB. 0 means no limit. It cannot be combined with MinRSSI.
+    MaxPathloss uint16
+    // DuplicateData makes BlueZ report repeated discovery data (e.g. every RSSI reading)
+    // instead of only changes. More events, fresher RSSI and LastSeen. It is always passed to
+    // BlueZ, whose own default is true, so false does filter repeats.
+    DuplicateData bool
+    // NamePattern keeps only devices whose address or name starts with it. Empty matches all.
+    NamePattern string
+
+    // MaxResults limits ScanSPP to the strongest devices after sorting. 0 means no limit.
+    // WatchSPP ignores it.
+    MaxResults int
+}
+
+// DeviceListOptions selects the devices returned by KnownDevices.
+type DeviceListOptions struct {
+    // Adapter restricts results to one local adapter, by hci name, address or path.
+    // Empty means all adapters.
+    Adapter string
+
+    // UUID is the service UUID devices must advertise in Device1.UUIDs. Empty means SPPUUID.
+    // Ignored if AllServices is set.
+    UUID string
+
+    // AllServices lists every device BlueZ knows, whatever services it advertises.
+    AllServices bool
+
+    // PairedOnly lists only paired devices.
+    PairedOnly bool
+}
+
+// Adapter describes a local Bluetooth adapter (org.bluez.Adapter1).
+type Adapter struct {
+    Path                string // D-Bus object path (e.g. /org/bluez/hci0)
+    Name                string // hci name (e.g. hci0)
+    Address             string // adapter address
+    Alias               string // friendly name shown to remote devices
+    Powered             bool
+    Discoverable        bool
+    DiscoverableTimeout time.Duration // 0 means discoverable until turned off
+    Pairable            bool
+    Discovering         bool
+}
+
+// AgentCapability is the IO capability announced to BlueZ by RegisterAgent.
+type AgentCapability string
+
+const (
+    AgentNoInputNoOutput AgentCapability = "NoInputNoOutput" // headless: "just works" pairing
+    AgentDisplayYesNo    AgentCapability = "DisplayYesNo"    // can show a passkey and confirm it
+    AgentKeyboardDisplay AgentCapability = "KeyboardDisplay" // can show and enter passkeys/PINs
+)
+
+// AgentOptions configures the built-in pairing agent (org.bluez.Agent1).
+//
+// Callbacks run on D-Bus handler goroutines and may block while the user is asked.
+// A nil confirmation or authorization callback accepts the request (suitable for headless
+// boxes); a nil PIN/passkey entry callback rejects it.
+type AgentOptions struct {
+    // Capability defaults to AgentNoInputNoOutput.
+    Capability AgentCapability
+
+    // ConfirmPasskey reports whether passkey matches the one shown on the remote device.
+    ConfirmPasskey func(dev Device, passkey uint32) bool
+    // RequestPinCode returns the legacy PIN for dev; ok=false rejects pairing.
+    RequestPinCode func(dev Device) (pin string, ok bool)
+    // RequestPasskey returns the passkey shown on dev; ok=false rejects pairing.
+    RequestPasskey func(dev Device) (passkey uint32, ok bool)
+    // DisplayPinCode shows a PIN to be entered on dev.
+    DisplayPinCode func(dev Device, pin string)
+    // DisplayPasskey shows a passkey to be entered on dev; entered counts typed digits.
+    DisplayPasskey func(dev Device, passkey uint32, entered uint16)
+    // Authorize reports whether an incoming "just works" pairing from dev is allowed.
+    Authorize func(dev Device) bool
+    // AuthorizeService reports whether dev may connect to the service uuid.
+    AuthorizeService func(dev Device, uuid string) bool
+    // Canceled is called when BlueZ cancels a request still waiting for one of the prompts
+    // above (e.g. the remote side gave up). The request has already been answered; the
+    // prompt should be dismissed and its eventual result is ignored.
+    Canceled func(dev Device)
 }
 
 // Mgr is the single public interface for discovery and connections.
-// Responsibilities end at preparing FDs for the caller; reconnect is out of scope.
+// Responsibilities end at preparing FDs for the caller; reconnect is provided separately by Reconnector.
 type Mgr interface {
-    // StartServer registers an SPP profile (Role="server").
-    // After a successful call, use Accept to wait for exactly one incoming connection.
+    // StartServer registers an SPP (or opts.UUID) profile (Role="server").
+    // After a successful call, use Accept repeatedly to receive incoming connections.
+    // The profile stays registered until Close.
     // State/usage constraints:
     //   - Must be called before Accept; calling Accept without a prior StartServer returns an error.
-    //   - Calling StartServer more than once returns an error.
+    //   - Calling StartServer more than once returns an error. A call that failed (invalid options,
+    //     channel in use, RegisterProfile rejected) may be retried with different options.
     //   - A Mgr instance is single-role: if Connect has been used on this instance,
     //     StartServer returns an error (and vice versa).
-    //   - If the fixed RFCOMM Channel is already in use, an error is returned.
+    //   - Options are validated first; an invalid channel, PSM, UUID or malformed ServiceRecord
+    //     returns an error without contacting BlueZ.
+    //   - If the requested RFCOMM Channel is already in use, an error naming the channel is returned.
+    //   - An unknown opts.Adapter returns an error.
     StartServer(ctx context.Context, opts ServerOptions) error
 
     // Accept blocks until a connection is established or ctx is canceled.
     // It returns the peer device information and a Unix file descriptor (FD) that the caller owns.
     // The caller should wrap the FD with os.NewFile(uintptr(fd), "rfcomm") for I/O and must Close it.
     // Server semantics and state/usage constraints:
-    //   - Accept may be called at most once. Multiple connections or re-listen are not supported.
-    //   - After one connection has been accepted, any subsequent incoming connections must be rejected
-    //     or their FDs immediately closed by the implementation.
+    //   - Accept may be called repeatedly; each call returns the next incoming connection.
+    //     Connections arriving while no Accept is waiting are queued (up to ServerOptions.MaxPeers).
+    //   - Each accepted peer occupies a slot until the caller calls Release for it. While all
+    //     slots are taken, further incoming connections are rejected and their FDs closed by
+    //     the implementation. Every connection takes a slot, also a further one from a device
+    //     that already holds one.
     //   - Once Accept has returned an FD, the implementation must NOT close that FD later due to ctx
     //     cancellation or other internal events; ownership is entirely with the caller.
+    //   - Queued connections not yet returned by Accept are closed by Close.
     //   - If called before StartServer or after Close, returns an error.
     // remote resolution:
-    //   - The implementation should attempt to provide the peer's MAC at minimum.
-    //     If the peer information cannot be resolved at accept time, return the zero-value Device.
+    //   - Path, MAC, Adapter and UUID (the registered service UUID) are always set; Channel is the
+    //     RFCOMM channel of the connection when it can be read from the socket (otherwise the
+    //     configured ServerOptions.Channel), and Version is the profile version BlueZ reported.
+    //   - Name, Alias, Paired and Trusted come from the peer's Device1 object at connection time.
+    //   - ServiceName is the peer's own SDP ServiceName for the same service UUID (e.g. when the
+    //     peer also runs a chat server), if an earlier scan cached it. With
+    //     ServerOptions.LookupServiceName an uncached name is queried before Accept returns;
+    //     it stays empty if the peer has no such record or does not answer in time.
     Accept(ctx context.Context) (fd int, remote Device, err error)
 
+    // Release frees the server slot held by remote after the caller has finished with
+    // (and closed) the FD returned by Accept. It does not touch the FD. A device with several
+    // connections holds one slot per connection; each call frees one.
+    // Contract:
+    //   - Safe for concurrent use with Accept and other Release calls.
+    //   - Returns an error if the server is not started, after Close, or if remote holds no slot.
+    Release(remote Device) error
+
     // ScanSPP discovers nearby devices advertising SPP and returns a snapshot list.
-    // Only devices containing SPPUUID are included. Implementations may attempt to obtain SDP ServiceName
-    // for better display.
+    // Only devices containing SPPUUID (or opts.UUID) are included. While scanning, the remote SDP record of each
+    // candidate is queried for ServiceName and Channel; results are cached per device for the
+    // lifetime of the manager. Queries still running when ctx ends are abandoned, leaving
+    // ServiceName/Channel empty for that device.
     // Timing control is by the caller-provided context; use context.WithTimeout as needed.
+    // Results are sorted by RSSI (strongest first, unknown last), then by name and path.
     // Contract:
     //   - Each returned Device must have a non-empty Path.
     //   - May be called in any state except after Close; after Close returns an error.
-    ScanSPP(ctx context.Context) ([]Device, error)
+    ScanSPP(ctx context.Context, opts ScanOptions) ([]Device, error)
+
+    // WatchSPP starts discovery and streams changes to the set of devices advertising SPP
+    // until ctx ends, at which point discovery is stopped and the channel is closed.
+    // Devices already known to BlueZ are reported first as DeviceAdded. Afterwards
+    // InterfacesAdded/InterfacesRemoved and Device1 PropertiesChanged (UUIDs, RSSI, Name, Alias, ...)
+    // are tracked, so a device whose UUIDs are resolved late is still reported. SDP results
+    // (ServiceName, Channel) arrive as DeviceUpdated once resolved.
+    // Contract:
+    //   - Sends block; the caller must drain the channel until it is closed.
+    //   - May be called in any state except after Close; after Close returns an error.
+    //     Close does not end a running watch; cancel ctx for that.
+    //   - An unknown opts.Adapter returns an error.
+    WatchSPP(ctx context.Context, opts ScanOptions) (<-chan DeviceEvent, error)
+
+    // Adapters lists local adapters with their current state, sorted by path.
+    Adapters(ctx context.Context) ([]Adapter, error)
+
+    // SetAdapterPowered powers the adapter (hci name, address or path) on or off.
+    SetAdapterPowered(ctx context.Context, adapter string, on bool) error
+
+    // SetAdapterDiscoverable makes the adapter visible to inquiries. timeout 0 keeps it
+    // discoverable until turned off; otherwise BlueZ turns discoverability off after timeout
+    // (whole seconds).
+    SetAdapterDiscoverable(ctx context.Context, adapter string, on bool, timeout time.Duration) error
+
+    // SetAdapterPairable allows or refuses incoming pairing on the adapter.
+    SetAdapterPairable(ctx context.Context, adapter string, on bool) error
+
+    // KnownDevices lists devices BlueZ already knows (paired, previously seen or connected)
+    // without starting discovery, sorted by path. ServiceName/Channel are filled from the SDP
+    // cache of earlier scans on this manager. An unknown opts.Adapter returns an error.
+    KnownDevices(ctx context.Context, opts DeviceListOptions) ([]Device, error)
+
+    // RemoveDevice makes BlueZ forget dev (Adapter1.RemoveDevice): its pairing keys are deleted
+    // and it disappears from KnownDevices until discovered again. The adapter is taken from
+    // dev.Adapter, or from dev.Path if empty.
+    RemoveDevice(ctx context.Context, dev Device) error
+
+    // SetTrusted sets Device1.Trusted for dev. BlueZ lets trusted devices connect without
+    // service authorization by the agent; ServerOptions.TrustedOnly accepts only them.
+    SetTrusted(ctx context.Context, dev Device, trusted bool) error
+
+    // SetBlocked sets Device1.Blocked for dev. BlueZ refuses all connections from and to a
+    // blocked device and drops its current ones.
+    SetBlocked(ctx context.Context, dev Device, blocked bool) error
 
     // Connect initiates an outgoing connection to the given device.
-    // A client-side profile (Role="client") is registered internally as needed.
-    // If pairing is required, a pre-registered BlueZ Agent (external to this package) must handle it.
+    // A client-side profile (Role="client") for dev.UUID (SPPUUID if empty) is registered internally
+    // as needed, and Device1.ConnectProfile is called with the same UUID.
+    // If pairing is required, a BlueZ Agent must handle it: either one registered with RegisterAgent
+    // or a pre-registered agent external to this package.
     // Then it waits for Profile1.NewConnection to obtain an FD. The returned FD is owned by the caller.
     // State/usage constraints:
     //   - The provided dev.Path must be non-empty; if empty, returns an error immediately.
@@ -84,9 +404,34 @@ type Mgr interface {
     //   - Connect may be called at most once per manager instance.
     // Error policy:
     //   - Context cancellation and deadlines are propagated: errors wrapping context.Canceled or
-    //     context.DeadlineExceeded may be returned.
+    //     context.DeadlineExceeded may be returned. Cancellation interrupts a pending Pair or
+    //     ConnectProfile immediately; BlueZ is then told to stop (CancelPairing or
+    //     DisconnectProfile) and a connection delivered after cancellation is closed.
+    //   - Pairing and ConnectProfile failures are *OpError values classified as ErrAuthFailed,
+    //     ErrNotPaired, ErrRejected or ErrDeviceNotFound where BlueZ's error allows; others
+    //     (e.g. page timeout, device out of range) have a nil Kind and are usually worth retrying.
     Connect(ctx context.Context, dev Device) (fd int, err error)
 
+    // RegisterAgent exports a pairing agent (org.bluez.Agent1), registers it with AgentManager1
+    // and requests it as the default agent. The agent stays registered until Close.
+    // Contract:
+    //   - May be called in any role, at most once per manager instance.
+    //   - After Close returns an error.
+    RegisterAgent(ctx context.Context, opts AgentOptions) error
+
+    // Events returns the channel of profile lifecycle notifications. The same channel is
+    // returned on every call; it is closed by Close.
+    // Contract:
+    //   - Up to 16 events are buffered. Beyond that events are held back, in order, until the
+    //     reader catches up, so BlueZ is never blocked by a slow reader and no event is lost.
+    //   - Connections keep their FDs across these events: the manager never closes an FD it
+    //     handed out, so the caller acts on EventDisconnected itself.
+    //   - When bluetoothd restarts (NameOwnerChanged for the BlueZ bus name), every device
+    //     holding a slot gets EventDisconnected and its slots are freed (Release is no longer
+    //     needed for it), then registered server and client profiles are registered again
+    //     automatically.
+    Events() <-chan Event
+
     // Close releases resources held by the manager (e.g., D-Bus objects, signal subscriptions).
     // Contract:
     //   - Safe for concurrent use; redundant calls are allowed (idempotent).
@@ -0,0 +1,150 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "errors"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+// connect calls Connect with a short timeout and returns the fake's end of the link too.
+func (f *fixture) connect(t *testing.T, m connmgr.Mgr, dev connmgr.Device) (int, *os.File) {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    fd, err := m.Connect(ctx, dev)
+    if err != nil {
+        t.Fatalf("Connect: %v", err)
+    }
+    select {
+    case l := <-f.bz.Links():
+        t.Cleanup(func() { l.File.Close() })
+        return fd, l.File
+    case <-time.After(5 * time.Second):
+        t.Fatal("fake saw no link")
+    }
+    return -1, nil
+}
+
+func TestConnect(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Paired: true})
+    fd, remote := f.connect(t, f.m, connmgr.Device{Path: string(p)})
+    defer syscall.Close(fd)
+
+    if _, err := syscall.Write(fd, []byte("ping")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 4)
+    if _, err := remote.Read(buf); err != nil || string(buf) != "ping" {
+        t.Fatalf("remote read %q, %v", buf, err)
+    }
+    var client bool
+    for _, prof := range f.bz.Profiles() {
+        client = client || (prof.Role() == "client" && prof.UUID == connmgr.SPPUUID)
+    }
+    if !client {
+        t.Error("no client profile registered")
+    }
+
+    if _, err := f.m.Connect(context.Background(), connmgr.Device{Path: string(p)}); !errors.Is(err, connmgr.ErrAlreadyUsed) {
+        t.Errorf("second Connect: got %v, want ErrAlreadyUsed", err)
+    }
+    if err := f.m.StartServer(context.Background(), connmgr.ServerOptions{ServiceName: "x"}); !errors.Is(err, connmgr.ErrRoleConflict) {
+        t.Errorf("StartServer on a client: got %v, want ErrRoleConflict", err)
+    }
+}
+
+func TestConnectPairs(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    fd, _ := f.connect(t, f.m, connmgr.Device{Path: string(p)})
+    syscall.Close(fd)
+    if v, _ := f.bz.Property(p, "org.bluez.Device1", "Paired"); v != true {
+        t.Error("device not paired by Connect")
+    }
+}
+
+func TestConnectCancel(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", PairDelay: time.Minute})
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(50*time.Millisecond, cancel)
+    start := time.Now()
+    _, err := f.m.Connect(ctx, connmgr.Device{Path: string(p)})
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("got %v, want context.Canceled", err)
+    }
+    if d := time.Since(start); d > 5*time.Second {
+        t.Errorf("Connect returned %s after cancellation", d)
+    }
+    // BlueZ was told to stop pairing: nothing is left to cancel.
+    bc, err := f.d.Dial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer bc.Close()
+    if err := bc.Object("org.bluez", p).Call("org.bluez.Device1.CancelPairing", 0).Err; err == nil {
+        t.Error("pairing still in progress after Connect was canceled")
+    }
+}
+
+func TestConnectDeadline(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", PairDelay: time.Minute})
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if _, err := f.m.Connect(ctx, connmgr.Device{Path: string(p)}); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want context.DeadlineExceeded", err)
+    }
+}
+
+func TestConnectErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        dev  fakebluez.Device
+        want error // nil: an *OpError without Kind
+    }{
+        {"pair failed", fakebluez.Device{PairError: "org.bluez.Error.AuthenticationFailed"}, connmgr.ErrAuthFailed},
+        {"pair rejected", fakebluez.Device{PairError: "org.bluez.Error.AuthenticationRejected"}, connmgr.ErrAuthFailed},
+        {"connect rejected", fakebluez.Device{Paired: true, ConnectError: "org.bluez.Error.Rejected"}, connmgr.ErrRejected},
+        {"connect not authorized", fakebluez.Device{Paired: true, ConnectError: "org.bluez.Error.NotAuthorized"}, connmgr.ErrRejected},
+        {"connect failed", fakebluez.Device{Paired: true, ConnectError: "org.bluez.Error.Failed"}, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newFixture(t)
+            tt.dev.Address = "AA:AA:AA:AA:AA:01"
+            p := f.addDevice(t, tt.dev)
+            _, err := f.m.Connect(context.Background(), connmgr.Device{Path: string(p)})
+            var opErr *connmgr.OpError
+            if !errors.As(err, &opErr) {
+                t.Fatalf("got %v (%T), want *OpError", err, err)
+            }
+            if tt.want == nil {
+                if opErr.Kind != nil {
+                    t.Fatalf("got Kind %v, want none", opErr.Kind)
+                }
+                return
+            }
+            if !errors.Is(err, tt.want) {
+                t.Fatalf("got %v, want %v", err, tt.want)
+            }
+        })
+    }
+
+    f := newFixture(t)
+    if _, err := f.m.Connect(context.Background(), connmgr.Device{Path: string(f.hci0) + "/dev_00_00_00_00_00_00"}); !errors.Is(err, connmgr.ErrDeviceNotFound) {
+        t.Errorf("unknown device: got %v, want ErrDeviceNotFound", err)
+    }
+    if _, err := f.newMgr(t).Connect(context.Background(), connmgr.Device{}); err == nil {
+        t.Error("empty path: no error")
+    }
+}
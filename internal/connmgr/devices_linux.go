@@ -0,0 +1,104 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+func (m *mgr) KnownDevices(ctx context.Context, opts DeviceListOptions) ([]Device, error) {
+    uuid := SPPUUID
+    if opts.UUID != "" && !opts.AllServices {
+        if _, err := parseUUID128(opts.UUID); err != nil {
+            return nil, err
+        }
+        uuid = strings.ToLower(opts.UUID)
+    }
+    bus, err := m.busForCall()
+    if err != nil {
+        return nil, err
+    }
+    adapter, err := m.resolveAdapter(ctx, bus, opts.Adapter)
+    if err != nil {
+        return nil, err
+    }
+    objs, err := m.managedObjects(ctx, bus)
+    if err != nil {
+        return nil, err
+    }
+    var out []Device
+    for p, ifaces := range objs {
+        if !onAdapter(p, adapter) {
+            continue
+        }
+        var (
+            d  Device
+            ok bool
+        )
+        if opts.AllServices {
+            var props map[string]dbus.Variant
+            if props, ok = ifaces[deviceIface]; ok {
+                d = deviceFromProps(p, props)
+            }
+        } else if d, ok = deviceFromIfaces(p, ifaces, uuid); ok {
+            d = m.withSDP(d)
+        }
+        if !ok || (opts.PairedOnly && !d.Paired) {
+            continue
+        }
+        out = append(out, d)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+    return out, nil
+}
+
+func (m *mgr) RemoveDevice(ctx context.Context, dev Device) error {
+    if dev.Path == "" {
+        return ErrDeviceNotFound
+    }
+    adapter := dev.Adapter
+    if adapter == "" {
+        adapter = string(adapterFromPath(dbus.ObjectPath(dev.Path)))
+    }
+    if adapter == "" {
+        return fmt.Errorf("%w: no adapter in %q", ErrDeviceNotFound, dev.Path)
+    }
+    bus, err := m.busForCall()
+    if err != nil {
+        return err
+    }
+    call := bus.Object(m.cfg.service, dbus.ObjectPath(adapter)).CallWithContext(ctx, adapterIface+".RemoveDevice", 0, dbus.ObjectPath(dev.Path))
+    if call.Err != nil {
+        return opError("RemoveDevice", call.Err)
+    }
+    return nil
+}
+
+func (m *mgr) SetTrusted(ctx context.Context, dev Device, trusted bool) error {
+    return m.setDeviceProp(ctx, dev, "Trusted", trusted)
+}
+
+func (m *mgr) SetBlocked(ctx context.Context, dev Device, blocked bool) error {
+    return m.setDeviceProp(ctx, dev, "Blocked", blocked)
+}
+
+// setDeviceProp sets a writable Device1 property of dev.
+func (m *mgr) setDeviceProp(ctx context.Context, dev Device, name string, value interface{}) error {
+    if dev.Path == "" {
+        return ErrDeviceNotFound
+    }
+    bus, err := m.busForCall()
+    if err != nil {
+        return err
+    }
+    call := bus.Object(m.cfg.service, dbus.ObjectPath(dev.Path)).CallWithContext(ctx, propsIface+".Set", 0, deviceIface, name, dbus.MakeVariant(value))
+    if call.Err != nil {
+        return opError("Set "+name, call.Err)
+    }
+    return nil
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+const chatUUID = "5c1a0e10-0000-1000-8000-00805f9b34fb"
+
+func TestKnownDevices(t *testing.T) {
+    f := newFixture(t)
+    hci1, err := f.bz.AddAdapter("hci1", "66:77:88:99:AA:BB")
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Name: "paired", UUIDs: []string{connmgr.SPPUUID}, Paired: true, Trusted: true})
+    f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:02", Name: "seen", UUIDs: []string{connmgr.SPPUUID}})
+    f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:03", Name: "headset", UUIDs: []string{"0000110b-0000-1000-8000-00805f9b34fb"}, Paired: true})
+    f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:04", Name: "chat", UUIDs: []string{chatUUID}})
+    if _, err := f.bz.AddDevice(hci1, fakebluez.Device{Address: "AA:AA:AA:AA:AA:05", Name: "other", UUIDs: []string{connmgr.SPPUUID}}); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        opts connmgr.DeviceListOptions
+        want []string
+    }{
+        {"spp", connmgr.DeviceListOptions{}, []string{"paired", "seen", "other"}},
+        {"paired only", connmgr.DeviceListOptions{PairedOnly: true}, []string{"paired"}},
+        {"adapter", connmgr.DeviceListOptions{Adapter: "hci1"}, []string{"other"}},
+        {"uuid", connmgr.DeviceListOptions{UUID: chatUUID}, []string{"chat"}},
+        {"uppercase uuid", connmgr.DeviceListOptions{UUID: "5C1A0E10-0000-1000-8000-00805F9B34FB"}, []string{"chat"}},
+        {"all services", connmgr.DeviceListOptions{AllServices: true, Adapter: "hci0"}, []string{"paired", "seen", "headset", "chat"}},
+        {"all services paired", connmgr.DeviceListOptions{AllServices: true, PairedOnly: true}, []string{"paired", "headset"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            devs, err := f.m.KnownDevices(context.Background(), tt.opts)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := names(devs); !equal(got, tt.want) {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+
+    devs, err := f.m.KnownDevices(context.Background(), connmgr.DeviceListOptions{PairedOnly: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if d := devs[0]; d.MAC != "AA:AA:AA:AA:AA:01" || !d.Trusted || d.Adapter != string(f.hci0) {
+        t.Errorf("device %+v: want MAC, Trusted and Adapter filled in", d)
+    }
+    if _, err := f.m.KnownDevices(context.Background(), connmgr.DeviceListOptions{UUID: "spp"}); err == nil {
+        t.Error("invalid UUID accepted")
+    }
+}
+
+func TestTrustAndBlock(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    dev := connmgr.Device{Path: string(p)}
+    ctx := context.Background()
+    prop := func(name string) interface{} {
+        v, _ := f.bz.Property(p, "org.bluez.Device1", name)
+        return v
+    }
+
+    for _, on := range []bool{true, false} {
+        if err := f.m.SetTrusted(ctx, dev, on); err != nil {
+            t.Fatal(err)
+        }
+        if prop("Trusted") != on {
+            t.Errorf("Trusted=%v, want %v", prop("Trusted"), on)
+        }
+        if err := f.m.SetBlocked(ctx, dev, on); err != nil {
+            t.Fatal(err)
+        }
+        if prop("Blocked") != on {
+            t.Errorf("Blocked=%v, want %v", prop("Blocked"), on)
+        }
+    }
+
+    // Not below an exported object: godbus searches those for fallback handlers without
+    // locking, which races with the fake unexporting them at the end of the test.
+    gone := connmgr.Device{Path: "/org/bluez_gone/dev_00_00_00_00_00_00"}
+    if err := f.m.SetTrusted(ctx, gone, true); !errors.Is(err, connmgr.ErrDeviceNotFound) {
+        t.Errorf("unknown device: got %v, want ErrDeviceNotFound", err)
+    }
+    if err := f.m.SetBlocked(ctx, connmgr.Device{}, true); !errors.Is(err, connmgr.ErrDeviceNotFound) {
+        t.Errorf("empty path: got %v, want ErrDeviceNotFound", err)
+    }
+}
+
+func TestRemoveDevice(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Name: "a", UUIDs: []string{connmgr.SPPUUID}, Paired: true})
+    f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:02", Name: "b", UUIDs: []string{connmgr.SPPUUID}, Paired: true})
+    ctx := context.Background()
+
+    // The adapter is derived from the path when dev.Adapter is empty.
+    if err := f.m.RemoveDevice(ctx, connmgr.Device{Path: string(p)}); err != nil {
+        t.Fatal(err)
+    }
+    devs, err := f.m.KnownDevices(ctx, connmgr.DeviceListOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := names(devs); !equal(got, []string{"b"}) {
+        t.Errorf("after RemoveDevice: %q, want [b]", got)
+    }
+    if err := f.m.RemoveDevice(ctx, devs[0]); err != nil {
+        t.Fatalf("remove by listed device: %v", err)
+    }
+
+    if err := f.m.RemoveDevice(ctx, connmgr.Device{Path: string(p)}); !errors.Is(err, connmgr.ErrDeviceNotFound) {
+        t.Errorf("removed twice: got %v, want ErrDeviceNotFound", err)
+    }
+    for _, d := range []connmgr.Device{{}, {Path: "/org/bluez"}} {
+        if err := f.m.RemoveDevice(ctx, d); !errors.Is(err, connmgr.ErrDeviceNotFound) {
+            t.Errorf("path %q: got %v, want ErrDeviceNotFound", d.Path, err)
+        }
+    }
+}
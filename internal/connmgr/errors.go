@@ -0,0 +1,76 @@
+package connmgr
+
+import (
+    "errors"
+    "strings"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+// Sentinel errors. Errors returned by Mgr match them with errors.Is; errors caused by a
+// BlueZ D-Bus call are *OpError values that also unwrap to the underlying dbus.Error.
+var (
+    // ErrClosed: the manager was closed.
+    ErrClosed = errors.New("connmgr: closed")
+    // ErrRoleConflict: the manager is already used in the other role (server vs. client).
+    ErrRoleConflict = errors.New("connmgr: role conflict")
+    // ErrAlreadyUsed: a once-per-manager operation (StartServer, Connect, RegisterAgent) was
+    // repeated, or BlueZ already has the profile/agent registered.
+    ErrAlreadyUsed = errors.New("connmgr: already used")
+    // ErrNotPaired: the remote device refused the link because no bonding (link key) exists.
+    ErrNotPaired = errors.New("connmgr: device not paired")
+    // ErrAuthFailed: pairing failed, was rejected, canceled or timed out.
+    ErrAuthFailed = errors.New("connmgr: authentication failed")
+    // ErrChannelInUse: the requested RFCOMM channel is bound by another server.
+    ErrChannelInUse = errors.New("connmgr: RFCOMM channel in use")
+    // ErrNoAdapter: no usable adapter (unknown selector, none present, powered off, or
+    // BlueZ not running).
+    ErrNoAdapter = errors.New("connmgr: no adapter")
+    // ErrDeviceNotFound: the device object does not exist (never discovered or removed).
+    ErrDeviceNotFound = errors.New("connmgr: device not found")
+    // ErrRejected: the remote side or BlueZ refused the request (connection refused,
+    // not authorized).
+    ErrRejected = errors.New("connmgr: rejected")
+)
+
+// OpError describes a failed BlueZ operation.
+type OpError struct {
+    Op   string // BlueZ method, e.g. "Pair", "ConnectProfile", "RegisterProfile(server)"
+    Kind error  // one of the sentinel errors, or nil if the failure is not classified
+    Err  error  // underlying error, usually a dbus.Error
+}
+
+func (e *OpError) Error() string {
+    var b strings.Builder
+    b.WriteString("connmgr: ")
+    b.WriteString(e.Op)
+    if e.Kind != nil {
+        b.WriteString(": ")
+        b.WriteString(strings.TrimPrefix(e.Kind.Error(), "connmgr: "))
+    }
+    if e.Err != nil {
+        b.WriteString(": ")
+        b.WriteString(describe(e.Err))
+    }
+    return b.String()
+}
+
+// Unwrap lets errors.Is match Kind and errors.As reach Err.
+func (e *OpError) Unwrap() []error {
+    if e.Kind == nil {
+        return []error{e.Err}
+    }
+    return []error{e.Kind, e.Err}
+}
+
+// describe renders D-Bus errors with their name, since dbus.Error.Error shows only the message.
+func describe(err error) string {
+    var dErr dbus.Error
+    if errors.As(err, &dErr) {
+        if msg := dErr.Error(); msg != dErr.Name {
+            return dErr.Name + ": " + msg
+        }
+        return dErr.Name
+    }
+    return err.Error()
+}
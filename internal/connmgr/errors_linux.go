@@ -0,0 +1,63 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "errors"
+    "strings"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+// errorKinds maps D-Bus error names to sentinel errors.
+var errorKinds = map[string]error{
+    "org.bluez.Error.AuthenticationFailed":   ErrAuthFailed,
+    "org.bluez.Error.AuthenticationCanceled": ErrAuthFailed,
+    "org.bluez.Error.AuthenticationRejected": ErrAuthFailed,
+    "org.bluez.Error.AuthenticationTimeout":  ErrAuthFailed,
+    "org.bluez.Error.Rejected":               ErrRejected,
+    "org.bluez.Error.NotAuthorized":          ErrRejected,
+    "org.bluez.Error.AlreadyExists":          ErrAlreadyUsed,
+    "org.bluez.Error.DoesNotExist":           ErrDeviceNotFound,
+    "org.bluez.Error.NotReady":               ErrNoAdapter,
+
+    "org.freedesktop.DBus.Error.UnknownObject":    ErrDeviceNotFound,
+    "org.freedesktop.DBus.Error.UnknownInterface": ErrDeviceNotFound,
+    "org.freedesktop.DBus.Error.ServiceUnknown":   ErrNoAdapter,
+    "org.freedesktop.DBus.Error.NameHasNoOwner":   ErrNoAdapter,
+}
+
+// errorReasons classifies org.bluez.Error.Failed by the reason string BlueZ puts in the
+// message (see BlueZ src/error.h), e.g. "br-connection-key-missing".
+var errorReasons = []struct {
+    substr string
+    kind   error
+}{
+    {"key-missing", ErrNotPaired},
+    {"refused", ErrRejected},
+    {"not-authorized", ErrRejected},
+    {"Authentication", ErrAuthFailed},
+}
+
+// opError wraps the error of a BlueZ call as *OpError, classifying D-Bus errors.
+func opError(op string, err error) error {
+    return &OpError{Op: op, Kind: classify(err), Err: err}
+}
+
+// classify returns the sentinel for a D-Bus error, or nil.
+func classify(err error) error {
+    var dErr dbus.Error
+    if !errors.As(err, &dErr) {
+        return nil
+    }
+    if kind, ok := errorKinds[dErr.Name]; ok {
+        return kind
+    }
+    msg := dErr.Error()
+    for _, r := range errorReasons {
+        if strings.Contains(msg, r.substr) {
+            return r.kind
+        }
+    }
+    return nil
+}
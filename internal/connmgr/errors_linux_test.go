@@ -0,0 +1,46 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+func TestClassify(t *testing.T) {
+    tests := []struct {
+        name, msg string
+        want      error
+    }{
+        {"org.bluez.Error.AuthenticationFailed", "", ErrAuthFailed},
+        {"org.bluez.Error.AuthenticationTimeout", "", ErrAuthFailed},
+        {"org.bluez.Error.Rejected", "", ErrRejected},
+        {"org.bluez.Error.AlreadyExists", "", ErrAlreadyUsed},
+        {"org.bluez.Error.DoesNotExist", "", ErrDeviceNotFound},
+        {"org.bluez.Error.NotReady", "", ErrNoAdapter},
+        {"org.freedesktop.DBus.Error.UnknownObject", "", ErrDeviceNotFound},
+        {"org.freedesktop.DBus.Error.ServiceUnknown", "", ErrNoAdapter},
+        {"org.bluez.Error.Failed", "br-connection-key-missing", ErrNotPaired},
+        {"org.bluez.Error.Failed", "br-connection-refused", ErrRejected},
+        {"org.bluez.Error.Failed", "Authentication Rejected", ErrAuthFailed},
+        {"org.bluez.Error.Failed", "br-connection-page-timeout", nil},
+        {"org.bluez.Error.InProgress", "", nil},
+    }
+    for _, tt := range tests {
+        err := dbus.Error{Name: tt.name, Body: []interface{}{tt.msg}}
+        if got := classify(err); got != tt.want {
+            t.Errorf("%s %q: got %v, want %v", tt.name, tt.msg, got, tt.want)
+        }
+        // Wrapped errors are classified too, and OpError exposes the kind to errors.Is.
+        op := opError("Test", fmt.Errorf("call: %w", err))
+        if tt.want != nil && !errors.Is(op, tt.want) {
+            t.Errorf("%s %q: %v is not %v", tt.name, tt.msg, op, tt.want)
+        }
+    }
+    if got := classify(errors.New("plain")); got != nil {
+        t.Errorf("non-D-Bus error classified as %v", got)
+    }
+}
@@ -0,0 +1,191 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+    // eventBuffer is the capacity of the Events channel.
+    eventBuffer = 16
+    // reregisterTimeout bounds each RegisterProfile call after bluetoothd restarted.
+    reregisterTimeout = 5 * time.Second
+)
+
+const (
+    busService = "org.freedesktop.DBus"
+    busIface   = "org.freedesktop.DBus"
+)
+
+// registration is a profile registered with RegisterProfile, kept to re-register it after
+// bluetoothd restarts. live is guarded by mgr.mu.
+type registration struct {
+    role    string // "server" or "client"
+    prof    *profile
+    path    dbus.ObjectPath
+    uuid    string
+    options map[string]dbus.Variant
+    live    bool
+}
+
+// newRegistration hooks prof's notifications to the manager's Events channel.
+// The caller fills in path, uuid and options once RegisterProfile succeeded.
+func (m *mgr) newRegistration(role string, prof *profile) *registration {
+    reg := &registration{role: role, prof: prof}
+    prof.notify = func(t EventType, d Device) { m.profileEvent(reg, t, d) }
+    return reg
+}
+
+// trackLocked records a successful registration and starts watching the BlueZ bus name.
+func (m *mgr) trackLocked(reg *registration) {
+    reg.live = true
+    m.regs = append(m.regs, reg)
+    m.watchOwnerLocked()
+}
+
+func (m *mgr) Events() <-chan Event { return m.events }
+
+// profileEvent forwards a Profile1 notification. EventReleased is reported once per
+// registration, whichever of Profile1.Release and the loss of the bus name comes first.
+func (m *mgr) profileEvent(reg *registration, t EventType, d Device) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if t == EventReleased {
+        if !reg.live {
+            return
+        }
+        reg.live = false
+    }
+    m.emitLocked(Event{Type: t, Role: reg.role, Device: d})
+}
+
+// emitLocked sends ev without blocking. When the buffer is full the event is not dropped
+// but queued for the pump, after any events already waiting, so the order is kept.
+func (m *mgr) emitLocked(ev Event) {
+    if m.closed {
+        return
+    }
+    if len(m.backlog) == 0 {
+        select {
+        case m.events <- ev:
+            return
+        default:
+        }
+    }
+    m.backlog = append(m.backlog, ev)
+    if !m.pumping {
+        m.pumping = true
+        m.pumpWG.Add(1)
+        go m.pump()
+    }
+}
+
+// pump delivers the backlog, blocking until the reader makes room or the manager closes.
+func (m *mgr) pump() {
+    defer m.pumpWG.Done()
+    for {
+        m.mu.Lock()
+        if m.closed || len(m.backlog) == 0 {
+            m.backlog = nil
+            m.pumping = false
+            m.mu.Unlock()
+            return
+        }
+        ev := m.backlog[0]
+        m.mu.Unlock()
+        select {
+        case m.events <- ev:
+            m.mu.Lock()
+            m.backlog = m.backlog[1:]
+            m.mu.Unlock()
+        case <-m.quit:
+        }
+    }
+}
+
+// watchOwnerLocked subscribes to NameOwnerChanged for the BlueZ name (once per manager).
+// Close stops the watch before it runs the cleanups, so it is gone before profiles are
+// unregistered.
+func (m *mgr) watchOwnerLocked() {
+    if m.stopWatch != nil {
+        return
+    }
+    bus := m.bus
+    match := []dbus.MatchOption{
+        dbus.WithMatchSender(busService),
+        dbus.WithMatchInterface(busIface),
+        dbus.WithMatchMember("NameOwnerChanged"),
+        dbus.WithMatchArg(0, m.cfg.service),
+    }
+    if err := bus.AddMatchSignal(match...); err != nil {
+        m.cfg.logger.Printf("connmgr: watch %s: %v (profiles will not be re-registered)", m.cfg.service, err)
+        return
+    }
+    sigCh := make(chan *dbus.Signal, 16)
+    bus.Signal(sigCh)
+    done := make(chan struct{})
+    finished := make(chan struct{})
+    go func() {
+        defer close(finished)
+        for {
+            select {
+            case <-done:
+                return
+            case sig := <-sigCh:
+                if sig != nil && sig.Name == busIface+".NameOwnerChanged" && len(sig.Body) >= 3 {
+                    name, _ := sig.Body[0].(string)
+                    oldOwner, _ := sig.Body[1].(string)
+                    newOwner, _ := sig.Body[2].(string)
+                    if name == m.cfg.service {
+                        m.ownerChanged(bus, oldOwner, newOwner)
+                    }
+                }
+            }
+        }
+    }()
+    m.stopWatch = func() {
+        close(done)
+        <-finished
+        bus.RemoveSignal(sigCh)
+        _ = bus.RemoveMatchSignal(match...)
+    }
+}
+
+// ownerChanged handles bluetoothd stopping (newOwner "") or starting: registrations held by
+// the old instance are gone, so they are reported released and registered with the new one.
+func (m *mgr) ownerChanged(bus *dbus.Conn, oldOwner, newOwner string) {
+    m.mu.Lock()
+    regs := append([]*registration(nil), m.regs...)
+    m.mu.Unlock()
+
+    if oldOwner != "" {
+        // The links of the old instance are gone with it: their slots are freed and each
+        // holder is told to close its FD.
+        for _, reg := range regs {
+            for _, dev := range reg.prof.releaseAll() {
+                m.profileEvent(reg, EventDisconnected, Device{Path: string(dev), MAC: macFromPath(dev)})
+            }
+            m.profileEvent(reg, EventReleased, Device{})
+        }
+    }
+    if newOwner == "" {
+        return
+    }
+    pm := bus.Object(m.cfg.service, dbus.ObjectPath("/org/bluez"))
+    for _, reg := range regs {
+        ctx, cancel := context.WithTimeout(context.Background(), reregisterTimeout)
+        var err error
+        if call := pm.CallWithContext(ctx, profileManagerIface+".RegisterProfile", 0, reg.path, reg.uuid, reg.options); call.Err != nil {
+            err = opError("RegisterProfile("+reg.role+")", call.Err)
+        }
+        cancel()
+        m.mu.Lock()
+        reg.live = err == nil
+        m.emitLocked(Event{Type: EventReregistered, Role: reg.role, Err: err})
+        m.mu.Unlock()
+    }
+}
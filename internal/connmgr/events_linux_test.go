@@ -0,0 +1,148 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "fmt"
+    "syscall"
+    "testing"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+func TestEventsNotDroppedWhenBufferFull(t *testing.T) {
+    f := newFixture(t)
+    const n = 40 // well beyond the 16 buffered events
+    f.startServer(t, connmgr.ServerOptions{MaxPeers: n})
+    var devs []connmgr.Device
+    for i := 0; i < n; i++ {
+        p := f.addDevice(t, fakebluez.Device{Address: fmt.Sprintf("AA:AA:AA:AA:AA:%02X", i)})
+        if _, err := f.bz.Connect(p, connmgr.SPPUUID); err != nil {
+            t.Fatal(err)
+        }
+        fd, dev := f.accept(t)
+        defer syscall.Close(fd)
+        devs = append(devs, dev)
+    }
+    // Nobody reads Events while BlueZ asks for all disconnections, twice each.
+    for _, d := range devs {
+        for j := 0; j < 2; j++ {
+            if err := f.bz.RequestDisconnection(f.path(d), connmgr.SPPUUID); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    // Every request is reported, in order.
+    for _, d := range devs {
+        for j := 0; j < 2; j++ {
+            if ev := nextEvent(t, f.m); ev.Type != connmgr.EventDisconnected || ev.Device.Path != d.Path {
+                t.Fatalf("got %s for %s, want disconnected for %s", ev.Type, ev.Device.Path, d.Path)
+            }
+        }
+    }
+    select {
+    case ev := <-f.m.Events():
+        t.Errorf("extra %s event", ev.Type)
+    default:
+    }
+}
+
+func TestRestartFreesSlots(t *testing.T) {
+    f := newFixture(t)
+    a := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    f.startServer(t, connmgr.ServerOptions{MaxPeers: 1})
+    if _, err := f.bz.Connect(a, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    fd, dev := f.accept(t)
+    defer syscall.Close(fd)
+
+    // bluetoothd goes away and comes back on a new connection.
+    if err := f.bz.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if ev := nextEvent(t, f.m); ev.Type != connmgr.EventDisconnected || ev.Device.Path != dev.Path {
+        t.Fatalf("first event %+v, want disconnected for %s", ev, dev.Path)
+    }
+    if ev := nextEvent(t, f.m); ev.Type != connmgr.EventReleased {
+        t.Fatalf("second event %s, want released", ev.Type)
+    }
+    bz := f.restart(t)
+    if ev := nextEvent(t, f.m); ev.Type != connmgr.EventReregistered || ev.Err != nil {
+        t.Fatalf("third event %+v, want reregistered", ev)
+    }
+    if err := f.m.Release(dev); err == nil {
+        t.Fatal("slot still held after restart")
+    }
+    a, err := bz.AddDevice(f.hci0, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := bz.Connect(a, connmgr.SPPUUID); err != nil {
+        t.Fatalf("connect after restart: %v", err)
+    }
+    fd2, _ := f.accept(t)
+    syscall.Close(fd2)
+}
+
+func TestEventsReleaseAndReregister(t *testing.T) {
+    f := newFixture(t)
+    f.startServer(t, connmgr.ServerOptions{})
+
+    // Profile1.Release, then bluetoothd going away: released is reported once.
+    if err := f.bz.ReleaseProfiles(); err != nil {
+        t.Fatal(err)
+    }
+    if ev := nextEvent(t, f.m); ev.Type != connmgr.EventReleased || ev.Role != "server" {
+        t.Fatalf("got %+v, want server released", ev)
+    }
+    if err := f.bz.Close(); err != nil {
+        t.Fatal(err)
+    }
+    f.restart(t)
+    ev := nextEvent(t, f.m)
+    if ev.Type != connmgr.EventReregistered || ev.Role != "server" || ev.Err != nil {
+        t.Fatalf("got %+v, want server reregistered", ev)
+    }
+    profiles := f.bz.Profiles()
+    if len(profiles) != 1 || profiles[0].Role() != "server" || profiles[0].Options["Name"].Value() != "test" {
+        t.Fatalf("profiles after restart: %+v", profiles)
+    }
+
+    // The re-registered profile delivers connections again.
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    if _, err := f.bz.Connect(p, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    fd, _ := f.accept(t)
+    syscall.Close(fd)
+}
+
+func TestEventsDisconnectRequest(t *testing.T) {
+    f := newFixture(t)
+    a := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    b := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:02"})
+    f.startServer(t, connmgr.ServerOptions{})
+    if _, err := f.bz.Connect(a, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    fd, _ := f.accept(t)
+    defer syscall.Close(fd)
+
+    // A device without a connection from this profile is ignored.
+    if err := f.bz.RequestDisconnection(b, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    if err := f.bz.RequestDisconnection(a, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    ev := nextEvent(t, f.m)
+    if ev.Type != connmgr.EventDisconnected || ev.Device.Path != string(a) || ev.Device.MAC != "AA:AA:AA:AA:AA:01" {
+        t.Fatalf("got %+v, want disconnected for A", ev)
+    }
+    // The FD is the caller's: the link still works.
+    if _, err := syscall.Write(fd, []byte("x")); err != nil {
+        t.Fatalf("FD closed by the manager: %v", err)
+    }
+}
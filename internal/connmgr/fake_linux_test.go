@@ -0,0 +1,135 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "os/exec"
+    "testing"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+// fixture is a fake bluetoothd with one adapter on a private bus, and a manager talking to it.
+type fixture struct {
+    d    *fakebluez.Daemon
+    bz   *fakebluez.BlueZ
+    hci0 dbus.ObjectPath
+    m    connmgr.Mgr
+}
+
+// newFixture starts the fake; tests are skipped if dbus-daemon is not installed.
+func newFixture(t *testing.T) *fixture {
+    t.Helper()
+    if _, err := exec.LookPath("dbus-daemon"); err != nil {
+        t.Skip("dbus-daemon not found in PATH")
+    }
+    d, err := fakebluez.StartDaemon()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { d.Close() })
+    bc, err := d.Dial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { bc.Close() })
+    bz, err := fakebluez.New(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { bz.Close() })
+    hci0, err := bz.AddAdapter("hci0", "00:11:22:33:44:55")
+    if err != nil {
+        t.Fatal(err)
+    }
+    f := &fixture{d: d, bz: bz, hci0: hci0}
+    f.m = f.newMgr(t)
+    return f
+}
+
+// newMgr returns another manager on its own bus connection, closed with the test.
+func (f *fixture) newMgr(t *testing.T) connmgr.Mgr {
+    t.Helper()
+    cc, err := f.d.Dial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { cc.Close() })
+    m := connmgr.New(connmgr.WithConn(cc))
+    t.Cleanup(func() { m.Close() })
+    return m
+}
+
+// addDevice adds a device to hci0.
+func (f *fixture) addDevice(t *testing.T, d fakebluez.Device) dbus.ObjectPath {
+    t.Helper()
+    p, err := f.bz.AddDevice(f.hci0, d)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return p
+}
+
+// startServer registers an SPP server with opts (ServiceName defaults to "test").
+func (f *fixture) startServer(t *testing.T, opts connmgr.ServerOptions) {
+    t.Helper()
+    if opts.ServiceName == "" {
+        opts.ServiceName = "test"
+    }
+    if err := f.m.StartServer(context.Background(), opts); err != nil {
+        t.Fatalf("StartServer: %v", err)
+    }
+}
+
+// accept calls Accept with a short timeout.
+func (f *fixture) accept(t *testing.T) (int, connmgr.Device) {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    fd, dev, err := f.m.Accept(ctx)
+    if err != nil {
+        t.Fatalf("Accept: %v", err)
+    }
+    return fd, dev
+}
+
+// path returns the object path of d.
+func (f *fixture) path(d connmgr.Device) dbus.ObjectPath { return dbus.ObjectPath(d.Path) }
+
+// restart replaces the fake bluetoothd with a new instance on a new bus connection, as after
+// bluetoothd restarted; the old one must have been closed. hci0 is added again.
+func (f *fixture) restart(t *testing.T) *fakebluez.BlueZ {
+    t.Helper()
+    bc, err := f.d.Dial()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { bc.Close() })
+    bz, err := fakebluez.New(bc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { bz.Close() })
+    if f.hci0, err = bz.AddAdapter("hci0", "00:11:22:33:44:55"); err != nil {
+        t.Fatal(err)
+    }
+    f.bz = bz
+    return bz
+}
+
+// nextEvent waits for the next manager event.
+func nextEvent(t *testing.T, m connmgr.Mgr) connmgr.Event {
+    t.Helper()
+    select {
+    case ev := <-m.Events():
+        return ev
+    case <-time.After(5 * time.Second):
+        t.Fatal("no event")
+    }
+    return connmgr.Event{}
+}
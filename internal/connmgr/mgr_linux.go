@@ -6,22 +6,25 @@ import (
     "context"
     "errors"
     "fmt"
+    "log"
     "os"
+    "sort"
     "strconv"
     "strings"
     "sync"
     "sync/atomic"
+    "time"
 
     dbus "github.com/godbus/dbus/v5"
+    "golang.org/x/sys/unix"
 )
 
-// New creates a new manager instance.
-func New() Mgr {
-    return &mgr{}
+// New creates a new manager instance. Without options it connects to the system bus
+// on first use.
+func New(opts ...Option) Mgr {
+    return &mgr{cfg: newConfig(opts), events: make(chan Event, eventBuffer), quit: make(chan struct{})}
 }
 
-// Note: no sentinel errors are exposed; callers should inspect returned errors as needed.
-
 type role int
 
 const (
@@ -31,7 +34,6 @@ const (
 )
 
 const (
-    bluezService         = "org.bluez"
     profileInterfaceName = "org.bluez.Profile1"
     profileManagerIface  = "org.bluez.ProfileManager1"
     deviceIface          = "org.bluez.Device1"
@@ -42,7 +44,15 @@ const (
 
 var pathCounter uint64
 
+// objectPath returns a process-unique path below the configured prefix, e.g. ".../server/p3".
+func (m *mgr) objectPath(kind, tag string) dbus.ObjectPath {
+    id := atomic.AddUint64(&pathCounter, 1)
+    return dbus.ObjectPath(m.cfg.pathPrefix + "/" + kind + "/" + tag + strconv.FormatUint(id, 10))
+}
+
 type mgr struct {
+    cfg config
+
     mu     sync.Mutex
     closed bool
 
@@ -55,6 +65,10 @@ type mgr struct {
     acceptUsed     bool
     srvProf        *profile
     serverPath     dbus.ObjectPath
+    lookupNames    bool // ServerOptions.LookupServiceName
+
+    // agent state
+    agentRegistered bool
 
     // client state
     clientExported bool
@@ -62,18 +76,48 @@ type mgr struct {
     cliProf        *profile
     clientPath     dbus.ObjectPath
 
+    // lifecycle notifications (see Events) and the registrations they refer to. Events that
+    // find the channel full wait in backlog, delivered in order by one pump goroutine.
+    events    chan Event
+    backlog   []Event
+    pumping   bool
+    pumpWG    sync.WaitGroup
+    quit      chan struct{} // closed by Close; stops the pump
+    regs      []*registration
+    stopWatch func() // stops the NameOwnerChanged watch; nil if not watching
+
+    // SDP results per device MAC and service UUID (see sdpResolver).
+    sdpCache map[string]sdpInfo
+
     // cleanup functions to release resources in Close (executed once, in reverse order).
     cleanup []func()
 }
 
-// ensureBusLocked connects to the system bus if not yet connected.
+// ensureBusLocked connects to the configured bus if not yet connected.
 func (m *mgr) ensureBusLocked() error {
     if m.bus != nil {
         return nil
     }
-    c, err := dbus.SystemBus()
+    if !dbus.ObjectPath(m.cfg.pathPrefix).IsValid() || m.cfg.pathPrefix == "/" {
+        return fmt.Errorf("connmgr: invalid path prefix %q", m.cfg.pathPrefix)
+    }
+    if m.cfg.conn != nil {
+        // Injected via WithConn: owned by the caller, never closed here.
+        m.bus = m.cfg.conn
+        return nil
+    }
+    // A private connection per manager, so closing one manager does not affect others.
+    var (
+        c   *dbus.Conn
+        err error
+    )
+    if m.cfg.address != "" {
+        c, err = dbus.Connect(m.cfg.address)
+    } else {
+        c, err = dbus.ConnectSystemBus()
+    }
     if err != nil {
-        return fmt.Errorf("connmgr: connect system bus: %w", err)
+        return fmt.Errorf("connmgr: connect bus: %w", err)
     }
     m.bus = c
     // Close the bus last during cleanup.
@@ -82,9 +126,34 @@ func (m *mgr) ensureBusLocked() error {
 }
 
 // profile implements org.bluez.Profile1 and forwards NewConnection events.
+//
+// NewConnection is invoked on D-Bus handler goroutines, so all state is guarded by mu.
 type profile struct {
-    ch       chan acceptResult // non-nil while accepting/connecting
-    accepted bool              // true after first delivery; subsequent connections are rejected/closed
+    mu      sync.Mutex
+    adapter dbus.ObjectPath         // if set, connections through other adapters are rejected
+    ch      chan acceptResult       // queued connections waiting for Accept/Connect; cap == limit
+    done    chan struct{}           // closed by shutdown
+    limit   int                     // maximum connections holding a slot (queued + accepted)
+    peers   map[dbus.ObjectPath]int // slots held per device, one per connection
+    held    int                     // total slots held
+    closed  bool
+    logger  *log.Logger
+    notify  func(EventType, Device) // set before export, never changed
+    policy  *peerPolicy             // server only; nil accepts every device
+    // Server only: props reads a peer's Device1 properties and service holds the UUID and
+    // configured Channel of the registered service, both used to describe accepted peers.
+    props   func(dbus.ObjectPath) (map[string]dbus.Variant, error)
+    service Device
+}
+
+func newProfile(limit int, logger *log.Logger) *profile {
+    return &profile{
+        ch:     make(chan acceptResult, limit),
+        done:   make(chan struct{}),
+        limit:  limit,
+        peers:  make(map[dbus.ObjectPath]int),
+        logger: logger,
+    }
 }
 
 type acceptResult struct {
@@ -93,17 +162,36 @@ type acceptResult struct {
     err error
 }
 
-// Release is called by BlueZ when the profile is being released.
-func (p *profile) Release() *dbus.Error { return nil }
+// Release is called by BlueZ when it unregisters the profile (e.g. bluetoothd shutting down).
+func (p *profile) Release() *dbus.Error {
+    p.emit(EventReleased, Device{})
+    return nil
+}
 
 // Cancel may be called to indicate a canceled request.
 func (p *profile) Cancel() *dbus.Error { return nil }
 
-// RequestDisconnection is ignored in this minimal implementation.
-func (p *profile) RequestDisconnection(_ dbus.ObjectPath) *dbus.Error { return nil }
+// RequestDisconnection reports that BlueZ wants the link to dev closed. The FD belongs to the
+// caller, so it is only notified; devices without a connection from this profile are ignored.
+func (p *profile) RequestDisconnection(dev dbus.ObjectPath) *dbus.Error {
+    p.mu.Lock()
+    held := p.peers[dev] > 0
+    p.mu.Unlock()
+    if held {
+        p.emit(EventDisconnected, Device{Path: string(dev), MAC: macFromPath(dev)})
+    }
+    return nil
+}
+
+func (p *profile) emit(t EventType, d Device) {
+    if p.notify != nil {
+        p.notify(t, d)
+    }
+}
 
-// NewConnection delivers the incoming RFCOMM socket FD to the waiting goroutine.
-func (p *profile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, _ map[string]dbus.Variant) *dbus.Error {
+// NewConnection queues the incoming RFCOMM socket FD for Accept/Connect.
+// Connections beyond the peer limit, or arriving after shutdown, are closed and rejected.
+func (p *profile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps map[string]dbus.Variant) *dbus.Error {
     res := acceptResult{
         fd: int(fd),
         dev: Device{
@@ -112,35 +200,137 @@ func (p *profile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, _ map[strin
         },
         err: nil,
     }
-    // Non-blocking delivery with single-accept guarantee.
-    if p.accepted {
-        // Already accepted once; close FD and reject.
-        _ = os.NewFile(uintptr(res.fd), "rfcomm").Close()
-        return &dbus.Error{Name: "org.bluez.Error.Rejected", Body: []interface{}{"already accepted"}}
+    // Resolving the peer and the policy may query BlueZ or call back into the application,
+    // so they run unlocked.
+    known := false
+    if p.props != nil {
+        res.dev, known = p.describe(dev, int(fd), fdProps)
+    }
+    if p.policy != nil {
+        if reason := p.policy.check(res.dev, known); reason != "" {
+            return p.reject(res, reason)
+        }
+    }
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.closed {
+        return p.reject(res, "closed")
+    }
+    if !onAdapter(dev, p.adapter) {
+        return p.reject(res, "wrong adapter")
+    }
+    // Every connection takes a slot of its own, also a second one from the same device:
+    // the first stays open until the caller releases it.
+    if p.held >= p.limit {
+        return p.reject(res, "peer limit reached")
     }
     select {
     case p.ch <- res:
-        p.accepted = true
+        p.peers[dev]++
+        p.held++
         return nil
     default:
-        // No receiver; close FD and return a rejection to avoid leaks.
-        _ = os.NewFile(uintptr(res.fd), "rfcomm").Close()
-        return &dbus.Error{Name: "org.bluez.Error.Rejected", Body: []interface{}{"no receiver"}}
+        // Cannot happen while the queue holds limit entries; close the FD to avoid leaks.
+        return p.reject(res, "no receiver")
+    }
+}
+
+// describe builds the Device reported by Accept from the peer's Device1 properties, the
+// NewConnection fd_properties and the socket itself. known is false if BlueZ has no
+// Device1 object for dev, in which case only the path-derived fields are set.
+func (p *profile) describe(dev dbus.ObjectPath, fd int, fdProps map[string]dbus.Variant) (d Device, known bool) {
+    if props, err := p.props(dev); err == nil {
+        d, known = deviceFromProps(dev, props), true
+    } else {
+        d = deviceFromProps(dev, nil)
+    }
+    d.UUID = p.service.UUID
+    d.Channel = p.service.Channel
+    if sa, err := unix.Getsockname(fd); err == nil {
+        if rc, ok := sa.(*unix.SockaddrRFCOMM); ok {
+            d.Channel = rc.Channel
+        }
+    }
+    if v, ok := fdProps["Version"]; ok {
+        d.Version, _ = v.Value().(uint16)
+    }
+    return d, known
+}
+
+// reject closes the FD of an unwanted connection and returns the error for BlueZ.
+func (p *profile) reject(res acceptResult, reason string) *dbus.Error {
+    closeFD(res.fd)
+    p.logger.Printf("connmgr: rejected connection from %s: %s", res.dev.Path, reason)
+    return rejected(reason)
+}
+
+// releaseAll frees every slot and returns the devices that held one.
+func (p *profile) releaseAll() []dbus.ObjectPath {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    devs := make([]dbus.ObjectPath, 0, len(p.peers))
+    for dev := range p.peers {
+        devs = append(devs, dev)
+    }
+    sort.Slice(devs, func(i, j int) bool { return devs[i] < devs[j] })
+    clear(p.peers)
+    p.held = 0
+    return devs
+}
+
+// release frees one slot held by dev. It reports whether dev held one.
+func (p *profile) release(dev dbus.ObjectPath) bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.peers[dev] == 0 {
+        return false
+    }
+    if p.peers[dev]--; p.peers[dev] == 0 {
+        delete(p.peers, dev)
+    }
+    p.held--
+    return true
+}
+
+// shutdown rejects further connections and closes FDs still queued for Accept.
+func (p *profile) shutdown() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.closed {
+        return
+    }
+    p.closed = true
+    close(p.done)
+    for {
+        select {
+        case res := <-p.ch:
+            closeFD(res.fd)
+        default:
+            return
+        }
     }
 }
 
+func closeFD(fd int) {
+    _ = os.NewFile(uintptr(fd), "rfcomm").Close()
+}
+
+func rejected(msg string) *dbus.Error {
+    return &dbus.Error{Name: "org.bluez.Error.Rejected", Body: []interface{}{msg}}
+}
+
 func (m *mgr) StartServer(ctx context.Context, opts ServerOptions) error {
-    _ = ctx // ctx reserved; registration is fast and not cancellable via D-Bus API directly.
+    // ctx bounds the adapter lookup; registration itself is fast and not cancellable via D-Bus.
     m.mu.Lock()
     defer m.mu.Unlock()
     if m.closed {
-        return errors.New("connmgr: closed")
+        return ErrClosed
     }
     if m.role == roleClient || m.connectUsed {
-        return errors.New("connmgr: already used as client")
+        return fmt.Errorf("%w: already used as client", ErrRoleConflict)
     }
     if m.serverExported {
-        return errors.New("connmgr: server already started")
+        return fmt.Errorf("%w: server already started", ErrAlreadyUsed)
     }
     if err := m.ensureBusLocked(); err != nil {
         return err
@@ -149,33 +339,63 @@ func (m *mgr) StartServer(ctx context.Context, opts ServerOptions) error {
     if opts.ServiceName == "" {
         return errors.New("connmgr: ServiceName required")
     }
+    maxPeers := opts.MaxPeers
+    if maxPeers < 0 {
+        return fmt.Errorf("connmgr: invalid MaxPeers %d", maxPeers)
+    }
+    if maxPeers == 0 {
+        maxPeers = DefaultMaxPeers
+    }
+    sp, err := buildServerProfile(opts)
+    if err != nil {
+        return err
+    }
+    policy, err := newPeerPolicy(opts)
+    if err != nil {
+        return err
+    }
+    adapter, err := m.resolveAdapter(ctx, m.bus, opts.Adapter)
+    if err != nil {
+        return err
+    }
+    if err := sp.prepare(); err != nil {
+        return err
+    }
 
     // Export Profile1 for server role.
-    m.srvProf = &profile{ch: make(chan acceptResult, 1)}
+    m.srvProf = newProfile(maxPeers, m.cfg.logger)
+    m.srvProf.adapter = adapter
+    m.srvProf.policy = policy
+    m.srvProf.props = m.deviceProps(m.bus)
+    m.srvProf.service = Device{UUID: sp.uuid, Channel: sp.channel}
+    m.lookupNames = opts.LookupServiceName
+    reg := m.newRegistration("server", m.srvProf)
     // Unique object path per instance to avoid collisions.
-    id := atomic.AddUint64(&pathCounter, 1)
-    m.serverPath = dbus.ObjectPath("/org/bluetooth_chat/connmgr/server/p" + strconv.FormatUint(id, 10))
+    m.serverPath = m.objectPath("server", "p")
     if err := m.bus.Export(m.srvProf, m.serverPath, profileInterfaceName); err != nil {
         return fmt.Errorf("connmgr: export server profile: %w", err)
     }
-    m.serverExported = true
 
     // Register the profile with BlueZ.
-    optsMap := map[string]dbus.Variant{
-        "Name":    dbus.MakeVariant(opts.ServiceName),
-        "Role":    dbus.MakeVariant("server"),
-        // BlueZ expects Channel as a uint16 (not byte).
-        "Channel": dbus.MakeVariant(uint16(DefaultRFCOMMChannel)),
-    }
-    pm := m.bus.Object(bluezService, dbus.ObjectPath("/org/bluez"))
-    if call := pm.Call(profileManagerIface+".RegisterProfile", 0, m.serverPath, SPPUUID, optsMap); call.Err != nil {
-        return fmt.Errorf("connmgr: RegisterProfile(server): %w", call.Err)
+    pm := m.bus.Object(m.cfg.service, dbus.ObjectPath("/org/bluez"))
+    if call := pm.Call(profileManagerIface+".RegisterProfile", 0, m.serverPath, sp.uuid, sp.options); call.Err != nil {
+        // Leave the manager reusable so the caller can retry, e.g. with another channel.
+        _ = m.bus.Export(nil, m.serverPath, profileInterfaceName)
+        m.srvProf = nil
+        return registerProfileError(sp, call.Err)
     }
-    // On close, unregister server profile before closing the bus.
+    m.serverExported = true
+    reg.path, reg.uuid, reg.options = m.serverPath, sp.uuid, sp.options
+    m.trackLocked(reg)
+    // On close, unregister server profile before closing the bus, then drop queued FDs.
+    srvProf := m.srvProf
     m.cleanup = append(m.cleanup, func() {
-        _ = pm.Call(profileManagerIface+".UnregisterProfile", 0, m.serverPath).Err
+        if err := pm.Call(profileManagerIface+".UnregisterProfile", 0, m.serverPath).Err; err != nil {
+            m.cfg.logger.Printf("connmgr: UnregisterProfile(server): %v", err)
+        }
         // Unexport the object path (best-effort).
         _ = m.bus.Export(nil, m.serverPath, profileInterfaceName)
+        srvProf.shutdown()
     })
     m.role = roleServer
     return nil
@@ -185,119 +405,97 @@ func (m *mgr) Accept(ctx context.Context) (fd int, remote Device, err error) {
     m.mu.Lock()
     if m.closed {
         m.mu.Unlock()
-        return 0, Device{}, errors.New("connmgr: closed")
+        return 0, Device{}, ErrClosed
     }
     if m.role != roleServer || !m.serverExported {
         m.mu.Unlock()
         return 0, Device{}, errors.New("connmgr: server not started")
     }
-    if m.acceptUsed {
-        m.mu.Unlock()
-        return 0, Device{}, errors.New("connmgr: Accept already used")
-    }
     m.acceptUsed = true
-    ch := m.srvProf.ch
+    prof := m.srvProf
+    lookup := m.lookupNames
     m.mu.Unlock()
 
     select {
     case <-ctx.Done():
         return 0, Device{}, fmt.Errorf("connmgr: accept canceled: %w", ctx.Err())
-    case res := <-ch:
+    case <-prof.done:
+        return 0, Device{}, ErrClosed
+    case res := <-prof.ch:
+        // The FD is the caller's from here on; the lookup only adds information.
+        res.dev.ServiceName = m.peerServiceName(ctx, res.dev, lookup)
         return res.fd, res.dev, res.err
     }
 }
 
-func (m *mgr) ScanSPP(ctx context.Context) ([]Device, error) {
+// peerServiceName returns the ServiceName of the peer's own record for the service it
+// connected to, from the SDP cache or, with lookup, a query bounded by peerSDPTimeout.
+func (m *mgr) peerServiceName(ctx context.Context, d Device, lookup bool) string {
+    if d.MAC == "" {
+        return ""
+    }
+    info, ok := m.cachedSDP(d.MAC, d.UUID)
+    if !ok {
+        if !lookup {
+            return ""
+        }
+        ctx, cancel := context.WithTimeout(ctx, peerSDPTimeout)
+        defer cancel()
+        var err error
+        m.mu.Lock()
+        bus := m.bus
+        m.mu.Unlock()
+        local := m.adapterAddress(ctx, bus, dbus.ObjectPath(d.Adapter))
+        if info, err = querySDP(ctx, local, d.MAC, d.UUID); err != nil {
+            return ""
+        }
+        m.cacheSDP(d.MAC, d.UUID, info)
+    }
+    return info.ServiceName
+}
+
+func (m *mgr) Release(remote Device) error {
     m.mu.Lock()
     if m.closed {
         m.mu.Unlock()
-        return nil, errors.New("connmgr: closed")
+        return ErrClosed
     }
-    if err := m.ensureBusLocked(); err != nil {
+    if m.role != roleServer || !m.serverExported {
         m.mu.Unlock()
-        return nil, err
+        return errors.New("connmgr: server not started")
     }
-    bus := m.bus
+    prof := m.srvProf
     m.mu.Unlock()
 
-    // Discover adapters.
-    adapters, err := listAdapters(bus)
-    if err != nil {
-        return nil, err
-    }
-    // Start discovery on all adapters (best-effort); stop when done.
-    for _, ap := range adapters {
-        _ = bus.Object(bluezService, ap).Call(adapterIface+".StartDiscovery", 0).Err
-        defer func(p dbus.ObjectPath) { _ = bus.Object(bluezService, p).Call(adapterIface+".StopDiscovery", 0).Err }(ap)
-    }
-
-    // Prime from current managed objects.
-    devMap, err := snapshotSPPDevices(bus)
-    if err != nil {
-        return nil, err
-    }
-
-    // Subscribe to InterfacesAdded to catch new devices until ctx is done.
-    sigCh := make(chan *dbus.Signal, 16)
-    bus.Signal(sigCh)
-    defer bus.RemoveSignal(sigCh)
-    if err := bus.AddMatchSignal(
-        dbus.WithMatchInterface(objManagerIface),
-        dbus.WithMatchMember("InterfacesAdded"),
-    ); err != nil {
-        return nil, fmt.Errorf("connmgr: AddMatchSignal: %w", err)
-    }
-    defer func() {
-        _ = bus.RemoveMatchSignal(
-            dbus.WithMatchInterface(objManagerIface),
-            dbus.WithMatchMember("InterfacesAdded"),
-        )
-    }()
-
-    loop:
-    for {
-        select {
-        case <-ctx.Done():
-            break loop
-        case sig := <-sigCh:
-            if sig == nil || len(sig.Body) < 2 {
-                continue
-            }
-            path, _ := sig.Body[0].(dbus.ObjectPath)
-            ifaces, _ := sig.Body[1].(map[string]map[string]dbus.Variant)
-            if ifaces == nil {
-                continue
-            }
-            if dev, ok := deviceFromIfaces(path, ifaces); ok {
-                devMap[dev.Path] = dev
-            }
-        }
-    }
-
-    // Build stable slice.
-    out := make([]Device, 0, len(devMap))
-    for _, d := range devMap {
-        out = append(out, d)
+    if !prof.release(dbus.ObjectPath(remote.Path)) {
+        return fmt.Errorf("connmgr: no active peer %q", remote.Path)
     }
-    return out, nil
+    return nil
 }
 
 func (m *mgr) Connect(ctx context.Context, dev Device) (fd int, err error) {
     if dev.Path == "" {
         return 0, errors.New("connmgr: device path required")
     }
+    uuid := SPPUUID
+    if dev.UUID != "" {
+        if _, err := parseUUID128(dev.UUID); err != nil {
+            return 0, err
+        }
+        uuid = strings.ToLower(dev.UUID)
+    }
     m.mu.Lock()
     if m.closed {
         m.mu.Unlock()
-        return 0, errors.New("connmgr: closed")
+        return 0, ErrClosed
     }
     if m.role == roleServer || m.acceptUsed {
         m.mu.Unlock()
-        return 0, errors.New("connmgr: already used as server")
+        return 0, fmt.Errorf("%w: already used as server", ErrRoleConflict)
     }
     if m.connectUsed {
         m.mu.Unlock()
-        return 0, errors.New("connmgr: Connect already used")
+        return 0, fmt.Errorf("%w: Connect already called", ErrAlreadyUsed)
     }
     if err := m.ensureBusLocked(); err != nil {
         m.mu.Unlock()
@@ -306,63 +504,102 @@ func (m *mgr) Connect(ctx context.Context, dev Device) (fd int, err error) {
 
     // Export Profile1 for client role once.
     if !m.clientExported {
-        m.cliProf = &profile{ch: make(chan acceptResult, 1)}
+        // Limit 1 and never released: exactly one connection is delivered.
+        m.cliProf = newProfile(1, m.cfg.logger)
+        reg := m.newRegistration("client", m.cliProf)
         // Unique client path per instance.
-        id := atomic.AddUint64(&pathCounter, 1)
-        m.clientPath = dbus.ObjectPath("/org/bluetooth_chat/connmgr/client/p" + strconv.FormatUint(id, 10))
+        m.clientPath = m.objectPath("client", "p")
         if err := m.bus.Export(m.cliProf, m.clientPath, profileInterfaceName); err != nil {
             m.mu.Unlock()
             return 0, fmt.Errorf("connmgr: export client profile: %w", err)
         }
-        pm := m.bus.Object(bluezService, dbus.ObjectPath("/org/bluez"))
+        pm := m.bus.Object(m.cfg.service, dbus.ObjectPath("/org/bluez"))
         optsMap := map[string]dbus.Variant{
             "Role": dbus.MakeVariant("client"),
             // Name is not used by client, but harmless to omit.
         }
-        if call := pm.Call(profileManagerIface+".RegisterProfile", 0, m.clientPath, SPPUUID, optsMap); call.Err != nil {
+        if call := pm.Call(profileManagerIface+".RegisterProfile", 0, m.clientPath, uuid, optsMap); call.Err != nil {
+            _ = m.bus.Export(nil, m.clientPath, profileInterfaceName)
             m.mu.Unlock()
-            return 0, fmt.Errorf("connmgr: RegisterProfile(client): %w", call.Err)
+            return 0, opError("RegisterProfile(client)", call.Err)
         }
+        reg.path, reg.uuid, reg.options = m.clientPath, uuid, optsMap
+        m.trackLocked(reg)
         // Unregister client profile on close.
+        cliProf := m.cliProf
         m.cleanup = append(m.cleanup, func() {
-            _ = pm.Call(profileManagerIface+".UnregisterProfile", 0, m.clientPath).Err
+            if err := pm.Call(profileManagerIface+".UnregisterProfile", 0, m.clientPath).Err; err != nil {
+                m.cfg.logger.Printf("connmgr: UnregisterProfile(client): %v", err)
+            }
             _ = m.bus.Export(nil, m.clientPath, profileInterfaceName)
+            cliProf.shutdown()
         })
         m.clientExported = true
         m.role = roleClient
     }
-    ch := m.cliProf.ch
+    prof := m.cliProf
     m.connectUsed = true
     bus := m.bus
     m.mu.Unlock()
 
     // Ensure paired; if not, attempt Pair() via Agent.
     devPath := dbus.ObjectPath(dev.Path)
-    devObj := bus.Object(bluezService, devPath)
+    devObj := bus.Object(m.cfg.service, devPath)
     var pairedVar dbus.Variant
-    if call := devObj.Call(propsIface+".Get", 0, deviceIface, "Paired"); call.Err == nil {
+    if call := devObj.CallWithContext(ctx, propsIface+".Get", 0, deviceIface, "Paired"); call.Err == nil {
         if err := call.Store(&pairedVar); err == nil {
             if b, ok := pairedVar.Value().(bool); ok && !b {
-                if err := devObj.Call(deviceIface+".Pair", 0).Err; err != nil {
-                    return 0, fmt.Errorf("connmgr: Pair: %w", err)
+                if err := devObj.CallWithContext(ctx, deviceIface+".Pair", 0).Err; err != nil {
+                    if ctx.Err() != nil {
+                        m.abortConnect(prof, devObj, deviceIface+".CancelPairing")
+                        return 0, fmt.Errorf("connmgr: pairing canceled: %w", ctx.Err())
+                    }
+                    return 0, opError("Pair", err)
                 }
             }
         }
+    } else if ctx.Err() != nil {
+        return 0, fmt.Errorf("connmgr: connect canceled: %w", ctx.Err())
+    } else if errors.Is(classify(call.Err), ErrDeviceNotFound) {
+        return 0, opError("Get Paired", call.Err)
     }
     // Initiate ConnectProfile on the device.
-    call := devObj.Call(deviceIface+".ConnectProfile", 0, SPPUUID)
+    call := devObj.CallWithContext(ctx, deviceIface+".ConnectProfile", 0, uuid)
     if call.Err != nil {
-        return 0, fmt.Errorf("connmgr: ConnectProfile: %w", call.Err)
+        if ctx.Err() != nil {
+            m.abortConnect(prof, devObj, deviceIface+".DisconnectProfile", uuid)
+            return 0, fmt.Errorf("connmgr: connect canceled: %w", ctx.Err())
+        }
+        return 0, opError("ConnectProfile", call.Err)
     }
 
     select {
     case <-ctx.Done():
+        m.abortConnect(prof, devObj, deviceIface+".DisconnectProfile", uuid)
         return 0, fmt.Errorf("connmgr: connect canceled: %w", ctx.Err())
-    case res := <-ch:
+    case <-prof.done:
+        return 0, ErrClosed
+    case res := <-prof.ch:
         return res.fd, res.err
     }
 }
 
+// abortTimeout bounds the best-effort calls telling BlueZ to stop a canceled Connect.
+const abortTimeout = 5 * time.Second
+
+// abortConnect handles a canceled Connect: it shuts the client profile down, so an FD that
+// is queued or arrives late is closed instead of leaked, then asks BlueZ to stop (method is
+// CancelPairing or DisconnectProfile). DisconnectProfile rather than Disconnect keeps other
+// profiles of the device (e.g. audio) connected.
+func (m *mgr) abortConnect(prof *profile, devObj dbus.BusObject, method string, args ...interface{}) {
+    prof.shutdown()
+    ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+    defer cancel()
+    if err := devObj.CallWithContext(ctx, method, 0, args...).Err; err != nil {
+        m.cfg.logger.Printf("connmgr: %s after cancel: %v", method, err)
+    }
+}
+
 // Close is safe for concurrent and redundant calls (idempotent).
 func (m *mgr) Close() error {
     m.mu.Lock()
@@ -371,29 +608,37 @@ func (m *mgr) Close() error {
         return nil
     }
     m.closed = true
+    close(m.quit)
     cleanup := m.cleanup
     // Clear to allow GC of captured resources.
     m.cleanup = nil
+    stopWatch := m.stopWatch
+    m.stopWatch = nil
     m.mu.Unlock()
 
+    // Stop reacting to bluetoothd restarts first, so nothing re-registers the profiles
+    // unregistered below.
+    if stopWatch != nil {
+        stopWatch()
+    }
     // Run cleanup outside the lock in reverse order of registration.
     for i := len(cleanup) - 1; i >= 0; i-- {
         if cleanup[i] != nil {
             cleanup[i]()
         }
     }
+    // Emitters check closed under the lock, so nothing sends after the pump stopped.
+    m.pumpWG.Wait()
+    close(m.events)
     return nil
 }
 
 // Helpers
 
-func listAdapters(bus *dbus.Conn) ([]dbus.ObjectPath, error) {
-    obj := bus.Object(bluezService, dbus.ObjectPath("/"))
-    var objs map[dbus.ObjectPath]map[string]map[string]dbus.Variant
-    if call := obj.Call(objManagerIface+".GetManagedObjects", 0); call.Err != nil {
-        return nil, fmt.Errorf("connmgr: GetManagedObjects: %w", call.Err)
-    } else if err := call.Store(&objs); err != nil {
-        return nil, fmt.Errorf("connmgr: decode GetManagedObjects: %w", err)
+func (m *mgr) listAdapters(ctx context.Context, bus *dbus.Conn) ([]dbus.ObjectPath, error) {
+    objs, err := m.managedObjects(ctx, bus)
+    if err != nil {
+        return nil, err
     }
     var out []dbus.ObjectPath
     for path, ifaces := range objs {
@@ -404,24 +649,19 @@ func listAdapters(bus *dbus.Conn) ([]dbus.ObjectPath, error) {
     return out, nil
 }
 
-func snapshotSPPDevices(bus *dbus.Conn) (map[string]Device, error) {
-    obj := bus.Object(bluezService, dbus.ObjectPath("/"))
+func (m *mgr) managedObjects(ctx context.Context, bus *dbus.Conn) (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, error) {
+    obj := bus.Object(m.cfg.service, dbus.ObjectPath("/"))
     var objs map[dbus.ObjectPath]map[string]map[string]dbus.Variant
-    if call := obj.Call(objManagerIface+".GetManagedObjects", 0); call.Err != nil {
-        return nil, fmt.Errorf("connmgr: GetManagedObjects: %w", call.Err)
+    if call := obj.CallWithContext(ctx, objManagerIface+".GetManagedObjects", 0); call.Err != nil {
+        return nil, opError("GetManagedObjects", call.Err)
     } else if err := call.Store(&objs); err != nil {
         return nil, fmt.Errorf("connmgr: decode GetManagedObjects: %w", err)
     }
-    out := make(map[string]Device)
-    for path, ifaces := range objs {
-        if dev, ok := deviceFromIfaces(path, ifaces); ok {
-            out[dev.Path] = dev
-        }
-    }
-    return out, nil
+    return objs, nil
 }
 
-func deviceFromIfaces(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant) (Device, bool) {
+// deviceFromIfaces returns the device at path if its Device1.UUIDs contain uuid.
+func deviceFromIfaces(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant, uuid string) (Device, bool) {
     props, ok := ifaces[deviceIface]
     if !ok {
         return Device{}, false
@@ -431,10 +671,32 @@ func deviceFromIfaces(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Va
         return Device{}, false
     }
     uu, _ := vUUIDs.Value().([]string)
-    if !containsUUID(uu, SPPUUID) {
+    if !containsUUID(uu, uuid) {
         return Device{}, false
     }
+    dev := deviceFromProps(path, props)
+    dev.UUID = uuid
+    return dev, true
+}
+
+// deviceProps returns a function reading the Device1 properties of a device object.
+func (m *mgr) deviceProps(bus *dbus.Conn) func(dbus.ObjectPath) (map[string]dbus.Variant, error) {
+    service := m.cfg.service
+    return func(path dbus.ObjectPath) (map[string]dbus.Variant, error) {
+        var props map[string]dbus.Variant
+        err := bus.Object(service, path).Call(propsIface+".GetAll", 0, deviceIface).Store(&props)
+        return props, err
+    }
+}
+
+// deviceFromProps fills a Device from Device1 properties without any UUID filtering.
+func deviceFromProps(path dbus.ObjectPath, props map[string]dbus.Variant) Device {
     var mac, name, alias string
+    var rssi int16
+    var adapter dbus.ObjectPath
+    var paired, trusted, blocked, connected bool
+    var class uint32
+    var icon string
     if v, ok := props["Address"]; ok {
         mac, _ = v.Value().(string)
     }
@@ -444,16 +706,51 @@ func deviceFromIfaces(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Va
     if v, ok := props["Alias"]; ok {
         alias, _ = v.Value().(string)
     }
+    if v, ok := props["RSSI"]; ok {
+        rssi, _ = v.Value().(int16)
+    }
+    if v, ok := props["Adapter"]; ok {
+        adapter, _ = v.Value().(dbus.ObjectPath)
+    }
+    if v, ok := props["Paired"]; ok {
+        paired, _ = v.Value().(bool)
+    }
+    if v, ok := props["Trusted"]; ok {
+        trusted, _ = v.Value().(bool)
+    }
+    if v, ok := props["Blocked"]; ok {
+        blocked, _ = v.Value().(bool)
+    }
+    if v, ok := props["Connected"]; ok {
+        connected, _ = v.Value().(bool)
+    }
+    if v, ok := props["Class"]; ok {
+        class, _ = v.Value().(uint32)
+    }
+    if v, ok := props["Icon"]; ok {
+        icon, _ = v.Value().(string)
+    }
     if mac == "" {
         mac = macFromPath(path)
     }
+    if adapter == "" {
+        adapter = adapterFromPath(path)
+    }
     return Device{
-        Path: string(path),
-        MAC:  mac,
-        Name: name,
-        Alias: alias,
-        // ServiceName: optional; omitted here.
-    }, true
+        Path:      string(path),
+        MAC:       mac,
+        Name:      name,
+        Alias:     alias,
+        RSSI:      rssi,
+        Adapter:   string(adapter),
+        Paired:    paired,
+        Trusted:   trusted,
+        Blocked:   blocked,
+        Connected: connected,
+        Class:     class,
+        Icon:      icon,
+        // ServiceName/Channel come from SDP (see sdpResolver).
+    }
 }
 
 func containsUUID(list []string, target string) bool {
@@ -465,6 +762,14 @@ func containsUUID(list []string, target string) bool {
     return false
 }
 
+// adapterFromPath returns the adapter part of a device object path (e.g. /org/bluez/hci0).
+func adapterFromPath(p dbus.ObjectPath) dbus.ObjectPath {
+    if i := strings.LastIndex(string(p), "/dev_"); i > 0 {
+        return p[:i]
+    }
+    return ""
+}
+
 func macFromPath(p dbus.ObjectPath) string {
     s := string(p)
     // Expect .../dev_XX_XX_XX_XX_XX_XX
@@ -0,0 +1,75 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "io"
+    "log"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+// DefaultPathPrefix is the object path under which the manager exports its profiles and agent.
+const DefaultPathPrefix = "/org/bluetooth_chat/connmgr"
+
+// Option configures a manager created by New.
+type Option func(*config)
+
+// config holds the settings applied by Options.
+type config struct {
+    conn       *dbus.Conn // injected bus; nil = dial address or the system bus
+    address    string     // bus address; "" = system bus
+    pathPrefix string
+    logger     *log.Logger
+    service    string // BlueZ bus name
+}
+
+func newConfig(opts []Option) config {
+    c := config{
+        pathPrefix: DefaultPathPrefix,
+        logger:     log.New(io.Discard, "", 0),
+        service:    "org.bluez",
+    }
+    for _, o := range opts {
+        o(&c)
+    }
+    return c
+}
+
+// WithConn makes the manager use conn instead of connecting to the system bus.
+// The connection is not closed by Close; the caller keeps ownership. It must support
+// Unix FD passing (any Unix socket bus connection does) for Accept/Connect to work.
+func WithConn(conn *dbus.Conn) Option {
+    return func(c *config) { c.conn = conn }
+}
+
+// WithBusAddress makes the manager dial the bus at address (e.g. "unix:path=/run/my-bus")
+// instead of the system bus. The connection is private to the manager and closed by Close.
+// Ignored if WithConn is also given.
+func WithBusAddress(address string) Option {
+    return func(c *config) { c.address = address }
+}
+
+// WithPathPrefix sets the object path under which profiles and the agent are exported
+// (default DefaultPathPrefix). Managers sharing a connection need distinct prefixes only if
+// they should be told apart in bus traffic; exported paths are unique per process anyway.
+// An invalid object path makes the first bus operation fail.
+func WithPathPrefix(prefix string) Option {
+    return func(c *config) { c.pathPrefix = prefix }
+}
+
+// WithLogger sets a logger for diagnostics that are not returned as errors, such as
+// rejected incoming connections and best-effort cleanup failures. The default discards them.
+func WithLogger(l *log.Logger) Option {
+    return func(c *config) {
+        if l != nil {
+            c.logger = l
+        }
+    }
+}
+
+// WithBluezService sets the bus name of the BlueZ daemon (default "org.bluez"), e.g. for a
+// mock on the session bus.
+func WithBluezService(name string) Option {
+    return func(c *config) { c.service = name }
+}
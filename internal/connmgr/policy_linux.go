@@ -0,0 +1,70 @@
+//go:build linux
+
+package connmgr
+
+import "strings"
+
+// peerPolicy is the validated authorization policy from ServerOptions.
+type peerPolicy struct {
+    deny, allow map[string]bool // upper-case MACs
+    trustedOnly bool
+    authorize   func(Device) bool
+}
+
+// newPeerPolicy returns nil if opts imposes no restriction.
+func newPeerPolicy(opts ServerOptions) (*peerPolicy, error) {
+    if len(opts.DenyMACs) == 0 && len(opts.AllowMACs) == 0 && !opts.TrustedOnly && opts.Authorize == nil {
+        return nil, nil
+    }
+    deny, err := macSet(opts.DenyMACs)
+    if err != nil {
+        return nil, err
+    }
+    allow, err := macSet(opts.AllowMACs)
+    if err != nil {
+        return nil, err
+    }
+    return &peerPolicy{
+        deny:        deny,
+        allow:       allow,
+        trustedOnly: opts.TrustedOnly,
+        authorize:   opts.Authorize,
+    }, nil
+}
+
+func macSet(macs []string) (map[string]bool, error) {
+    set := make(map[string]bool, len(macs))
+    for _, mac := range macs {
+        if _, err := parseMAC(mac); err != nil {
+            return nil, err
+        }
+        set[strings.ToUpper(mac)] = true
+    }
+    return set, nil
+}
+
+// check returns the rejection reason for dev, or "" if the connection is allowed.
+// known reports whether BlueZ returned Device1 properties for dev.
+func (pp *peerPolicy) check(dev Device, known bool) string {
+    mac := strings.ToUpper(dev.MAC)
+    if pp.deny[mac] {
+        return "device denied"
+    }
+    if len(pp.allow) > 0 && !pp.allow[mac] {
+        return "device not allowed"
+    }
+    if !pp.trustedOnly && pp.authorize == nil {
+        return ""
+    }
+    if !known {
+        // Unknown device: it cannot be trusted, and the callback would see no details.
+        return "device unknown"
+    }
+    if pp.trustedOnly && (!dev.Paired || !dev.Trusted) {
+        return "device not paired and trusted"
+    }
+    if pp.authorize != nil && !pp.authorize(dev) {
+        return "not authorized"
+    }
+    return ""
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand/v2"
+    "syscall"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+// ReconnectState is the state reported by a Reconnector.
+type ReconnectState int
+
+const (
+    StateConnecting   ReconnectState = iota // a Connect attempt has started
+    StateConnected                          // the link is up; ReconnectEvent.FD is valid
+    StateDisconnected                       // the link was lost; the caller should close the previous FD
+    StateBackoff                            // an attempt failed; waiting ReconnectEvent.Delay before retrying
+    StateGaveUp                             // MaxAttempts consecutive attempts failed; Run has stopped
+)
+
+func (s ReconnectState) String() string {
+    switch s {
+    case StateConnecting:
+        return "connecting"
+    case StateConnected:
+        return "connected"
+    case StateDisconnected:
+        return "disconnected"
+    case StateBackoff:
+        return "backoff"
+    case StateGaveUp:
+        return "gave-up"
+    default:
+        return fmt.Sprintf("ReconnectState(%d)", int(s))
+    }
+}
+
+// ReconnectEvent reports a state transition of a Reconnector.
+type ReconnectEvent struct {
+    State   ReconnectState
+    Attempt int           // 1-based attempt number since the last successful connection
+    FD      int           // StateConnected only: RFCOMM socket FD owned by the caller
+    Delay   time.Duration // StateBackoff only: wait before the next attempt
+    Err     error         // cause for StateDisconnected, StateBackoff and StateGaveUp
+}
+
+// ReconnectOptions controls retry timing. Zero fields take the documented defaults.
+type ReconnectOptions struct {
+    InitialBackoff time.Duration // delay after the first failure (default 1s)
+    MaxBackoff     time.Duration // upper bound for the delay (default 30s)
+    Multiplier     float64       // growth factor per failed attempt (default 2)
+    Jitter         float64       // random spread as a fraction of the delay, 0..1 (default 0.2)
+    MaxAttempts    int           // consecutive failures before giving up; 0 retries forever
+    AttemptTimeout time.Duration // timeout for a single Connect attempt (default 30s)
+
+    // Manager holds options for the manager created for each attempt (e.g. WithConn).
+    Manager []Option
+}
+
+// Reconnector keeps a client connection to one device alive.
+//
+// Each attempt uses a fresh manager (ScanSPP is not repeated; dev.Path must be known).
+// While connected it watches both the FD for hang-up and Device1 "Connected" for false,
+// and re-establishes the link with exponential backoff and jitter.
+//
+// FD ownership: every FD delivered with StateConnected belongs to the caller. The Reconnector
+// never closes it; on StateDisconnected the caller should close it. If the caller closes the
+// FD on its own, it should cancel Run's ctx as well.
+type Reconnector struct {
+    dev  Device
+    opts ReconnectOptions
+}
+
+// NewReconnector returns a Reconnector for dev. dev.Path must be non-empty.
+func NewReconnector(dev Device, opts ReconnectOptions) *Reconnector {
+    if opts.InitialBackoff <= 0 {
+        opts.InitialBackoff = time.Second
+    }
+    if opts.MaxBackoff <= 0 {
+        opts.MaxBackoff = 30 * time.Second
+    }
+    if opts.MaxBackoff < opts.InitialBackoff {
+        opts.MaxBackoff = opts.InitialBackoff
+    }
+    if opts.Multiplier < 1 {
+        opts.Multiplier = 2
+    }
+    if opts.Jitter <= 0 || opts.Jitter > 1 {
+        opts.Jitter = 0.2
+    }
+    if opts.AttemptTimeout <= 0 {
+        opts.AttemptTimeout = 30 * time.Second
+    }
+    return &Reconnector{dev: dev, opts: opts}
+}
+
+// Run starts supervising in a new goroutine and returns its event channel.
+// Events are delivered in order and sends block, so the caller must drain the channel.
+// The channel is closed when ctx is done or after StateGaveUp.
+func (r *Reconnector) Run(ctx context.Context) <-chan ReconnectEvent {
+    out := make(chan ReconnectEvent)
+    go r.run(ctx, out)
+    return out
+}
+
+func (r *Reconnector) run(ctx context.Context, out chan<- ReconnectEvent) {
+    defer close(out)
+    if r.dev.Path == "" {
+        r.emit(ctx, out, ReconnectEvent{State: StateGaveUp, Err: errors.New("connmgr: device path required")})
+        return
+    }
+    attempt := 0
+    for {
+        attempt++
+        if !r.emit(ctx, out, ReconnectEvent{State: StateConnecting, Attempt: attempt}) {
+            return
+        }
+        m, fd, watch, err := r.connectOnce(ctx)
+        if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+            if r.opts.MaxAttempts > 0 && attempt >= r.opts.MaxAttempts {
+                r.emit(ctx, out, ReconnectEvent{State: StateGaveUp, Attempt: attempt, Err: err})
+                return
+            }
+            delay := r.backoff(attempt)
+            if !r.emit(ctx, out, ReconnectEvent{State: StateBackoff, Attempt: attempt, Delay: delay, Err: err}) {
+                return
+            }
+            t := time.NewTimer(delay)
+            select {
+            case <-ctx.Done():
+                t.Stop()
+                return
+            case <-t.C:
+            }
+            continue
+        }
+
+        if !r.emit(ctx, out, ReconnectEvent{State: StateConnected, Attempt: attempt, FD: fd}) {
+            // Undelivered FD is still ours.
+            closeFD(fd)
+            watch.stop()
+            _ = m.Close()
+            return
+        }
+        attempt = 0
+        err = watch.wait(ctx)
+        watch.stop()
+        _ = m.Close()
+        if ctx.Err() != nil {
+            return
+        }
+        if !r.emit(ctx, out, ReconnectEvent{State: StateDisconnected, Err: err}) {
+            return
+        }
+    }
+}
+
+// emit delivers ev unless ctx ends first. It reports whether ev was delivered.
+func (r *Reconnector) emit(ctx context.Context, out chan<- ReconnectEvent, ev ReconnectEvent) bool {
+    select {
+    case out <- ev:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// backoff returns the jittered delay after the given failed attempt (1-based).
+func (r *Reconnector) backoff(attempt int) time.Duration {
+    d := float64(r.opts.InitialBackoff)
+    for i := 1; i < attempt && d < float64(r.opts.MaxBackoff); i++ {
+        d *= r.opts.Multiplier
+    }
+    if d > float64(r.opts.MaxBackoff) {
+        d = float64(r.opts.MaxBackoff)
+    }
+    // Spread uniformly over [d*(1-j), d*(1+j)].
+    d *= 1 + r.opts.Jitter*(2*rand.Float64()-1)
+    return time.Duration(d)
+}
+
+// connectOnce performs one Connect with a fresh manager and arms the link watch
+// before the FD is handed out, so the watch never refers to a reused FD number.
+func (r *Reconnector) connectOnce(ctx context.Context) (*mgr, int, *linkWatch, error) {
+    m := New(r.opts.Manager...).(*mgr)
+    actx, cancel := context.WithTimeout(ctx, r.opts.AttemptTimeout)
+    defer cancel()
+    fd, err := m.Connect(actx, r.dev)
+    if err != nil {
+        _ = m.Close()
+        return nil, 0, nil, err
+    }
+    watch, err := newLinkWatch(m.bus, dbus.ObjectPath(r.dev.Path), fd)
+    if err != nil {
+        closeFD(fd)
+        _ = m.Close()
+        return nil, 0, nil, err
+    }
+    return m, fd, watch, nil
+}
+
+// linkWatch detects loss of an established link.
+type linkWatch struct {
+    bus   *dbus.Conn
+    path  dbus.ObjectPath
+    epfd  int
+    sigCh chan *dbus.Signal
+
+    waited bool // epfd is closed by wait's poller goroutine once set
+}
+
+func newLinkWatch(bus *dbus.Conn, path dbus.ObjectPath, fd int) (*linkWatch, error) {
+    epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+    if err != nil {
+        return nil, fmt.Errorf("connmgr: epoll_create: %w", err)
+    }
+    // Only hang-up conditions; EPOLLIN is deliberately not watched so pending data is left to the caller.
+    ev := syscall.EpollEvent{Events: syscall.EPOLLRDHUP | syscall.EPOLLHUP | syscall.EPOLLERR, Fd: int32(fd)}
+    if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+        syscall.Close(epfd)
+        return nil, fmt.Errorf("connmgr: epoll_ctl: %w", err)
+    }
+    w := &linkWatch{bus: bus, path: path, epfd: epfd, sigCh: make(chan *dbus.Signal, 16)}
+    bus.Signal(w.sigCh)
+    if err := bus.AddMatchSignal(w.matchOptions()...); err != nil {
+        bus.RemoveSignal(w.sigCh)
+        syscall.Close(epfd)
+        return nil, fmt.Errorf("connmgr: AddMatchSignal: %w", err)
+    }
+    return w, nil
+}
+
+func (w *linkWatch) matchOptions() []dbus.MatchOption {
+    return []dbus.MatchOption{
+        dbus.WithMatchObjectPath(w.path),
+        dbus.WithMatchInterface(propsIface),
+        dbus.WithMatchMember("PropertiesChanged"),
+    }
+}
+
+// wait blocks until the link is lost or ctx is done, and returns the cause.
+func (w *linkWatch) wait(ctx context.Context) error {
+    hup := make(chan error, 1)
+    stop := make(chan struct{})
+    defer close(stop)
+    w.waited = true
+    go func() {
+        defer syscall.Close(w.epfd)
+        events := make([]syscall.EpollEvent, 1)
+        for {
+            select {
+            case <-stop:
+                return
+            default:
+            }
+            // Short timeout so the goroutine notices stop.
+            n, err := syscall.EpollWait(w.epfd, events, 500)
+            if err != nil {
+                if errors.Is(err, syscall.EINTR) {
+                    continue
+                }
+                hup <- fmt.Errorf("connmgr: epoll_wait: %w", err)
+                return
+            }
+            if n > 0 {
+                hup <- errors.New("connmgr: link closed by peer")
+                return
+            }
+        }
+    }()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case err := <-hup:
+            return err
+        case sig := <-w.sigCh:
+            if sig == nil || sig.Path != w.path || len(sig.Body) < 2 {
+                continue
+            }
+            if iface, _ := sig.Body[0].(string); iface != deviceIface {
+                continue
+            }
+            changed, _ := sig.Body[1].(map[string]dbus.Variant)
+            if v, ok := changed["Connected"]; ok {
+                if b, ok := v.Value().(bool); ok && !b {
+                    return errors.New("connmgr: device disconnected")
+                }
+            }
+        }
+    }
+}
+
+// stop releases the watch. wait must have returned (or never been called).
+func (w *linkWatch) stop() {
+    _ = w.bus.RemoveMatchSignal(w.matchOptions()...)
+    w.bus.RemoveSignal(w.sigCh)
+    if !w.waited {
+        syscall.Close(w.epfd)
+    }
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "syscall"
+    "testing"
+    "time"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+// reconnector returns a Reconnector for dev whose managers use the fixture's bus.
+func (f *fixture) reconnector(dev connmgr.Device, opts connmgr.ReconnectOptions) *connmgr.Reconnector {
+    opts.Manager = []connmgr.Option{connmgr.WithBusAddress(f.d.Address)}
+    return connmgr.NewReconnector(dev, opts)
+}
+
+// nextState waits for the next Reconnector event and checks its state.
+func nextState(t *testing.T, ch <-chan connmgr.ReconnectEvent, want connmgr.ReconnectState) connmgr.ReconnectEvent {
+    t.Helper()
+    select {
+    case ev, ok := <-ch:
+        if !ok {
+            t.Fatalf("channel closed, want %v", want)
+        }
+        if ev.State != want {
+            t.Fatalf("got %v (attempt %d, %v), want %v", ev.State, ev.Attempt, ev.Err, want)
+        }
+        return ev
+    case <-time.After(5 * time.Second):
+        t.Fatalf("no event, want %v", want)
+    }
+    return connmgr.ReconnectEvent{}
+}
+
+// closed waits for ch to be closed.
+func closed(t *testing.T, ch <-chan connmgr.ReconnectEvent) {
+    t.Helper()
+    select {
+    case ev, ok := <-ch:
+        if ok {
+            t.Fatalf("unexpected %v after the end", ev.State)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("channel not closed")
+    }
+}
+
+func TestReconnectBackoffAndGiveUp(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Paired: true, ConnectError: "org.bluez.Error.Failed"})
+    const (
+        minDelay = 10 * time.Millisecond
+        maxDelay = 40 * time.Millisecond
+        jitter   = 0.5
+    )
+    r := f.reconnector(connmgr.Device{Path: string(p)}, connmgr.ReconnectOptions{
+        InitialBackoff: minDelay, MaxBackoff: maxDelay, Jitter: jitter, MaxAttempts: 5,
+    })
+    ch := r.Run(context.Background())
+    for attempt := 1; attempt < 5; attempt++ {
+        if ev := nextState(t, ch, connmgr.StateConnecting); ev.Attempt != attempt {
+            t.Fatalf("connecting attempt %d, want %d", ev.Attempt, attempt)
+        }
+        ev := nextState(t, ch, connmgr.StateBackoff)
+        if ev.Err == nil {
+            t.Error("backoff without a cause")
+        }
+        lo, hi := time.Duration(float64(minDelay)*(1-jitter)), time.Duration(float64(maxDelay)*(1+jitter))
+        if ev.Delay < lo || ev.Delay > hi {
+            t.Errorf("attempt %d: delay %v outside [%v, %v]", attempt, ev.Delay, lo, hi)
+        }
+    }
+    nextState(t, ch, connmgr.StateConnecting)
+    if ev := nextState(t, ch, connmgr.StateGaveUp); ev.Attempt != 5 || ev.Err == nil {
+        t.Errorf("gave up at attempt %d with %v, want 5 and a cause", ev.Attempt, ev.Err)
+    }
+    closed(t, ch)
+}
+
+func TestReconnectAfterDisconnect(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Paired: true})
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    r := f.reconnector(connmgr.Device{Path: string(p)}, connmgr.ReconnectOptions{InitialBackoff: 10 * time.Millisecond})
+    ch := r.Run(ctx)
+
+    nextState(t, ch, connmgr.StateConnecting)
+    ev := nextState(t, ch, connmgr.StateConnected)
+    defer syscall.Close(ev.FD)
+    // Keep the fake's end open so only the property change can report the loss.
+    l := <-f.bz.Links()
+    defer l.File.Close()
+
+    if err := f.bz.SetProperty(p, "org.bluez.Device1", "Connected", false); err != nil {
+        t.Fatal(err)
+    }
+    if ev := nextState(t, ch, connmgr.StateDisconnected); ev.Err == nil {
+        t.Error("disconnected without a cause")
+    }
+    if ev := nextState(t, ch, connmgr.StateConnecting); ev.Attempt != 1 {
+        t.Errorf("reconnect attempt %d, want 1", ev.Attempt)
+    }
+    ev = nextState(t, ch, connmgr.StateConnected)
+    defer syscall.Close(ev.FD)
+    l = <-f.bz.Links()
+    defer l.File.Close()
+
+    // A hang-up from the remote side is noticed as well.
+    l.File.Close()
+    nextState(t, ch, connmgr.StateDisconnected)
+    nextState(t, ch, connmgr.StateConnecting)
+}
+
+func TestReconnectCancel(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Paired: true, ConnectError: "org.bluez.Error.Failed"})
+    ctx, cancel := context.WithCancel(context.Background())
+    r := f.reconnector(connmgr.Device{Path: string(p)}, connmgr.ReconnectOptions{InitialBackoff: time.Minute})
+    ch := r.Run(ctx)
+    nextState(t, ch, connmgr.StateConnecting)
+    nextState(t, ch, connmgr.StateBackoff)
+    // Run is now waiting out a minute of backoff.
+    cancel()
+    closed(t, ch)
+
+    // Cancelling while connected ends Run too.
+    p2 := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:02", Paired: true})
+    ctx, cancel = context.WithCancel(context.Background())
+    ch = f.reconnector(connmgr.Device{Path: string(p2)}, connmgr.ReconnectOptions{}).Run(ctx)
+    nextState(t, ch, connmgr.StateConnecting)
+    ev := nextState(t, ch, connmgr.StateConnected)
+    defer syscall.Close(ev.FD)
+    l := <-f.bz.Links()
+    defer l.File.Close()
+    cancel()
+    closed(t, ch)
+}
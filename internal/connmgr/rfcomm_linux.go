@@ -0,0 +1,229 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+
+    dbus "github.com/godbus/dbus/v5"
+    "golang.org/x/sys/unix"
+)
+
+// maxRFCOMMChannel is the highest valid RFCOMM server channel.
+const maxRFCOMMChannel = 30
+
+// serverProfile is the validated form of ServerOptions for RegisterProfile.
+type serverProfile struct {
+    uuid      string
+    name      string
+    channel   uint8 // 0 = chosen by BlueZ
+    hasRecord bool  // caller supplied ServiceRecord
+    options   map[string]dbus.Variant
+}
+
+// buildServerProfile validates opts and builds the RegisterProfile options map.
+func buildServerProfile(opts ServerOptions) (serverProfile, error) {
+    sp := serverProfile{uuid: SPPUUID, name: opts.ServiceName, channel: opts.Channel, hasRecord: opts.ServiceRecord != ""}
+    if opts.UUID != "" {
+        if _, err := parseUUID128(opts.UUID); err != nil {
+            return serverProfile{}, err
+        }
+        sp.uuid = strings.ToLower(opts.UUID)
+    }
+    if opts.Channel > maxRFCOMMChannel {
+        return serverProfile{}, fmt.Errorf("connmgr: invalid RFCOMM channel %d (want 1-%d, or 0 for automatic)", opts.Channel, maxRFCOMMChannel)
+    }
+    if opts.PSM != 0 && !validPSM(opts.PSM) {
+        return serverProfile{}, fmt.Errorf("connmgr: invalid L2CAP PSM 0x%04x (must be odd with bit 8 clear)", opts.PSM)
+    }
+    if opts.ServiceRecord != "" {
+        if err := checkXML(opts.ServiceRecord); err != nil {
+            return serverProfile{}, fmt.Errorf("connmgr: invalid ServiceRecord: %w", err)
+        }
+    }
+
+    sp.options = map[string]dbus.Variant{
+        "Name": dbus.MakeVariant(opts.ServiceName),
+        "Role": dbus.MakeVariant("server"),
+    }
+    if opts.Channel != 0 {
+        // BlueZ expects Channel as a uint16 (not byte).
+        sp.options["Channel"] = dbus.MakeVariant(uint16(opts.Channel))
+    }
+    if opts.PSM != 0 {
+        sp.options["PSM"] = dbus.MakeVariant(opts.PSM)
+    }
+    // Booleans are only sent when set, so false keeps BlueZ's per-UUID default
+    // (e.g. SPP requires authorization by default).
+    if opts.RequireAuthentication {
+        sp.options["RequireAuthentication"] = dbus.MakeVariant(true)
+    }
+    if opts.RequireAuthorization {
+        sp.options["RequireAuthorization"] = dbus.MakeVariant(true)
+    }
+    if opts.AutoConnect {
+        sp.options["AutoConnect"] = dbus.MakeVariant(true)
+    }
+    if opts.ServiceRecord != "" {
+        sp.options["ServiceRecord"] = dbus.MakeVariant(opts.ServiceRecord)
+    }
+    return sp, nil
+}
+
+// prepare checks the requested channel and, for a custom UUID without a caller-supplied
+// record, generates a record that advertises both the UUID and SPP. That record must name
+// its channel, so with Channel 0 a free channel is picked here instead of by BlueZ.
+func (sp *serverProfile) prepare() error {
+    if sp.channel != 0 {
+        if err := probeRFCOMMChannel(sp.channel); err != nil {
+            return err
+        }
+    }
+    if strings.EqualFold(sp.uuid, SPPUUID) || sp.hasRecord {
+        return nil
+    }
+    if sp.channel == 0 {
+        ch, err := freeRFCOMMChannel()
+        if err != nil {
+            return err
+        }
+        sp.channel = ch
+        sp.options["Channel"] = dbus.MakeVariant(uint16(ch))
+    }
+    sp.options["ServiceRecord"] = dbus.MakeVariant(serviceRecordXML(sp.uuid, sp.name, sp.channel))
+    return nil
+}
+
+// serviceRecordXML builds a BlueZ XML SDP record whose ServiceClassIDList holds uuid followed
+// by SPP, so generic SPP clients still see the service while Device1.UUIDs on peers contain uuid.
+func serviceRecordXML(uuid, name string, channel uint8) string {
+    var esc strings.Builder
+    _ = xml.EscapeText(&esc, []byte(name))
+    return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" ?>
+<record>
+  <attribute id="0x0001">
+    <sequence>
+      <uuid value="%s" />
+      <uuid value="0x1101" />
+    </sequence>
+  </attribute>
+  <attribute id="0x0004">
+    <sequence>
+      <sequence>
+        <uuid value="0x0100" />
+      </sequence>
+      <sequence>
+        <uuid value="0x0003" />
+        <uint8 value="0x%02x" />
+      </sequence>
+    </sequence>
+  </attribute>
+  <attribute id="0x0005">
+    <sequence>
+      <uuid value="0x1002" />
+    </sequence>
+  </attribute>
+  <attribute id="0x0009">
+    <sequence>
+      <sequence>
+        <uuid value="0x1101" />
+        <uint16 value="0x0102" />
+      </sequence>
+    </sequence>
+  </attribute>
+  <attribute id="0x0100">
+    <text value="%s" />
+  </attribute>
+</record>
+`, uuid, channel, esc.String())
+}
+
+// validPSM reports whether psm is a valid L2CAP PSM: the least significant bit of the
+// low octet is 1 and the least significant bit of the high octet is 0.
+func validPSM(psm uint16) bool {
+    return psm&0x0101 == 0x0001
+}
+
+// checkXML verifies that s is well-formed XML.
+func checkXML(s string) error {
+    d := xml.NewDecoder(strings.NewReader(s))
+    sawElement := false
+    for {
+        tok, err := d.Token()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if _, ok := tok.(xml.StartElement); ok {
+            sawElement = true
+        }
+    }
+    if !sawElement {
+        return errors.New("no XML element")
+    }
+    return nil
+}
+
+// probeRFCOMMChannel reports an error if another RFCOMM server is already bound to ch
+// on any local adapter. If Bluetooth sockets are unavailable the probe is skipped.
+func probeRFCOMMChannel(ch uint8) error {
+    fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.BTPROTO_RFCOMM)
+    if err != nil {
+        return nil
+    }
+    defer unix.Close(fd)
+    err = unix.Bind(fd, &unix.SockaddrRFCOMM{Channel: ch})
+    if errors.Is(err, unix.EADDRINUSE) {
+        return fmt.Errorf("%w (channel %d)", ErrChannelInUse, ch)
+    }
+    return nil
+}
+
+// freeRFCOMMChannel asks the kernel for the first unused RFCOMM server channel.
+// The channel is released again before returning, so another server may still take it.
+func freeRFCOMMChannel() (uint8, error) {
+    fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.BTPROTO_RFCOMM)
+    if err != nil {
+        return 0, fmt.Errorf("connmgr: pick RFCOMM channel: %w", err)
+    }
+    defer unix.Close(fd)
+    // Channel 0 is assigned on listen(2).
+    if err := unix.Bind(fd, &unix.SockaddrRFCOMM{}); err != nil {
+        return 0, fmt.Errorf("connmgr: pick RFCOMM channel: %w", err)
+    }
+    if err := unix.Listen(fd, 1); err != nil {
+        return 0, fmt.Errorf("connmgr: pick RFCOMM channel: %w", err)
+    }
+    sa, err := unix.Getsockname(fd)
+    if err != nil {
+        return 0, fmt.Errorf("connmgr: pick RFCOMM channel: %w", err)
+    }
+    rc, ok := sa.(*unix.SockaddrRFCOMM)
+    if !ok || rc.Channel == 0 {
+        return 0, errors.New("connmgr: pick RFCOMM channel: no free channel")
+    }
+    return rc.Channel, nil
+}
+
+// registerProfileError classifies RegisterProfile failures. BlueZ reports a taken channel
+// only as a generic failure, so with a fixed channel the channel is probed again (a server
+// may have bound it since prepare): it is ErrChannelInUse only if the bind saw EADDRINUSE.
+func registerProfileError(sp serverProfile, err error) error {
+    op := "RegisterProfile(server)"
+    var dErr dbus.Error
+    if errors.As(err, &dErr) && sp.channel != 0 {
+        switch dErr.Name {
+        case "org.bluez.Error.NotPermitted", "org.bluez.Error.Failed":
+            if errors.Is(probeRFCOMMChannel(sp.channel), ErrChannelInUse) {
+                return &OpError{Op: op, Kind: ErrChannelInUse, Err: err}
+            }
+        }
+    }
+    return opError(op, err)
+}
@@ -0,0 +1,482 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+func (m *mgr) ScanSPP(ctx context.Context, opts ScanOptions) ([]Device, error) {
+    events, err := m.WatchSPP(ctx, opts)
+    if err != nil {
+        return nil, err
+    }
+    devMap := make(map[string]Device)
+    for ev := range events {
+        switch ev.Type {
+        case DeviceAdded, DeviceUpdated:
+            devMap[ev.Device.Path] = ev.Device
+        case DeviceRemoved:
+            delete(devMap, ev.Device.Path)
+        }
+    }
+
+    out := make([]Device, 0, len(devMap))
+    for _, d := range devMap {
+        out = append(out, d)
+    }
+    sortDevices(out)
+    if opts.MaxResults > 0 && len(out) > opts.MaxResults {
+        out = out[:opts.MaxResults]
+    }
+    return out, nil
+}
+
+// sortDevices orders devices by RSSI (strongest first, unknown last), then by name and path,
+// so equal entries keep the same order from one scan to the next.
+func sortDevices(devs []Device) {
+    sort.Slice(devs, func(i, j int) bool {
+        a, b := devs[i], devs[j]
+        if (a.RSSI == 0) != (b.RSSI == 0) {
+            return b.RSSI == 0
+        }
+        if a.RSSI != b.RSSI {
+            return a.RSSI > b.RSSI
+        }
+        if na, nb := sortName(a), sortName(b); na != nb {
+            return na < nb
+        }
+        return a.Path < b.Path
+    })
+}
+
+func sortName(d Device) string {
+    name := d.Alias
+    if name == "" {
+        name = d.Name
+    }
+    return strings.ToLower(name)
+}
+
+func (m *mgr) WatchSPP(ctx context.Context, opts ScanOptions) (<-chan DeviceEvent, error) {
+    m.mu.Lock()
+    if m.closed {
+        m.mu.Unlock()
+        return nil, ErrClosed
+    }
+    if err := m.ensureBusLocked(); err != nil {
+        m.mu.Unlock()
+        return nil, err
+    }
+    bus := m.bus
+    m.mu.Unlock()
+
+    uuid := SPPUUID
+    if opts.UUID != "" {
+        if _, err := parseUUID128(opts.UUID); err != nil {
+            return nil, err
+        }
+        uuid = strings.ToLower(opts.UUID)
+    }
+    filter, err := newScanFilter(opts)
+    if err != nil {
+        return nil, err
+    }
+    pinned, err := m.resolveAdapter(ctx, bus, opts.Adapter)
+    if err != nil {
+        return nil, err
+    }
+    adapters := []dbus.ObjectPath{pinned}
+    if pinned == "" {
+        if adapters, err = m.listAdapters(ctx, bus); err != nil {
+            return nil, err
+        }
+        if len(adapters) == 0 {
+            return nil, ErrNoAdapter
+        }
+    }
+
+    // Subscribe before taking the snapshot so no change falls in between.
+    sigCh := make(chan *dbus.Signal, 16)
+    bus.Signal(sigCh)
+    matches := [][]dbus.MatchOption{
+        {dbus.WithMatchSender(m.cfg.service), dbus.WithMatchInterface(objManagerIface), dbus.WithMatchMember("InterfacesAdded")},
+        {dbus.WithMatchSender(m.cfg.service), dbus.WithMatchInterface(objManagerIface), dbus.WithMatchMember("InterfacesRemoved")},
+        {dbus.WithMatchSender(m.cfg.service), dbus.WithMatchInterface(propsIface), dbus.WithMatchMember("PropertiesChanged"), dbus.WithMatchArg(0, deviceIface)},
+    }
+    unsubscribe := func(n int) {
+        for _, opts := range matches[:n] {
+            _ = bus.RemoveMatchSignal(opts...)
+        }
+        bus.RemoveSignal(sigCh)
+    }
+    for i, opts := range matches {
+        if err := bus.AddMatchSignal(opts...); err != nil {
+            unsubscribe(i)
+            return nil, fmt.Errorf("connmgr: AddMatchSignal: %w", err)
+        }
+    }
+
+    objs, err := m.managedObjects(ctx, bus)
+    if err != nil {
+        unsubscribe(len(matches))
+        return nil, err
+    }
+
+    // Start discovery on all adapters (best-effort); stopped when the watch ends.
+    for _, ap := range adapters {
+        if filter.dbus != nil {
+            if err := bus.Object(m.cfg.service, ap).Call(adapterIface+".SetDiscoveryFilter", 0, filter.dbus).Err; err != nil {
+                m.cfg.logger.Printf("connmgr: SetDiscoveryFilter on %s: %v", ap, err)
+            }
+        }
+        if err := bus.Object(m.cfg.service, ap).Call(adapterIface+".StartDiscovery", 0).Err; err != nil {
+            m.cfg.logger.Printf("connmgr: StartDiscovery on %s: %v", ap, err)
+        }
+    }
+
+    w := &sppWatch{
+        m:       m,
+        adapter: pinned,
+        uuid:    uuid,
+        filter:  filter,
+        out:     make(chan DeviceEvent, 16),
+        props:   make(map[dbus.ObjectPath]map[string]dbus.Variant),
+        seen:    make(map[dbus.ObjectPath]time.Time),
+        listed:  make(map[dbus.ObjectPath]Device),
+    }
+    w.sdp = newSDPResolver(ctx, m, bus, uuid)
+    go func() {
+        defer close(w.out)
+        defer unsubscribe(len(matches))
+        defer func() {
+            for _, ap := range adapters {
+                if err := bus.Object(m.cfg.service, ap).Call(adapterIface+".StopDiscovery", 0).Err; err != nil {
+                    m.cfg.logger.Printf("connmgr: StopDiscovery on %s: %v", ap, err)
+                }
+                // An empty filter clears ours, so later scans start unfiltered.
+                if filter.dbus != nil {
+                    _ = bus.Object(m.cfg.service, ap).Call(adapterIface+".SetDiscoveryFilter", 0, map[string]dbus.Variant{}).Err
+                }
+            }
+        }()
+        // Abandoned SDP queries must finish before the resolver's channel is dropped.
+        defer w.sdp.wait()
+
+        for path, ifaces := range objs {
+            if props, ok := ifaces[deviceIface]; ok && onAdapter(path, pinned) {
+                // Not heard: a cached RSSI may be arbitrarily old, so LastSeen waits for
+                // the first discovery result of this watch.
+                w.props[path] = props
+                if !w.update(ctx, path) {
+                    return
+                }
+            }
+        }
+        w.loop(ctx, sigCh)
+    }()
+    return w.out, nil
+}
+
+// sppWatch tracks Device1 objects for WatchSPP. All fields are owned by the watch goroutine.
+type sppWatch struct {
+    m       *mgr
+    adapter dbus.ObjectPath // "" for all adapters
+    uuid    string          // service UUID devices must advertise
+    filter  scanFilter
+    out     chan DeviceEvent
+    sdp     *sdpResolver
+
+    props  map[dbus.ObjectPath]map[string]dbus.Variant // all known Device1 objects, SPP or not
+    seen   map[dbus.ObjectPath]time.Time               // last discovery result per device
+    listed map[dbus.ObjectPath]Device                  // devices reported to the caller
+}
+
+// heard records a discovery result for path if props carry an RSSI reading.
+func (w *sppWatch) heard(path dbus.ObjectPath, props map[string]dbus.Variant) {
+    if _, ok := props["RSSI"]; ok {
+        w.seen[path] = time.Now()
+    }
+}
+
+func (w *sppWatch) loop(ctx context.Context, sigCh <-chan *dbus.Signal) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case mac := <-w.sdp.resolved:
+            for path, d := range w.listed {
+                if d.MAC == mac && !w.update(ctx, path) {
+                    return
+                }
+            }
+        case sig := <-sigCh:
+            if sig == nil {
+                continue
+            }
+            path, ok := w.apply(sig)
+            if ok && onAdapter(path, w.adapter) && !w.update(ctx, path) {
+                return
+            }
+        }
+    }
+}
+
+// apply merges a signal into the property cache and returns the affected device path.
+func (w *sppWatch) apply(sig *dbus.Signal) (dbus.ObjectPath, bool) {
+    switch sig.Name {
+    case objManagerIface + ".InterfacesAdded":
+        if len(sig.Body) < 2 {
+            return "", false
+        }
+        path, _ := sig.Body[0].(dbus.ObjectPath)
+        ifaces, _ := sig.Body[1].(map[string]map[string]dbus.Variant)
+        props, ok := ifaces[deviceIface]
+        if !ok {
+            return "", false
+        }
+        w.props[path] = props
+        w.heard(path, props)
+        return path, true
+    case objManagerIface + ".InterfacesRemoved":
+        if len(sig.Body) < 2 {
+            return "", false
+        }
+        path, _ := sig.Body[0].(dbus.ObjectPath)
+        removed, _ := sig.Body[1].([]string)
+        for _, iface := range removed {
+            if iface == deviceIface {
+                delete(w.props, path)
+                delete(w.seen, path)
+                return path, true
+            }
+        }
+    case propsIface + ".PropertiesChanged":
+        if len(sig.Body) < 3 {
+            return "", false
+        }
+        if iface, _ := sig.Body[0].(string); iface != deviceIface {
+            return "", false
+        }
+        props, ok := w.props[sig.Path]
+        if !ok {
+            // Device not announced yet; InterfacesAdded will carry the full set.
+            return "", false
+        }
+        changed, _ := sig.Body[1].(map[string]dbus.Variant)
+        for k, v := range changed {
+            props[k] = v
+        }
+        invalidated, _ := sig.Body[2].([]string)
+        for _, k := range invalidated {
+            delete(props, k)
+        }
+        w.heard(sig.Path, changed)
+        return sig.Path, true
+    }
+    return "", false
+}
+
+// update emits Added/Updated/Removed for path if its visible state changed.
+// It reports false if ctx ended while sending.
+func (w *sppWatch) update(ctx context.Context, path dbus.ObjectPath) bool {
+    prev, wasListed := w.listed[path]
+    var (
+        dev Device
+        ok  bool
+    )
+    if props, known := w.props[path]; known {
+        dev, ok = deviceFromIfaces(path, map[string]map[string]dbus.Variant{deviceIface: props}, w.uuid)
+        if ok {
+            dev.LastSeen = w.seen[path]
+            ok = w.filter.match(dev, props)
+        }
+    }
+    var ev DeviceEvent
+    switch {
+    case ok:
+        w.sdp.resolve(dev)
+        dev = w.m.withSDP(dev)
+        if wasListed && dev == prev {
+            return true
+        }
+        w.listed[path] = dev
+        ev = DeviceEvent{Type: DeviceUpdated, Device: dev}
+        if !wasListed {
+            ev.Type = DeviceAdded
+        }
+    case wasListed:
+        // Gone, or no longer advertising SPP.
+        delete(w.listed, path)
+        ev = DeviceEvent{Type: DeviceRemoved, Device: prev}
+    default:
+        return true
+    }
+    select {
+    case w.out <- ev:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// scanFilter is the validated discovery filter of ScanOptions. dbus is the
+// SetDiscoveryFilter argument, nil if no filter was requested.
+type scanFilter struct {
+    dbus        map[string]dbus.Variant
+    minRSSI     int16
+    maxPathloss uint16
+    pattern     string
+}
+
+func newScanFilter(opts ScanOptions) (scanFilter, error) {
+    f := scanFilter{minRSSI: opts.MinRSSI, maxPathloss: opts.MaxPathloss, pattern: opts.NamePattern}
+    if opts.MaxResults < 0 {
+        return scanFilter{}, fmt.Errorf("connmgr: invalid MaxResults %d", opts.MaxResults)
+    }
+    if opts.MinRSSI != 0 && opts.MaxPathloss != 0 {
+        return scanFilter{}, errors.New("connmgr: MinRSSI and MaxPathloss are mutually exclusive")
+    }
+    filter := make(map[string]dbus.Variant)
+    switch opts.Transport {
+    case "", "auto":
+    case "bredr", "le":
+        filter["Transport"] = dbus.MakeVariant(opts.Transport)
+    default:
+        return scanFilter{}, fmt.Errorf("connmgr: invalid Transport %q (want auto, bredr or le)", opts.Transport)
+    }
+    if opts.MinRSSI != 0 {
+        filter["RSSI"] = dbus.MakeVariant(opts.MinRSSI)
+    }
+    if opts.MaxPathloss != 0 {
+        filter["Pathloss"] = dbus.MakeVariant(opts.MaxPathloss)
+    }
+    // Always sent: BlueZ defaults to true, so leaving it out could not turn it off.
+    filter["DuplicateData"] = dbus.MakeVariant(opts.DuplicateData)
+    if opts.NamePattern != "" {
+        filter["Pattern"] = dbus.MakeVariant(opts.NamePattern)
+    }
+    f.dbus = filter
+    return f, nil
+}
+
+// match applies the RSSI, pathloss and name filters to a device already known to BlueZ,
+// which SetDiscoveryFilter does not hide.
+func (f scanFilter) match(d Device, props map[string]dbus.Variant) bool {
+    if f.minRSSI != 0 && (d.RSSI == 0 || d.RSSI < f.minRSSI) {
+        return false
+    }
+    if f.maxPathloss != 0 {
+        tx, ok := props["TxPower"].Value().(int16)
+        if !ok || d.RSSI == 0 || int(tx)-int(d.RSSI) > int(f.maxPathloss) {
+            return false
+        }
+    }
+    if f.pattern != "" && !strings.HasPrefix(d.Name, f.pattern) &&
+        !strings.HasPrefix(strings.ToUpper(d.MAC), strings.ToUpper(f.pattern)) {
+        return false
+    }
+    return true
+}
+
+// sdpResolverConcurrency bounds parallel SDP queries (each pages the remote device).
+const sdpResolverConcurrency = 2
+
+// sdpResolver runs SDP queries for scan candidates and stores results in the manager cache.
+// resolve is called only from the watching goroutine; each successful query reports the
+// device MAC on resolved.
+type sdpResolver struct {
+    ctx      context.Context
+    m        *mgr
+    bus      *dbus.Conn
+    uuid     string
+    sem      chan struct{}
+    wg       sync.WaitGroup
+    seen     map[string]bool
+    resolved chan string
+}
+
+func newSDPResolver(ctx context.Context, m *mgr, bus *dbus.Conn, uuid string) *sdpResolver {
+    return &sdpResolver{
+        ctx:      ctx,
+        m:        m,
+        bus:      bus,
+        uuid:     uuid,
+        sem:      make(chan struct{}, sdpResolverConcurrency),
+        seen:     make(map[string]bool),
+        resolved: make(chan string),
+    }
+}
+
+// resolve starts a query for dev unless it was already started or cached. The query goes
+// through the adapter dev was discovered on.
+func (r *sdpResolver) resolve(dev Device) {
+    mac := dev.MAC
+    if mac == "" || r.seen[mac] {
+        return
+    }
+    r.seen[mac] = true
+    if _, ok := r.m.cachedSDP(mac, r.uuid); ok {
+        return
+    }
+    r.wg.Add(1)
+    go func() {
+        defer r.wg.Done()
+        select {
+        case r.sem <- struct{}{}:
+        case <-r.ctx.Done():
+            return
+        }
+        defer func() { <-r.sem }()
+        local := r.m.adapterAddress(r.ctx, r.bus, dbus.ObjectPath(dev.Adapter))
+        info, err := querySDP(r.ctx, local, mac, r.uuid)
+        if err != nil {
+            // Not cached: the device may be out of range now and answer in a later scan.
+            return
+        }
+        r.m.cacheSDP(mac, r.uuid, info)
+        select {
+        case r.resolved <- mac:
+        case <-r.ctx.Done():
+        }
+    }()
+}
+
+// wait blocks until all started queries finished or were abandoned.
+// The watch loop must have stopped receiving on resolved only after ctx ended.
+func (r *sdpResolver) wait() { r.wg.Wait() }
+
+func (m *mgr) cachedSDP(mac, uuid string) (sdpInfo, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    info, ok := m.sdpCache[sdpKey(mac, uuid)]
+    return info, ok
+}
+
+func (m *mgr) cacheSDP(mac, uuid string, info sdpInfo) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.sdpCache == nil {
+        m.sdpCache = make(map[string]sdpInfo)
+    }
+    m.sdpCache[sdpKey(mac, uuid)] = info
+}
+
+func sdpKey(mac, uuid string) string { return mac + "/" + uuid }
+
+// withSDP fills ServiceName/Channel from the SDP cache when available.
+func (m *mgr) withSDP(d Device) Device {
+    if info, ok := m.cachedSDP(d.MAC, d.UUID); ok {
+        d.ServiceName = info.ServiceName
+        d.Channel = info.Channel
+    }
+    return d
+}
@@ -0,0 +1,203 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+// scan runs ScanSPP for a short time.
+func (f *fixture) scan(t *testing.T, opts connmgr.ScanOptions) []connmgr.Device {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+    defer cancel()
+    devs, err := f.m.ScanSPP(ctx, opts)
+    if err != nil {
+        t.Fatalf("ScanSPP: %v", err)
+    }
+    return devs
+}
+
+func names(devs []connmgr.Device) []string {
+    var out []string
+    for _, d := range devs {
+        out = append(out, d.Name)
+    }
+    return out
+}
+
+func equal(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func TestScanSPPFiltersAndOrder(t *testing.T) {
+    f := newFixture(t)
+    spp := []string{connmgr.SPPUUID}
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:01", Name: "weak", RSSI: -80, UUIDs: spp})
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:02", Name: "strong", RSSI: -40, UUIDs: spp})
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:03", Name: "unknown-b", UUIDs: spp})
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:04", Name: "unknown-a", UUIDs: spp})
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:05", Name: "headset", RSSI: -30, UUIDs: []string{"0000110b-0000-1000-8000-00805f9b34fb"}})
+    f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:06", Name: "chat", RSSI: -50, UUIDs: []string{connmgr.ChatServiceUUID}})
+
+    tests := []struct {
+        name string
+        opts connmgr.ScanOptions
+        want []string
+    }{
+        {"spp", connmgr.ScanOptions{}, []string{"strong", "weak", "unknown-a", "unknown-b"}},
+        {"uuid", connmgr.ScanOptions{UUID: connmgr.ChatServiceUUID}, []string{"chat"}},
+        {"min rssi", connmgr.ScanOptions{MinRSSI: -60}, []string{"strong"}},
+        {"pattern", connmgr.ScanOptions{NamePattern: "unknown-"}, []string{"unknown-a", "unknown-b"}},
+        {"pattern address", connmgr.ScanOptions{NamePattern: "00:00:00:00:00:0"}, []string{"strong", "weak", "unknown-a", "unknown-b"}},
+        {"max results", connmgr.ScanOptions{MaxResults: 2}, []string{"strong", "weak"}},
+        {"adapter", connmgr.ScanOptions{Adapter: "hci0"}, []string{"strong", "weak", "unknown-a", "unknown-b"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            devs := f.scan(t, tt.opts)
+            if got := names(devs); !equal(got, tt.want) {
+                t.Fatalf("got %q, want %q", got, tt.want)
+            }
+            for _, d := range devs {
+                if d.Path == "" || d.Adapter != string(f.hci0) || d.MAC == "" {
+                    t.Errorf("incomplete device %+v", d)
+                }
+                // Known before the scan and not heard during it.
+                if !d.LastSeen.IsZero() {
+                    t.Errorf("%s: LastSeen set from the snapshot", d.Name)
+                }
+            }
+        })
+    }
+}
+
+func TestScanSPPInvalidOptions(t *testing.T) {
+    f := newFixture(t)
+    for _, opts := range []connmgr.ScanOptions{
+        {Transport: "usb"},
+        {MinRSSI: -60, MaxPathloss: 20},
+        {MaxResults: -1},
+        {UUID: "1101"},
+    } {
+        if _, err := f.m.ScanSPP(context.Background(), opts); err == nil {
+            t.Errorf("%+v: no error", opts)
+        }
+    }
+    if _, err := f.m.ScanSPP(context.Background(), connmgr.ScanOptions{Adapter: "hci9"}); err == nil {
+        t.Error("unknown adapter: no error")
+    }
+}
+
+func TestWatchSPPDiscoveryFilter(t *testing.T) {
+    f := newFixture(t)
+    ctx, cancel := context.WithCancel(context.Background())
+    events, err := f.m.WatchSPP(ctx, connmgr.ScanOptions{Transport: "bredr", MinRSSI: -70, NamePattern: "chat"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    filter := f.bz.DiscoveryFilter(f.hci0)
+    want := map[string]interface{}{"Transport": "bredr", "RSSI": int16(-70), "DuplicateData": false, "Pattern": "chat"}
+    if len(filter) != len(want) {
+        t.Errorf("filter %v, want %v", filter, want)
+    }
+    for k, v := range want {
+        if got := filter[k].Value(); got != v {
+            t.Errorf("filter[%s] = %v, want %v", k, got, v)
+        }
+    }
+    if v, _ := f.bz.Property(f.hci0, "org.bluez.Adapter1", "Discovering"); v != true {
+        t.Error("discovery not started")
+    }
+    cancel()
+    for range events {
+    }
+    if filter := f.bz.DiscoveryFilter(f.hci0); len(filter) != 0 {
+        t.Errorf("filter %v left after the watch", filter)
+    }
+    if v, _ := f.bz.Property(f.hci0, "org.bluez.Adapter1", "Discovering"); v != false {
+        t.Error("discovery not stopped")
+    }
+}
+
+// nextDeviceEvent waits for the next WatchSPP event.
+func nextDeviceEvent(t *testing.T, events <-chan connmgr.DeviceEvent) connmgr.DeviceEvent {
+    t.Helper()
+    select {
+    case ev, ok := <-events:
+        if !ok {
+            t.Fatal("watch ended")
+        }
+        return ev
+    case <-time.After(5 * time.Second):
+        t.Fatal("no device event")
+    }
+    return connmgr.DeviceEvent{}
+}
+
+func TestWatchSPPOrdering(t *testing.T) {
+    f := newFixture(t)
+    spp := []string{connmgr.SPPUUID}
+    known := f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:01", Name: "known", RSSI: -50, UUIDs: spp})
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    events, err := f.m.WatchSPP(ctx, connmgr.ScanOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := func(typ connmgr.DeviceEventType, path dbus.ObjectPath) connmgr.DeviceEvent {
+        t.Helper()
+        ev := nextDeviceEvent(t, events)
+        if ev.Type != typ || ev.Device.Path != string(path) {
+            t.Fatalf("got %s %s, want %s %s", ev.Type, ev.Device.Path, typ, path)
+        }
+        return ev
+    }
+
+    // Devices known before the watch come first, without LastSeen.
+    if ev := expect(connmgr.DeviceAdded, known); !ev.Device.LastSeen.IsZero() {
+        t.Error("LastSeen set for a device from the snapshot")
+    }
+
+    // A device found during the watch, first without its service UUIDs.
+    late := f.addDevice(t, fakebluez.Device{Address: "00:00:00:00:00:02", Name: "late"})
+    if err := f.bz.SetProperty(late, "org.bluez.Device1", "UUIDs", spp); err != nil {
+        t.Fatal(err)
+    }
+    expect(connmgr.DeviceAdded, late)
+
+    // A discovery result.
+    if err := f.bz.SetProperty(known, "org.bluez.Device1", "RSSI", int16(-42)); err != nil {
+        t.Fatal(err)
+    }
+    ev := expect(connmgr.DeviceUpdated, known)
+    if ev.Device.RSSI != -42 || ev.Device.LastSeen.IsZero() {
+        t.Errorf("update %+v, want RSSI -42 and LastSeen set", ev.Device)
+    }
+
+    if err := f.bz.RemoveDevice(late); err != nil {
+        t.Fatal(err)
+    }
+    if ev := expect(connmgr.DeviceRemoved, late); ev.Device.Name != "late" {
+        t.Errorf("removed device %+v, want its last state", ev.Device)
+    }
+
+    cancel()
+    for range events {
+    }
+}
@@ -0,0 +1,390 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "context"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+    "golang.org/x/sys/unix"
+)
+
+// Minimal SDP client (Bluetooth Core Spec Vol 3, Part B) used to read the remote
+// ServiceName and RFCOMM channel of a service. BlueZ does not expose remote SDP
+// records over D-Bus, so the query goes over an L2CAP socket on PSM 1.
+
+const (
+    sdpPSM = 1
+
+    sdpErrorRsp             = 0x01
+    sdpServiceSearchAttrReq = 0x06
+    sdpServiceSearchAttrRsp = 0x07
+
+    sdpAttrProtocolDescriptorList = 0x0004
+    sdpAttrServiceName            = 0x0100 // primary language base (0x0100) + 0x0000
+
+    sdpUUIDRFCOMM = 0x0003
+
+    sdpMaxAttrBytes = 0xffff
+    // sdpQueryTimeout bounds a single query, including paging the remote device.
+    sdpQueryTimeout = 10 * time.Second
+    // peerSDPTimeout bounds the lookup of an accepted peer's ServiceName; the link is already
+    // up, so a responsive peer answers well within it.
+    peerSDPTimeout = 2 * time.Second
+)
+
+// sdpInfo is what ScanSPP needs from a remote service record.
+type sdpInfo struct {
+    ServiceName string
+    Channel     uint8
+}
+
+// querySDP connects to the SDP server of mac and returns the first record of service uuid
+// that carries an RFCOMM channel, preferring records that also carry a ServiceName. local is
+// the address of the adapter to query through ("" lets the kernel pick one).
+func querySDP(ctx context.Context, local, mac, uuid string) (sdpInfo, error) {
+    addr, err := parseMAC(mac)
+    if err != nil {
+        return sdpInfo{}, err
+    }
+    var localAddr [6]byte
+    if local != "" {
+        if localAddr, err = parseMAC(local); err != nil {
+            return sdpInfo{}, err
+        }
+    }
+    u, err := parseUUID128(uuid)
+    if err != nil {
+        return sdpInfo{}, err
+    }
+    ctx, cancel := context.WithTimeout(ctx, sdpQueryTimeout)
+    defer cancel()
+
+    fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+    if err != nil {
+        return sdpInfo{}, fmt.Errorf("connmgr: sdp socket: %w", err)
+    }
+    defer unix.Close(fd)
+    if local != "" {
+        // Without the bind the kernel routes by its own choice, possibly via another adapter.
+        if err := unix.Bind(fd, &unix.SockaddrL2{Addr: localAddr}); err != nil {
+            return sdpInfo{}, fmt.Errorf("connmgr: sdp bind %s: %w", local, err)
+        }
+    }
+    if err := unix.Connect(fd, &unix.SockaddrL2{PSM: sdpPSM, Addr: addr}); err != nil && !errors.Is(err, unix.EINPROGRESS) {
+        return sdpInfo{}, fmt.Errorf("connmgr: sdp connect %s: %w", mac, err)
+    }
+    if err := pollFD(ctx, fd, unix.POLLOUT); err != nil {
+        return sdpInfo{}, fmt.Errorf("connmgr: sdp connect %s: %w", mac, err)
+    }
+    if soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR); err != nil || soErr != 0 {
+        if err == nil {
+            err = unix.Errno(soErr)
+        }
+        return sdpInfo{}, fmt.Errorf("connmgr: sdp connect %s: %w", mac, err)
+    }
+
+    var (
+        lists []byte
+        cont  = []byte{0}
+        tid   uint16
+        buf   = make([]byte, 4096)
+    )
+    for {
+        tid++
+        if _, err := unix.Write(fd, sdpSearchAttrRequest(tid, u, cont)); err != nil {
+            return sdpInfo{}, fmt.Errorf("connmgr: sdp write: %w", err)
+        }
+        if err := pollFD(ctx, fd, unix.POLLIN); err != nil {
+            return sdpInfo{}, fmt.Errorf("connmgr: sdp read: %w", err)
+        }
+        n, err := unix.Read(fd, buf)
+        if err != nil {
+            return sdpInfo{}, fmt.Errorf("connmgr: sdp read: %w", err)
+        }
+        part, next, err := parseSearchAttrResponse(buf[:n], tid)
+        if err != nil {
+            return sdpInfo{}, err
+        }
+        lists = append(lists, part...)
+        if len(next) == 0 {
+            break
+        }
+        cont = append([]byte{byte(len(next))}, next...)
+    }
+    return pickSDPRecord(lists)
+}
+
+// adapterAddress returns the Address of the adapter at path, or "" if path is empty or the
+// property cannot be read.
+func (m *mgr) adapterAddress(ctx context.Context, bus *dbus.Conn, path dbus.ObjectPath) string {
+    if path == "" || bus == nil {
+        return ""
+    }
+    var addr string
+    if err := bus.Object(m.cfg.service, path).CallWithContext(ctx, propsIface+".Get", 0, adapterIface, "Address").Store(&addr); err != nil {
+        return ""
+    }
+    return addr
+}
+
+// pollFD waits until fd is ready for events, ctx is done, or the socket fails.
+func pollFD(ctx context.Context, fd int, events int16) error {
+    for {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        pfd := []unix.PollFd{{Fd: int32(fd), Events: events}}
+        // Short slices so ctx cancellation is noticed promptly.
+        n, err := unix.Poll(pfd, 200)
+        if err != nil {
+            if errors.Is(err, unix.EINTR) {
+                continue
+            }
+            return err
+        }
+        if n == 0 {
+            continue
+        }
+        if pfd[0].Revents&events != 0 {
+            return nil
+        }
+        if pfd[0].Revents&(unix.POLLERR|unix.POLLHUP|unix.POLLNVAL) != 0 {
+            if soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR); err == nil && soErr != 0 {
+                return unix.Errno(soErr)
+            }
+            return errors.New("connection closed")
+        }
+    }
+}
+
+// sdpSearchAttrRequest builds an SDP_ServiceSearchAttributeRequest for one UUID128,
+// asking for ProtocolDescriptorList and ServiceName.
+func sdpSearchAttrRequest(tid uint16, uuid [16]byte, cont []byte) []byte {
+    var p []byte
+    // ServiceSearchPattern: DES { UUID128 }
+    p = append(p, 0x35, 17, 0x1c)
+    p = append(p, uuid[:]...)
+    // MaximumAttributeByteCount
+    p = binary.BigEndian.AppendUint16(p, sdpMaxAttrBytes)
+    // AttributeIDList: DES { uint16 0x0004, uint16 0x0100 }
+    p = append(p, 0x35, 6, 0x09)
+    p = binary.BigEndian.AppendUint16(p, sdpAttrProtocolDescriptorList)
+    p = append(p, 0x09)
+    p = binary.BigEndian.AppendUint16(p, sdpAttrServiceName)
+    // ContinuationState
+    p = append(p, cont...)
+
+    pdu := []byte{sdpServiceSearchAttrReq}
+    pdu = binary.BigEndian.AppendUint16(pdu, tid)
+    pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(p)))
+    return append(pdu, p...)
+}
+
+// parseSearchAttrResponse returns this response's share of the AttributeLists and the
+// continuation state (empty when complete).
+func parseSearchAttrResponse(pdu []byte, tid uint16) (part, cont []byte, err error) {
+    if len(pdu) < 5 {
+        return nil, nil, errors.New("connmgr: sdp: short response")
+    }
+    if got := binary.BigEndian.Uint16(pdu[1:3]); got != tid {
+        return nil, nil, fmt.Errorf("connmgr: sdp: transaction id %d, want %d", got, tid)
+    }
+    params := pdu[5:]
+    if int(binary.BigEndian.Uint16(pdu[3:5])) != len(params) {
+        return nil, nil, errors.New("connmgr: sdp: bad parameter length")
+    }
+    switch pdu[0] {
+    case sdpServiceSearchAttrRsp:
+    case sdpErrorRsp:
+        if len(params) >= 2 {
+            return nil, nil, fmt.Errorf("connmgr: sdp: error response 0x%04x", binary.BigEndian.Uint16(params))
+        }
+        return nil, nil, errors.New("connmgr: sdp: error response")
+    default:
+        return nil, nil, fmt.Errorf("connmgr: sdp: unexpected PDU 0x%02x", pdu[0])
+    }
+    if len(params) < 2 {
+        return nil, nil, errors.New("connmgr: sdp: short response")
+    }
+    n := int(binary.BigEndian.Uint16(params))
+    if len(params) < 2+n+1 {
+        return nil, nil, errors.New("connmgr: sdp: truncated attribute lists")
+    }
+    part = params[2 : 2+n]
+    rest := params[2+n:]
+    cn := int(rest[0])
+    if len(rest) < 1+cn || cn > 16 {
+        return nil, nil, errors.New("connmgr: sdp: bad continuation state")
+    }
+    return part, rest[1 : 1+cn], nil
+}
+
+// sdpElem is a decoded SDP data element.
+type sdpElem struct {
+    typ   byte // 1 uint, 2 int, 3 UUID, 4 text, 5 bool, 6 sequence, 7 alternative, 8 URL
+    data  []byte
+    items []sdpElem // for sequences and alternatives
+}
+
+// parseSDPElem decodes one data element from b and returns the remainder.
+func parseSDPElem(b []byte) (sdpElem, []byte, error) {
+    if len(b) == 0 {
+        return sdpElem{}, nil, errors.New("connmgr: sdp: empty data element")
+    }
+    typ, sizeIdx := b[0]>>3, b[0]&0x07
+    b = b[1:]
+    var n int
+    switch {
+    case typ == 0:
+        n = 0
+    case sizeIdx <= 4:
+        n = 1 << sizeIdx
+    case sizeIdx == 5 && len(b) >= 1:
+        n, b = int(b[0]), b[1:]
+    case sizeIdx == 6 && len(b) >= 2:
+        n, b = int(binary.BigEndian.Uint16(b)), b[2:]
+    case sizeIdx == 7 && len(b) >= 4:
+        n, b = int(binary.BigEndian.Uint32(b)), b[4:]
+    default:
+        return sdpElem{}, nil, errors.New("connmgr: sdp: truncated data element header")
+    }
+    if len(b) < n {
+        return sdpElem{}, nil, errors.New("connmgr: sdp: truncated data element")
+    }
+    e := sdpElem{typ: typ, data: b[:n]}
+    if typ == 6 || typ == 7 {
+        for body := e.data; len(body) > 0; {
+            var item sdpElem
+            var err error
+            item, body, err = parseSDPElem(body)
+            if err != nil {
+                return sdpElem{}, nil, err
+            }
+            e.items = append(e.items, item)
+        }
+    }
+    return e, b[n:], nil
+}
+
+func (e sdpElem) uint() (uint64, bool) {
+    if e.typ != 1 || len(e.data) == 0 || len(e.data) > 8 {
+        return 0, false
+    }
+    var v uint64
+    for _, c := range e.data {
+        v = v<<8 | uint64(c)
+    }
+    return v, true
+}
+
+// uuid16 returns the 16-bit form of a UUID element (UUID32/128 on the Bluetooth base are reduced).
+func (e sdpElem) uuid16() (uint16, bool) {
+    if e.typ != 3 {
+        return 0, false
+    }
+    switch len(e.data) {
+    case 2:
+        return binary.BigEndian.Uint16(e.data), true
+    case 4:
+        if binary.BigEndian.Uint16(e.data) == 0 {
+            return binary.BigEndian.Uint16(e.data[2:]), true
+        }
+    case 16:
+        base, _ := parseUUID128(SPPUUID)
+        if binary.BigEndian.Uint16(e.data) == 0 && string(e.data[4:]) == string(base[4:]) {
+            return binary.BigEndian.Uint16(e.data[2:]), true
+        }
+    }
+    return 0, false
+}
+
+// pickSDPRecord extracts sdpInfo from the AttributeLists (DES of per-record attribute DES).
+func pickSDPRecord(lists []byte) (sdpInfo, error) {
+    if len(lists) == 0 {
+        return sdpInfo{}, errors.New("connmgr: sdp: no matching record")
+    }
+    top, _, err := parseSDPElem(lists)
+    if err != nil {
+        return sdpInfo{}, err
+    }
+    var best sdpInfo
+    for _, rec := range top.items {
+        var info sdpInfo
+        for i := 0; i+1 < len(rec.items); i += 2 {
+            id, _ := rec.items[i].uint()
+            val := rec.items[i+1]
+            switch id {
+            case sdpAttrProtocolDescriptorList:
+                info.Channel = rfcommChannel(val)
+            case sdpAttrServiceName:
+                if val.typ == 4 {
+                    info.ServiceName = strings.TrimRight(string(val.data), "\x00")
+                }
+            }
+        }
+        if info.Channel == 0 {
+            continue
+        }
+        if best.Channel == 0 || (best.ServiceName == "" && info.ServiceName != "") {
+            best = info
+        }
+    }
+    if best.Channel == 0 {
+        return sdpInfo{}, errors.New("connmgr: sdp: no RFCOMM record")
+    }
+    return best, nil
+}
+
+// rfcommChannel finds { UUID RFCOMM, uint8 channel } in a ProtocolDescriptorList.
+func rfcommChannel(pdl sdpElem) uint8 {
+    for _, proto := range pdl.items {
+        if len(proto.items) < 2 {
+            continue
+        }
+        if u, ok := proto.items[0].uuid16(); !ok || u != sdpUUIDRFCOMM {
+            continue
+        }
+        if ch, ok := proto.items[1].uint(); ok && ch <= 30 {
+            return uint8(ch)
+        }
+    }
+    return 0
+}
+
+// parseMAC converts "AA:BB:CC:DD:EE:FF" to display-order bytes (as unix.SockaddrL2 expects).
+func parseMAC(mac string) ([6]byte, error) {
+    var out [6]byte
+    parts := strings.Split(mac, ":")
+    if len(parts) != 6 {
+        return out, fmt.Errorf("connmgr: invalid MAC %q", mac)
+    }
+    for i, p := range parts {
+        v, err := strconv.ParseUint(p, 16, 8)
+        if err != nil || len(p) != 2 {
+            return out, fmt.Errorf("connmgr: invalid MAC %q", mac)
+        }
+        out[i] = byte(v)
+    }
+    return out, nil
+}
+
+// parseUUID128 parses the canonical 8-4-4-4-12 form.
+func parseUUID128(s string) ([16]byte, error) {
+    var out [16]byte
+    h := strings.ReplaceAll(s, "-", "")
+    if len(h) != 32 || strings.Count(s, "-") != 4 {
+        return out, fmt.Errorf("connmgr: invalid UUID %q", s)
+    }
+    if _, err := hex.Decode(out[:], []byte(h)); err != nil {
+        return out, fmt.Errorf("connmgr: invalid UUID %q", s)
+    }
+    return out, nil
+}
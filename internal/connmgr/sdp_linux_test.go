@@ -0,0 +1,216 @@
+//go:build linux
+
+package connmgr
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+)
+
+// Builders for canned SDP data elements.
+
+func des(items ...[]byte) []byte {
+    body := bytes.Join(items, nil)
+    return append([]byte{0x35, byte(len(body))}, body...)
+}
+
+func u8(v uint8) []byte    { return []byte{0x08, v} }
+func u16(v uint16) []byte  { return binary.BigEndian.AppendUint16([]byte{0x09}, v) }
+func uuid(v uint16) []byte { return binary.BigEndian.AppendUint16([]byte{0x19}, v) }
+func text(s string) []byte { return append([]byte{0x25, byte(len(s))}, s...) }
+
+// record is an attribute list with an optional RFCOMM channel (0 = L2CAP only) and name.
+func record(channel uint8, name string) []byte {
+    pdl := des(des(uuid(0x0100)))
+    if channel != 0 {
+        pdl = des(des(uuid(0x0100)), des(uuid(sdpUUIDRFCOMM), u8(channel)))
+    }
+    attrs := [][]byte{u16(sdpAttrProtocolDescriptorList), pdl}
+    if name != "" {
+        attrs = append(attrs, u16(sdpAttrServiceName), text(name))
+    }
+    return des(attrs...)
+}
+
+// response wraps AttributeLists bytes and a continuation state into a response PDU.
+func response(tid uint16, lists, cont []byte) []byte {
+    p := binary.BigEndian.AppendUint16(nil, uint16(len(lists)))
+    p = append(p, lists...)
+    p = append(p, byte(len(cont)))
+    p = append(p, cont...)
+    pdu := []byte{sdpServiceSearchAttrRsp}
+    pdu = binary.BigEndian.AppendUint16(pdu, tid)
+    pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(p)))
+    return append(pdu, p...)
+}
+
+func TestParseSDPElem(t *testing.T) {
+    tests := []struct {
+        name  string
+        in    []byte
+        typ   byte
+        items int
+        rest  int
+        ok    bool
+    }{
+        {"uint8", []byte{0x08, 7, 0xff}, 1, 0, 1, true},
+        {"nil", []byte{0x00}, 0, 0, 0, true},
+        {"uuid128", append([]byte{0x1c}, make([]byte, 16)...), 3, 0, 0, true},
+        {"nested sequence", des(des(u8(1), u8(2)), u16(3)), 6, 2, 0, true},
+        {"alternative", []byte{0x3d, 2, 0x08, 1}, 7, 1, 0, true},
+        {"16-bit length", append([]byte{0x36, 0, 2}, u8(1)...), 6, 1, 0, true},
+        {"32-bit length", append([]byte{0x37, 0, 0, 0, 2}, u8(1)...), 6, 1, 0, true},
+        {"empty", nil, 0, 0, 0, false},
+        {"truncated value", []byte{0x09, 1}, 0, 0, 0, false},
+        {"truncated 8-bit length", []byte{0x35}, 0, 0, 0, false},
+        {"truncated 16-bit length", []byte{0x36, 0}, 0, 0, 0, false},
+        {"truncated 32-bit length", []byte{0x37, 0, 0}, 0, 0, 0, false},
+        {"oversize sequence", []byte{0x35, 10, 0x08, 1}, 0, 0, 0, false},
+        {"oversize 32-bit length", []byte{0x37, 0xff, 0xff, 0xff, 0xff, 0x08, 1}, 0, 0, 0, false},
+        {"bad item in sequence", []byte{0x35, 2, 0x09, 1}, 0, 0, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, rest, err := parseSDPElem(tt.in)
+            if !tt.ok {
+                if err == nil {
+                    t.Fatal("no error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if e.typ != tt.typ || len(e.items) != tt.items || len(rest) != tt.rest {
+                t.Errorf("got type %d, %d items, %d bytes left; want %d, %d, %d", e.typ, len(e.items), len(rest), tt.typ, tt.items, tt.rest)
+            }
+        })
+    }
+}
+
+func TestRFCOMMChannel(t *testing.T) {
+    base, _ := parseUUID128(SPPUUID)
+    rfcomm128 := append([]byte{0x1c}, base[:]...)
+    rfcomm128[3], rfcomm128[4] = 0x00, sdpUUIDRFCOMM
+    tests := []struct {
+        name string
+        pdl  []byte
+        want uint8
+    }{
+        {"l2cap and rfcomm", des(des(uuid(0x0100)), des(uuid(sdpUUIDRFCOMM), u8(5))), 5},
+        {"uuid128 form", des(des(rfcomm128, u8(9))), 9},
+        {"uuid32 form", des(des([]byte{0x1a, 0, 0, 0, sdpUUIDRFCOMM}, u8(4))), 4},
+        {"l2cap only", des(des(uuid(0x0100), u16(0x1001))), 0},
+        {"rfcomm without channel", des(des(uuid(sdpUUIDRFCOMM))), 0},
+        {"channel out of range", des(des(uuid(sdpUUIDRFCOMM), u8(31))), 0},
+        {"channel not a uint", des(des(uuid(sdpUUIDRFCOMM), text("5"))), 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pdl, _, err := parseSDPElem(tt.pdl)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := rfcommChannel(pdl); got != tt.want {
+                t.Errorf("got %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestPickSDPRecord(t *testing.T) {
+    tests := []struct {
+        name  string
+        lists []byte
+        want  sdpInfo
+        ok    bool
+    }{
+        {"single", des(record(3, "Chat")), sdpInfo{"Chat", 3}, true},
+        {"name NUL-terminated", des(record(3, "Chat\x00")), sdpInfo{"Chat", 3}, true},
+        {"first of several", des(record(3, "A"), record(4, "B")), sdpInfo{"A", 3}, true},
+        {"prefers a named record", des(record(3, ""), record(4, "B")), sdpInfo{"B", 4}, true},
+        {"skips records without rfcomm", des(record(0, "L2CAP"), record(6, "")), sdpInfo{"", 6}, true},
+        {"no rfcomm protocol descriptor", des(record(0, "L2CAP")), sdpInfo{}, false},
+        {"no protocol descriptor list", des(des(u16(sdpAttrServiceName), text("Chat"))), sdpInfo{}, false},
+        {"no records", des(), sdpInfo{}, false},
+        {"empty", nil, sdpInfo{}, false},
+        {"truncated", des(record(3, "Chat"))[:10], sdpInfo{}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := pickSDPRecord(tt.lists)
+            if !tt.ok {
+                if err == nil {
+                    t.Fatalf("got %+v, want an error", got)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseSearchAttrResponse(t *testing.T) {
+    lists := des(record(3, "Chat"))
+    tests := []struct {
+        name       string
+        pdu        []byte
+        part, cont []byte
+        ok         bool
+    }{
+        {"complete", response(1, lists, nil), lists, nil, true},
+        {"continued", response(1, lists[:5], []byte{0xaa, 0xbb}), lists[:5], []byte{0xaa, 0xbb}, true},
+        {"wrong transaction id", response(2, lists, nil), nil, nil, false},
+        {"short", []byte{sdpServiceSearchAttrRsp, 0, 1, 0}, nil, nil, false},
+        {"error response", []byte{sdpErrorRsp, 0, 1, 0, 2, 0, 3}, nil, nil, false},
+        {"unexpected pdu", []byte{0x05, 0, 1, 0, 0}, nil, nil, false},
+        {"parameter length too long", append(response(1, lists, nil), 0), nil, nil, false},
+        {"lists longer than the pdu", func() []byte {
+            p := response(1, lists, nil)
+            p[6]++ // AttributeListsByteCount
+            return p
+        }(), nil, nil, false},
+        {"continuation state truncated", func() []byte {
+            p := response(1, lists, []byte{1, 2})
+            p[len(p)-3] = 5 // claims 5 bytes, carries 2
+            return p
+        }(), nil, nil, false},
+        {"continuation state over 16 bytes", response(1, lists, make([]byte, 17)), nil, nil, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            part, cont, err := parseSearchAttrResponse(tt.pdu, 1)
+            if !tt.ok {
+                if err == nil {
+                    t.Fatal("no error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(part, tt.part) || !bytes.Equal(cont, tt.cont) {
+                t.Errorf("got part %x cont %x, want %x %x", part, cont, tt.part, tt.cont)
+            }
+        })
+    }
+
+    // A record split across two responses parses once the parts are joined.
+    first, cont, err := parseSearchAttrResponse(response(1, lists[:7], []byte{1}), 1)
+    if err != nil || len(cont) == 0 {
+        t.Fatalf("first part: cont %x, %v", cont, err)
+    }
+    second, cont, err := parseSearchAttrResponse(response(2, lists[7:], nil), 2)
+    if err != nil || len(cont) != 0 {
+        t.Fatalf("second part: cont %x, %v", cont, err)
+    }
+    if info, err := pickSDPRecord(append(first, second...)); err != nil || info != (sdpInfo{"Chat", 3}) {
+        t.Errorf("joined parts: %+v, %v", info, err)
+    }
+}
@@ -0,0 +1,173 @@
+//go:build linux
+
+package connmgr_test
+
+import (
+    "context"
+    "errors"
+    "syscall"
+    "testing"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+
+    "bluetooth-chat/internal/connmgr"
+    "bluetooth-chat/internal/fakebluez"
+)
+
+func TestPeerLimitCountsConnections(t *testing.T) {
+    f := newFixture(t)
+    a := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01"})
+    b := f.addDevice(t, fakebluez.Device{Address: "BB:BB:BB:BB:BB:02"})
+    f.startServer(t, connmgr.ServerOptions{MaxPeers: 2})
+
+    for i := 0; i < 2; i++ {
+        if _, err := f.bz.Connect(a, connmgr.SPPUUID); err != nil {
+            t.Fatalf("connection %d from A: %v", i+1, err)
+        }
+    }
+    fd1, devA := f.accept(t)
+    fd2, _ := f.accept(t)
+    defer syscall.Close(fd2)
+    if _, err := f.bz.Connect(b, connmgr.SPPUUID); err == nil {
+        t.Fatal("B accepted while A holds both slots")
+    }
+
+    // Releasing one of A's connections frees exactly one slot.
+    syscall.Close(fd1)
+    if err := f.m.Release(devA); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := f.bz.Connect(b, connmgr.SPPUUID); err != nil {
+        t.Fatalf("B after release: %v", err)
+    }
+    fd3, devB := f.accept(t)
+    defer syscall.Close(fd3)
+    if devB.Path != string(b) {
+        t.Fatalf("accepted %s, want %s", devB.Path, b)
+    }
+    if _, err := f.bz.Connect(b, connmgr.SPPUUID); err == nil {
+        t.Fatal("third connection accepted with MaxPeers 2")
+    }
+
+    if err := f.m.Release(devA); err != nil {
+        t.Fatal(err)
+    }
+    if err := f.m.Release(devA); err == nil {
+        t.Fatal("Release freed more slots than A held")
+    }
+}
+
+func TestAccept(t *testing.T) {
+    f := newFixture(t)
+    p := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Name: "phone", Paired: true, Trusted: true, Version: 0x0102})
+    f.startServer(t, connmgr.ServerOptions{ServiceName: "chat"})
+    var server bool
+    for _, prof := range f.bz.Profiles() {
+        if prof.Role() == "server" && prof.UUID == connmgr.SPPUUID {
+            server = prof.Options["Name"].Value() == "chat"
+        }
+    }
+    if !server {
+        t.Fatalf("no server profile named chat in %+v", f.bz.Profiles())
+    }
+
+    remote, err := f.bz.Connect(p, connmgr.SPPUUID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer remote.Close()
+    fd, dev := f.accept(t)
+    defer syscall.Close(fd)
+    want := connmgr.Device{
+        Path: string(p), MAC: "AA:AA:AA:AA:AA:01", Name: "phone", Alias: "phone",
+        UUID: connmgr.SPPUUID, Adapter: string(f.hci0), Paired: true, Trusted: true, Version: 0x0102,
+    }
+    dev.Channel, dev.Connected = 0, false // depend on the socket and the fake
+    if dev != want {
+        t.Errorf("accepted %+v\nwant     %+v", dev, want)
+    }
+    if _, err := remote.Write([]byte("ping")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 4)
+    if _, err := syscall.Read(fd, buf); err != nil || string(buf) != "ping" {
+        t.Fatalf("read %q, %v", buf, err)
+    }
+}
+
+func TestAcceptCancel(t *testing.T) {
+    f := newFixture(t)
+    f.startServer(t, connmgr.ServerOptions{})
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if _, _, err := f.m.Accept(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want context.DeadlineExceeded", err)
+    }
+}
+
+func TestServerUsage(t *testing.T) {
+    f := newFixture(t)
+    ctx := context.Background()
+    if _, _, err := f.m.Accept(ctx); err == nil {
+        t.Error("Accept before StartServer: no error")
+    }
+    for _, opts := range []connmgr.ServerOptions{
+        {},                                  // no ServiceName
+        {ServiceName: "x", MaxPeers: -1},    // negative limit
+        {ServiceName: "x", Channel: 31},     // channel out of range
+        {ServiceName: "x", PSM: 0x1000},     // even PSM
+        {ServiceName: "x", UUID: "1101"},    // not a 128-bit UUID
+        {ServiceName: "x", Adapter: "hci9"}, // unknown adapter
+    } {
+        if err := f.m.StartServer(ctx, opts); err == nil {
+            t.Fatalf("%+v: no error", opts)
+        }
+    }
+    if n := len(f.bz.Profiles()); n != 0 {
+        t.Fatalf("%d profiles registered by failed calls", n)
+    }
+    f.startServer(t, connmgr.ServerOptions{})
+    if err := f.m.StartServer(ctx, connmgr.ServerOptions{ServiceName: "again"}); !errors.Is(err, connmgr.ErrAlreadyUsed) {
+        t.Errorf("second StartServer: got %v, want ErrAlreadyUsed", err)
+    }
+    if _, err := f.m.Connect(ctx, connmgr.Device{Path: string(f.hci0) + "/dev_AA_AA_AA_AA_AA_01"}); !errors.Is(err, connmgr.ErrRoleConflict) {
+        t.Errorf("Connect on a server: got %v, want ErrRoleConflict", err)
+    }
+    if err := f.m.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if n := len(f.bz.Profiles()); n != 0 {
+        t.Errorf("%d profiles left after Close", n)
+    }
+    if _, _, err := f.m.Accept(ctx); !errors.Is(err, connmgr.ErrClosed) {
+        t.Errorf("Accept after Close: got %v, want ErrClosed", err)
+    }
+}
+
+func TestServerPolicy(t *testing.T) {
+    f := newFixture(t)
+    denied := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:01", Paired: true, Trusted: true})
+    untrusted := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:02", Paired: true})
+    refused := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:03", Paired: true, Trusted: true})
+    ok := f.addDevice(t, fakebluez.Device{Address: "AA:AA:AA:AA:AA:04", Paired: true, Trusted: true})
+    f.startServer(t, connmgr.ServerOptions{
+        DenyMACs:    []string{"aa:aa:aa:aa:aa:01"},
+        TrustedOnly: true,
+        Authorize:   func(d connmgr.Device) bool { return d.MAC != "AA:AA:AA:AA:AA:03" },
+    })
+    for _, p := range []dbus.ObjectPath{denied, untrusted, refused} {
+        if _, err := f.bz.Connect(p, connmgr.SPPUUID); err == nil {
+            t.Errorf("%s accepted", p)
+        }
+    }
+    // Rejected connections took no slot (MaxPeers 1).
+    if _, err := f.bz.Connect(ok, connmgr.SPPUUID); err != nil {
+        t.Fatal(err)
+    }
+    fd, dev := f.accept(t)
+    defer syscall.Close(fd)
+    if dev.Path != string(ok) {
+        t.Errorf("accepted %s, want %s", dev.Path, ok)
+    }
+}
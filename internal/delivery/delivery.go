@@ -0,0 +1,160 @@
+// Package delivery gives chat messages an identity and tracks whether the peer received them.
+//
+// With the receipts capability negotiated in the handshake (it requires binary frames), each
+// text frame carries a Message envelope: a sender-chosen unique ID, the time it was written
+// and the text. The receiver answers with a Delivered receipt as soon as it decoded the
+// message and, optionally, a Read receipt once it was shown; receipts travel in control
+// frames. The sender registers every message with a Tracker, which reports the state changes
+// (sent, delivered, read) and marks a message Failed if no delivery receipt arrives in time.
+//
+// Payload layouts (integers big-endian):
+//
+//    message:  idlen(1) id(idlen) time(8, Unix ms) text
+//    receipt:  kind(1) idlen(1) id(idlen) time(8, Unix ms)
+//
+// The first byte of a control payload is the Kind, so other control messages can be added
+// with kinds of their own.
+package delivery
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// Capability is the handshake capability announcing message envelopes and receipts.
+const Capability = "receipts"
+
+// MaxIDLength is the longest message ID accepted.
+const MaxIDLength = 64
+
+// MessageOverhead is the envelope size of a message with an ID from NewID, for checking a
+// text against the peer's frame limit.
+const MessageOverhead = 1 + 2*idBytes + 8
+
+const idBytes = 16
+
+// ErrMalformed is returned when a payload does not follow the layouts above.
+var ErrMalformed = errors.New("delivery: malformed payload")
+
+// Message is one chat message.
+type Message struct {
+    ID   string    // unique per message, e.g. from NewID
+    Time time.Time // when the sender wrote it
+    Text string
+}
+
+// NewID returns a random 128-bit ID in hex, unique across sessions and peers.
+func NewID() string {
+    var b [idBytes]byte
+    rand.Read(b[:])
+    return hex.EncodeToString(b[:])
+}
+
+// NewMessage returns a message with a fresh ID, stamped with the current time.
+func NewMessage(text string) Message {
+    return Message{ID: NewID(), Time: time.Now(), Text: text}
+}
+
+// AppendMessage appends the envelope of m to dst.
+func AppendMessage(dst []byte, m Message) ([]byte, error) {
+    if err := checkID(m.ID); err != nil {
+        return dst, err
+    }
+    dst = append(dst, byte(len(m.ID)))
+    dst = append(dst, m.ID...)
+    dst = binary.BigEndian.AppendUint64(dst, uint64(m.Time.UnixMilli()))
+    return append(dst, m.Text...), nil
+}
+
+// ParseMessage decodes a message envelope.
+func ParseMessage(p []byte) (Message, error) {
+    id, rest, err := parseID(p)
+    if err != nil {
+        return Message{}, err
+    }
+    if len(rest) < 8 {
+        return Message{}, fmt.Errorf("%w: short message header", ErrMalformed)
+    }
+    return Message{ID: id, Time: unixMilli(rest), Text: string(rest[8:])}, nil
+}
+
+// Kind is the type of a receipt.
+type Kind uint8
+
+const (
+    Delivered Kind = 1 // the peer received and decoded the message
+    Read      Kind = 2 // the peer showed the message to its user
+)
+
+func (k Kind) String() string {
+    switch k {
+    case Delivered:
+        return "delivered"
+    case Read:
+        return "read"
+    }
+    return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Receipt acknowledges the message with the given ID.
+type Receipt struct {
+    Kind Kind
+    ID   string
+    Time time.Time // when the receiver delivered or showed the message
+}
+
+// AppendReceipt appends the encoding of r to dst.
+func AppendReceipt(dst []byte, r Receipt) ([]byte, error) {
+    if r.Kind != Delivered && r.Kind != Read {
+        return dst, fmt.Errorf("delivery: invalid receipt %s", r.Kind)
+    }
+    if err := checkID(r.ID); err != nil {
+        return dst, err
+    }
+    dst = append(dst, byte(r.Kind), byte(len(r.ID)))
+    dst = append(dst, r.ID...)
+    return binary.BigEndian.AppendUint64(dst, uint64(r.Time.UnixMilli())), nil
+}
+
+// ParseReceipt decodes a receipt. A payload of an unknown kind yields ErrMalformed.
+func ParseReceipt(p []byte) (Receipt, error) {
+    if len(p) == 0 {
+        return Receipt{}, fmt.Errorf("%w: empty control payload", ErrMalformed)
+    }
+    r := Receipt{Kind: Kind(p[0])}
+    if r.Kind != Delivered && r.Kind != Read {
+        return Receipt{}, fmt.Errorf("%w: unknown control %s", ErrMalformed, r.Kind)
+    }
+    id, rest, err := parseID(p[1:])
+    if err != nil {
+        return Receipt{}, err
+    }
+    if len(rest) != 8 {
+        return Receipt{}, fmt.Errorf("%w: receipt length", ErrMalformed)
+    }
+    r.ID, r.Time = id, unixMilli(rest)
+    return r, nil
+}
+
+func checkID(id string) error {
+    if id == "" || len(id) > MaxIDLength {
+        return fmt.Errorf("delivery: message ID must be 1..%d bytes", MaxIDLength)
+    }
+    return nil
+}
+
+func parseID(p []byte) (string, []byte, error) {
+    if len(p) == 0 || p[0] == 0 || int(p[0]) > MaxIDLength || len(p) < 1+int(p[0]) {
+        return "", nil, fmt.Errorf("%w: bad message ID", ErrMalformed)
+    }
+    n := 1 + int(p[0])
+    return string(p[1:n]), p[n:], nil
+}
+
+func unixMilli(b []byte) time.Time {
+    return time.UnixMilli(int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,179 @@
+package delivery
+
+import (
+    "slices"
+    "sync"
+    "time"
+)
+
+// DefaultTimeout is the delivery timeout used when NewTracker is given 0.
+const DefaultTimeout = 30 * time.Second
+
+// historySize bounds the finished messages a Tracker remembers to match late receipts.
+const historySize = 256
+
+// State is the delivery state of a sent message.
+type State int
+
+const (
+    StateSent      State = iota // being written or written to the connection, no receipt yet
+    StateDelivered              // the peer acknowledged receiving it
+    StateRead                   // the peer showed it to its user
+    StateFailed                 // no delivery receipt within the timeout
+)
+
+func (s State) String() string {
+    switch s {
+    case StateSent:
+        return "sent"
+    case StateDelivered:
+        return "delivered"
+    case StateRead:
+        return "read"
+    case StateFailed:
+        return "failed"
+    }
+    return "unknown"
+}
+
+// Update reports a state change of a tracked message.
+type Update struct {
+    Message
+    State State
+    At    time.Time // receipt time from the peer, or when the timeout expired
+}
+
+type entry struct {
+    msg   Message
+    state State
+    timer *time.Timer
+}
+
+// Tracker follows the messages sent on one connection until they are delivered or time out.
+// It is safe for concurrent use.
+type Tracker struct {
+    timeout time.Duration
+    notify  func(Update)
+
+    mu      sync.Mutex
+    entries map[string]*entry
+    done    []string // IDs of finished entries, oldest first
+    closed  bool
+}
+
+// NewTracker returns a Tracker failing messages not delivered within timeout (DefaultTimeout
+// if 0). notify is called for every state change, without locks held; it may run on a timer
+// goroutine.
+func NewTracker(timeout time.Duration, notify func(Update)) *Tracker {
+    if timeout <= 0 {
+        timeout = DefaultTimeout
+    }
+    return &Tracker{timeout: timeout, notify: notify, entries: make(map[string]*entry)}
+}
+
+// Sent registers m and starts its delivery timeout. Call it before writing m, so a receipt
+// cannot arrive for a message the Tracker does not know yet; if the write fails, call Unsent.
+func (t *Tracker) Sent(m Message) {
+    t.mu.Lock()
+    if t.closed {
+        t.mu.Unlock()
+        return
+    }
+    e := &entry{msg: m, state: StateSent}
+    t.entries[m.ID] = e
+    e.timer = time.AfterFunc(t.timeout, func() { t.expire(e) })
+    t.mu.Unlock()
+    t.notify(Update{Message: m, State: StateSent, At: m.Time})
+}
+
+// Unsent withdraws the message id registered with Sent whose write failed. Its timeout is
+// stopped, no further update is reported for it and Close does not return it.
+func (t *Tracker) Unsent(id string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if e, ok := t.entries[id]; ok && e.state == StateSent {
+        e.timer.Stop()
+        delete(t.entries, id)
+    }
+}
+
+func (t *Tracker) expire(e *entry) {
+    t.mu.Lock()
+    if t.closed || e.state != StateSent || t.entries[e.msg.ID] != e { // finished or withdrawn
+        t.mu.Unlock()
+        return
+    }
+    e.state = StateFailed
+    t.finishLocked(e)
+    t.mu.Unlock()
+    t.notify(Update{Message: e.msg, State: StateFailed, At: time.Now()})
+}
+
+// Receipt applies r to the message it acknowledges. A receipt arriving after the timeout still
+// marks the message delivered. It reports false for unknown IDs and receipts that do not
+// advance the state (duplicates, or Delivered after Read).
+func (t *Tracker) Receipt(r Receipt) bool {
+    var next State
+    switch r.Kind {
+    case Delivered:
+        next = StateDelivered
+    case Read:
+        next = StateRead
+    default:
+        return false
+    }
+    t.mu.Lock()
+    e, ok := t.entries[r.ID]
+    if t.closed || !ok || (e.state >= next && e.state != StateFailed) {
+        t.mu.Unlock()
+        return false
+    }
+    if e.state == StateSent {
+        e.timer.Stop()
+        t.finishLocked(e)
+    }
+    e.state = next
+    t.mu.Unlock()
+    t.notify(Update{Message: e.msg, State: next, At: r.Time})
+    return true
+}
+
+// finishLocked moves e to the bounded history of finished messages.
+func (t *Tracker) finishLocked(e *entry) {
+    t.done = append(t.done, e.msg.ID)
+    if len(t.done) > historySize {
+        delete(t.entries, t.done[0])
+        t.done = t.done[1:]
+    }
+}
+
+// State returns the current state of the message with the given ID, if it is still known.
+func (t *Tracker) State(id string) (State, bool) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    e, ok := t.entries[id]
+    if !ok {
+        return 0, false
+    }
+    return e.state, true
+}
+
+// Close stops all timeouts and returns the messages not confirmed as delivered (still
+// waiting or failed), oldest first. No updates are reported after Close.
+func (t *Tracker) Close() []Message {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed {
+        return nil
+    }
+    t.closed = true
+    var out []Message
+    for _, e := range t.entries {
+        if e.state == StateSent || e.state == StateFailed {
+            e.timer.Stop()
+            out = append(out, e.msg)
+        }
+    }
+    slices.SortFunc(out, func(a, b Message) int { return a.Time.Compare(b.Time) })
+    return out
+}
@@ -0,0 +1,141 @@
+package delivery
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+// recorder collects the updates of a Tracker.
+type recorder struct {
+    mu      sync.Mutex
+    updates []Update
+    ch      chan Update
+}
+
+func newRecorder() *recorder { return &recorder{ch: make(chan Update, 16)} }
+
+func (r *recorder) notify(u Update) {
+    r.mu.Lock()
+    r.updates = append(r.updates, u)
+    r.mu.Unlock()
+    r.ch <- u
+}
+
+func (r *recorder) next(t *testing.T) Update {
+    t.Helper()
+    select {
+    case u := <-r.ch:
+        return u
+    case <-time.After(5 * time.Second):
+        t.Fatal("no update")
+        return Update{}
+    }
+}
+
+func TestTrackerReceipts(t *testing.T) {
+    rec := newRecorder()
+    tr := NewTracker(time.Hour, rec.notify)
+    m := NewMessage("hi")
+    tr.Sent(m)
+    if u := rec.next(t); u.State != StateSent || u.ID != m.ID {
+        t.Fatalf("got %+v, want sent", u)
+    }
+    if !tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()}) {
+        t.Fatal("delivered receipt not applied")
+    }
+    if u := rec.next(t); u.State != StateDelivered {
+        t.Fatalf("got %s, want delivered", u.State)
+    }
+    if tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()}) {
+        t.Error("duplicate receipt applied")
+    }
+    if !tr.Receipt(Receipt{Kind: Read, ID: m.ID, Time: time.Now()}) {
+        t.Fatal("read receipt not applied")
+    }
+    if u := rec.next(t); u.State != StateRead {
+        t.Fatalf("got %s, want read", u.State)
+    }
+    if tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()}) {
+        t.Error("delivered applied after read")
+    }
+    if tr.Receipt(Receipt{Kind: Delivered, ID: NewID(), Time: time.Now()}) {
+        t.Error("receipt for an unknown message applied")
+    }
+    if pending := tr.Close(); len(pending) != 0 {
+        t.Errorf("Close returned %d confirmed messages", len(pending))
+    }
+}
+
+func TestTrackerTimeout(t *testing.T) {
+    rec := newRecorder()
+    tr := NewTracker(10*time.Millisecond, rec.notify)
+    m := NewMessage("hi")
+    tr.Sent(m)
+    rec.next(t) // sent
+    if u := rec.next(t); u.State != StateFailed {
+        t.Fatalf("got %s, want failed", u.State)
+    }
+    // A late receipt still counts.
+    if !tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()}) {
+        t.Fatal("late receipt not applied")
+    }
+    if st, _ := tr.State(m.ID); st != StateDelivered {
+        t.Fatalf("state %s, want delivered", st)
+    }
+}
+
+func TestTrackerReceiptBeforeWriteReturns(t *testing.T) {
+    // The sender registers the message before writing it; the peer's receipt is processed
+    // while the write is still in progress and must not be lost or overtaken by a timeout.
+    rec := newRecorder()
+    tr := NewTracker(50*time.Millisecond, rec.notify)
+    m := NewMessage("fast")
+    tr.Sent(m)
+    tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()})
+    time.Sleep(100 * time.Millisecond)
+    if st, _ := tr.State(m.ID); st != StateDelivered {
+        t.Fatalf("state %s, want delivered", st)
+    }
+    if pending := tr.Close(); len(pending) != 0 {
+        t.Fatalf("Close returned %d messages, want none", len(pending))
+    }
+}
+
+func TestTrackerUnsent(t *testing.T) {
+    rec := newRecorder()
+    tr := NewTracker(10*time.Millisecond, rec.notify)
+    m := NewMessage("lost")
+    tr.Sent(m)
+    rec.next(t) // sent
+    tr.Unsent(m.ID)
+    time.Sleep(30 * time.Millisecond)
+    rec.mu.Lock()
+    n := len(rec.updates)
+    rec.mu.Unlock()
+    if n != 1 {
+        t.Errorf("%d updates after Unsent, want only sent", n)
+    }
+    if tr.Receipt(Receipt{Kind: Delivered, ID: m.ID, Time: time.Now()}) {
+        t.Error("receipt applied to a withdrawn message")
+    }
+    if pending := tr.Close(); len(pending) != 0 {
+        t.Errorf("Close returned %d messages, want none", len(pending))
+    }
+}
+
+func TestTrackerClose(t *testing.T) {
+    rec := newRecorder()
+    tr := NewTracker(time.Hour, rec.notify)
+    a, b := NewMessage("a"), NewMessage("b")
+    b.Time = a.Time.Add(time.Second)
+    tr.Sent(b)
+    tr.Sent(a)
+    pending := tr.Close()
+    if len(pending) != 2 || pending[0].ID != a.ID || pending[1].ID != b.ID {
+        t.Fatalf("Close returned %v, want a, b", pending)
+    }
+    if tr.Receipt(Receipt{Kind: Delivered, ID: a.ID, Time: time.Now()}) {
+        t.Error("receipt applied after Close")
+    }
+}
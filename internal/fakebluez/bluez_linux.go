@@ -0,0 +1,750 @@
+//go:build linux
+
+package fakebluez
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+    "golang.org/x/sys/unix"
+)
+
+const (
+    // Service is the bus name owned by the fake.
+    Service = "org.bluez"
+
+    profileIface        = "org.bluez.Profile1"
+    profileManagerIface = "org.bluez.ProfileManager1"
+    agentIface          = "org.bluez.Agent1"
+    agentManagerIface   = "org.bluez.AgentManager1"
+    adapterIface        = "org.bluez.Adapter1"
+    deviceIface         = "org.bluez.Device1"
+    objManagerIface     = "org.freedesktop.DBus.ObjectManager"
+    propsIface          = "org.freedesktop.DBus.Properties"
+
+    managerPath = dbus.ObjectPath("/org/bluez")
+)
+
+// writable lists the properties that Properties.Set accepts, per interface.
+var writable = map[string]map[string]bool{
+    adapterIface: {"Alias": true, "Powered": true, "Discoverable": true, "DiscoverableTimeout": true, "Pairable": true},
+    deviceIface:  {"Alias": true, "Trusted": true, "Blocked": true},
+}
+
+// BlueZ is the fake bluetoothd. All methods are safe for concurrent use.
+type BlueZ struct {
+    conn  *dbus.Conn
+    links chan Link
+
+    mu       sync.Mutex
+    closed   bool
+    objects  map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+    devices  map[dbus.ObjectPath]*devState
+    profiles []Profile
+    agents   []Agent
+    filters  map[dbus.ObjectPath]map[string]dbus.Variant // discovery filter per adapter
+}
+
+// devState holds the behaviour of one device and the test-side ends of its connections.
+type devState struct {
+    spec   Device
+    cancel chan struct{} // non-nil while Pair is pending
+    peers  []*os.File
+}
+
+// New exports the BlueZ manager objects on conn and takes the org.bluez name.
+// conn stays owned by the caller; Close releases the name and objects but not conn.
+func New(conn *dbus.Conn) (*BlueZ, error) {
+    b := &BlueZ{
+        conn:    conn,
+        links:   make(chan Link, 16),
+        objects: make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant),
+        devices: make(map[dbus.ObjectPath]*devState),
+        filters: make(map[dbus.ObjectPath]map[string]dbus.Variant),
+    }
+    if err := conn.Export(objectManager{b}, "/", objManagerIface); err != nil {
+        return nil, fmt.Errorf("fakebluez: export ObjectManager: %w", err)
+    }
+    if err := conn.Export(profileManager{b}, managerPath, profileManagerIface); err != nil {
+        return nil, fmt.Errorf("fakebluez: export ProfileManager1: %w", err)
+    }
+    if err := conn.Export(agentManager{b}, managerPath, agentManagerIface); err != nil {
+        return nil, fmt.Errorf("fakebluez: export AgentManager1: %w", err)
+    }
+    reply, err := conn.RequestName(Service, dbus.NameFlagDoNotQueue)
+    if err != nil {
+        return nil, fmt.Errorf("fakebluez: RequestName: %w", err)
+    }
+    if reply != dbus.RequestNameReplyPrimaryOwner {
+        return nil, fmt.Errorf("fakebluez: %s already owned", Service)
+    }
+    return b, nil
+}
+
+// Conn returns the connection the fake is exported on, e.g. to emit extra signals.
+func (b *BlueZ) Conn() *dbus.Conn { return b.conn }
+
+// Close releases org.bluez, unexports all objects and closes the test-side ends of open links.
+// Clients see the name vanish as if bluetoothd had stopped.
+func (b *BlueZ) Close() error {
+    b.mu.Lock()
+    if b.closed {
+        b.mu.Unlock()
+        return nil
+    }
+    b.closed = true
+    paths := make([]dbus.ObjectPath, 0, len(b.objects))
+    for p := range b.objects {
+        paths = append(paths, p)
+    }
+    var peers []*os.File
+    for _, st := range b.devices {
+        peers = append(peers, st.peers...)
+        st.peers = nil
+    }
+    b.mu.Unlock()
+
+    for _, f := range peers {
+        _ = f.Close()
+    }
+    for _, p := range paths {
+        _ = b.conn.Export(nil, p, adapterIface)
+        _ = b.conn.Export(nil, p, deviceIface)
+        _ = b.conn.Export(nil, p, propsIface)
+    }
+    _ = b.conn.Export(nil, "/", objManagerIface)
+    _ = b.conn.Export(nil, managerPath, profileManagerIface)
+    _ = b.conn.Export(nil, managerPath, agentManagerIface)
+    _, err := b.conn.ReleaseName(Service)
+    return err
+}
+
+// AddAdapter adds a powered, pairable adapter at /org/bluez/<name>.
+func (b *BlueZ) AddAdapter(name, address string) (dbus.ObjectPath, error) {
+    path := managerPath + dbus.ObjectPath("/"+name)
+    props := map[string]dbus.Variant{
+        "Address":             dbus.MakeVariant(address),
+        "Name":                dbus.MakeVariant(name),
+        "Alias":               dbus.MakeVariant(name),
+        "Powered":             dbus.MakeVariant(true),
+        "Discoverable":        dbus.MakeVariant(false),
+        "DiscoverableTimeout": dbus.MakeVariant(uint32(180)),
+        "Pairable":            dbus.MakeVariant(true),
+        "Discovering":         dbus.MakeVariant(false),
+        "UUIDs":               dbus.MakeVariant([]string{}),
+    }
+    if err := b.addObject(path, adapterIface, props, &adapter{b: b, path: path}); err != nil {
+        return "", err
+    }
+    return path, nil
+}
+
+// AddDevice adds a device below adapter and announces it with InterfacesAdded.
+func (b *BlueZ) AddDevice(adapter dbus.ObjectPath, d Device) (dbus.ObjectPath, error) {
+    if d.Address == "" {
+        return "", errors.New("fakebluez: device Address required")
+    }
+    b.mu.Lock()
+    _, ok := b.objects[adapter][adapterIface]
+    b.mu.Unlock()
+    if !ok {
+        return "", fmt.Errorf("fakebluez: no adapter %s", adapter)
+    }
+    path := adapter + dbus.ObjectPath("/dev_"+strings.ReplaceAll(strings.ToUpper(d.Address), ":", "_"))
+    alias := d.Alias
+    if alias == "" {
+        alias = d.Name
+    }
+    if alias == "" {
+        alias = d.Address
+    }
+    uuids := d.UUIDs
+    if uuids == nil {
+        uuids = []string{}
+    }
+    props := map[string]dbus.Variant{
+        "Address":   dbus.MakeVariant(strings.ToUpper(d.Address)),
+        "Alias":     dbus.MakeVariant(alias),
+        "Adapter":   dbus.MakeVariant(adapter),
+        "UUIDs":     dbus.MakeVariant(uuids),
+        "Paired":    dbus.MakeVariant(d.Paired),
+        "Trusted":   dbus.MakeVariant(d.Trusted),
+        "Blocked":   dbus.MakeVariant(d.Blocked),
+        "Connected": dbus.MakeVariant(d.Connected),
+    }
+    if d.Name != "" {
+        props["Name"] = dbus.MakeVariant(d.Name)
+    }
+    if d.RSSI != 0 {
+        props["RSSI"] = dbus.MakeVariant(d.RSSI)
+    }
+    if d.Class != 0 {
+        props["Class"] = dbus.MakeVariant(d.Class)
+    }
+    if d.Icon != "" {
+        props["Icon"] = dbus.MakeVariant(d.Icon)
+    }
+    b.mu.Lock()
+    b.devices[path] = &devState{spec: d}
+    b.mu.Unlock()
+    if err := b.addObject(path, deviceIface, props, &device{b: b, path: path}); err != nil {
+        b.mu.Lock()
+        delete(b.devices, path)
+        b.mu.Unlock()
+        return "", err
+    }
+    return path, nil
+}
+
+// RemoveDevice removes a device (as Adapter1.RemoveDevice does), closing its open links.
+func (b *BlueZ) RemoveDevice(path dbus.ObjectPath) error {
+    b.mu.Lock()
+    st, ok := b.devices[path]
+    if !ok {
+        b.mu.Unlock()
+        return fmt.Errorf("fakebluez: no device %s", path)
+    }
+    delete(b.devices, path)
+    delete(b.objects, path)
+    peers := st.peers
+    st.peers = nil
+    b.mu.Unlock()
+
+    for _, f := range peers {
+        _ = f.Close()
+    }
+    _ = b.conn.Export(nil, path, deviceIface)
+    _ = b.conn.Export(nil, path, propsIface)
+    return b.conn.Emit("/", objManagerIface+".InterfacesRemoved", path, []string{deviceIface, propsIface})
+}
+
+// SetProperty changes a property and emits PropertiesChanged, e.g. to simulate an RSSI update
+// or UUIDs resolved after discovery. The property need not exist yet.
+func (b *BlueZ) SetProperty(path dbus.ObjectPath, iface, name string, value interface{}) error {
+    return b.setProps(path, iface, map[string]interface{}{name: value})
+}
+
+// Property returns the current value of a property.
+func (b *BlueZ) Property(path dbus.ObjectPath, iface, name string) (interface{}, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    v, ok := b.objects[path][iface][name]
+    if !ok {
+        return nil, false
+    }
+    return v.Value(), true
+}
+
+// Profiles returns the currently registered profiles in registration order.
+func (b *BlueZ) Profiles() []Profile {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return append([]Profile(nil), b.profiles...)
+}
+
+// Agents returns the currently registered agents in registration order.
+func (b *BlueZ) Agents() []Agent {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return append([]Agent(nil), b.agents...)
+}
+
+// DiscoveryFilter returns the filter last set on adapter with SetDiscoveryFilter
+// (nil if none or cleared).
+func (b *BlueZ) DiscoveryFilter(adapter dbus.ObjectPath) map[string]dbus.Variant {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.filters[adapter]
+}
+
+// Links delivers the test-side ends of connections made by Device1.ConnectProfile/Connect.
+// Up to 16 links are buffered; further ones are closed if nobody receives them.
+func (b *BlueZ) Links() <-chan Link { return b.links }
+
+// Connect simulates dev connecting to the local server profile for uuid (Role "server" or
+// unset). The peer's NewConnection receives one end of a socketpair; the other is returned.
+func (b *BlueZ) Connect(dev dbus.ObjectPath, uuid string) (*os.File, error) {
+    p, ok := b.findProfile(uuid, "server")
+    if !ok {
+        return nil, fmt.Errorf("fakebluez: no server profile for %s", uuid)
+    }
+    f, err := b.deliver(dev, p)
+    if err != nil {
+        return nil, err
+    }
+    _ = b.setProps(dev, deviceIface, map[string]interface{}{"Connected": true})
+    return f, nil
+}
+
+// Disconnect simulates link loss: all test-side ends for dev are closed and
+// Connected becomes false.
+func (b *BlueZ) Disconnect(dev dbus.ObjectPath) error {
+    b.mu.Lock()
+    st, ok := b.devices[dev]
+    if !ok {
+        b.mu.Unlock()
+        return fmt.Errorf("fakebluez: no device %s", dev)
+    }
+    peers := st.peers
+    st.peers = nil
+    b.mu.Unlock()
+
+    for _, f := range peers {
+        _ = f.Close()
+    }
+    return b.setProps(dev, deviceIface, map[string]interface{}{"Connected": false})
+}
+
+// RequestDisconnection calls Profile1.RequestDisconnection for dev on every profile
+// registered for uuid, as BlueZ does before tearing a link down.
+func (b *BlueZ) RequestDisconnection(dev dbus.ObjectPath, uuid string) error {
+    var errs []error
+    for _, p := range b.Profiles() {
+        if strings.EqualFold(p.UUID, uuid) {
+            errs = append(errs, b.conn.Object(p.Owner, p.Path).Call(profileIface+".RequestDisconnection", 0, dev).Err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// ReleaseProfiles unregisters all profiles and calls Profile1.Release on each, as bluetoothd
+// does when it shuts down.
+func (b *BlueZ) ReleaseProfiles() error {
+    b.mu.Lock()
+    profiles := b.profiles
+    b.profiles = nil
+    b.mu.Unlock()
+    var errs []error
+    for _, p := range profiles {
+        errs = append(errs, b.conn.Object(p.Owner, p.Path).Call(profileIface+".Release", 0).Err)
+    }
+    return errors.Join(errs...)
+}
+
+// findProfile returns the most recently registered profile for uuid whose Role is role or unset.
+func (b *BlueZ) findProfile(uuid, role string) (Profile, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for i := len(b.profiles) - 1; i >= 0; i-- {
+        p := b.profiles[i]
+        if strings.EqualFold(p.UUID, uuid) && (p.Role() == role || p.Role() == "") {
+            return p, true
+        }
+    }
+    return Profile{}, false
+}
+
+// deliver calls p's NewConnection for dev with one end of a new socketpair and returns the other.
+func (b *BlueZ) deliver(dev dbus.ObjectPath, p Profile) (*os.File, error) {
+    b.mu.Lock()
+    st, ok := b.devices[dev]
+    b.mu.Unlock()
+    if !ok {
+        return nil, fmt.Errorf("fakebluez: no device %s", dev)
+    }
+    fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return nil, fmt.Errorf("fakebluez: socketpair: %w", err)
+    }
+    fdProps := map[string]dbus.Variant{}
+    if st.spec.Version != 0 {
+        fdProps["Version"] = dbus.MakeVariant(st.spec.Version)
+    }
+    // The FD is duplicated into the receiver by the kernel; ours is closed after the call.
+    call := b.conn.Object(p.Owner, p.Path).Call(profileIface+".NewConnection", 0, dev, dbus.UnixFD(fds[0]), fdProps)
+    _ = unix.Close(fds[0])
+    f := os.NewFile(uintptr(fds[1]), "fakebluez-peer")
+    if call.Err != nil {
+        _ = f.Close()
+        return nil, call.Err
+    }
+    b.mu.Lock()
+    st.peers = append(st.peers, f)
+    b.mu.Unlock()
+    return f, nil
+}
+
+// addObject stores props, exports handler and Properties at path and emits InterfacesAdded.
+func (b *BlueZ) addObject(path dbus.ObjectPath, iface string, props map[string]dbus.Variant, handler interface{}) error {
+    b.mu.Lock()
+    if _, exists := b.objects[path]; exists {
+        b.mu.Unlock()
+        return fmt.Errorf("fakebluez: object %s already exists", path)
+    }
+    b.objects[path] = map[string]map[string]dbus.Variant{iface: props}
+    snapshot := copyProps(props)
+    b.mu.Unlock()
+
+    if err := b.conn.Export(handler, path, iface); err != nil {
+        return fmt.Errorf("fakebluez: export %s: %w", path, err)
+    }
+    if err := b.conn.Export(properties{b: b, path: path}, path, propsIface); err != nil {
+        return fmt.Errorf("fakebluez: export %s: %w", path, err)
+    }
+    return b.conn.Emit("/", objManagerIface+".InterfacesAdded", path, map[string]map[string]dbus.Variant{iface: snapshot})
+}
+
+// setProps updates properties and emits one PropertiesChanged signal for them.
+func (b *BlueZ) setProps(path dbus.ObjectPath, iface string, values map[string]interface{}) error {
+    changed := make(map[string]dbus.Variant, len(values))
+    b.mu.Lock()
+    props, ok := b.objects[path][iface]
+    if !ok {
+        b.mu.Unlock()
+        return fmt.Errorf("fakebluez: no %s at %s", iface, path)
+    }
+    for k, v := range values {
+        props[k] = dbus.MakeVariant(v)
+        changed[k] = props[k]
+    }
+    b.mu.Unlock()
+    return b.conn.Emit(path, propsIface+".PropertiesChanged", iface, changed, []string{})
+}
+
+func (b *BlueZ) device(path dbus.ObjectPath) (*devState, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    st, ok := b.devices[path]
+    return st, ok
+}
+
+func copyProps(props map[string]dbus.Variant) map[string]dbus.Variant {
+    out := make(map[string]dbus.Variant, len(props))
+    for k, v := range props {
+        out[k] = v
+    }
+    return out
+}
+
+func bluezError(name, msg string) *dbus.Error {
+    return &dbus.Error{Name: "org.bluez.Error." + name, Body: []interface{}{msg}}
+}
+
+// D-Bus handlers. Each type exports exactly the methods of its interface.
+
+type objectManager struct{ b *BlueZ }
+
+func (o objectManager) GetManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+    o.b.mu.Lock()
+    defer o.b.mu.Unlock()
+    out := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant, len(o.b.objects))
+    for p, ifaces := range o.b.objects {
+        out[p] = make(map[string]map[string]dbus.Variant, len(ifaces))
+        for iface, props := range ifaces {
+            out[p][iface] = copyProps(props)
+        }
+    }
+    return out, nil
+}
+
+type properties struct {
+    b    *BlueZ
+    path dbus.ObjectPath
+}
+
+func (p properties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+    p.b.mu.Lock()
+    defer p.b.mu.Unlock()
+    v, ok := p.b.objects[p.path][iface][name]
+    if !ok {
+        return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("no property %s.%s", iface, name))
+    }
+    return v, nil
+}
+
+func (p properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+    p.b.mu.Lock()
+    defer p.b.mu.Unlock()
+    props, ok := p.b.objects[p.path][iface]
+    if !ok {
+        return nil, dbus.MakeFailedError(fmt.Errorf("no interface %s", iface))
+    }
+    return copyProps(props), nil
+}
+
+func (p properties) Set(iface, name string, value dbus.Variant) *dbus.Error {
+    if !writable[iface][name] {
+        return bluezError("NotPermitted", "read-only property "+name)
+    }
+    p.b.mu.Lock()
+    old, ok := p.b.objects[p.path][iface][name]
+    p.b.mu.Unlock()
+    if !ok || reflect.TypeOf(old.Value()) != reflect.TypeOf(value.Value()) {
+        return bluezError("InvalidArguments", "invalid value for "+name)
+    }
+    if err := p.b.setProps(p.path, iface, map[string]interface{}{name: value.Value()}); err != nil {
+        return dbus.MakeFailedError(err)
+    }
+    return nil
+}
+
+type profileManager struct{ b *BlueZ }
+
+func (pm profileManager) RegisterProfile(sender dbus.Sender, path dbus.ObjectPath, uuid string, opts map[string]dbus.Variant) *dbus.Error {
+    b := pm.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for _, p := range b.profiles {
+        if p.Owner == string(sender) && p.Path == path {
+            return bluezError("AlreadyExists", "profile already registered")
+        }
+        if ch, ok := opts["Channel"]; ok && p.Options["Channel"] == ch && p.Role() != "client" {
+            return bluezError("Failed", "RFCOMM channel in use")
+        }
+    }
+    b.profiles = append(b.profiles, Profile{Owner: string(sender), Path: path, UUID: uuid, Options: opts})
+    return nil
+}
+
+func (pm profileManager) UnregisterProfile(sender dbus.Sender, path dbus.ObjectPath) *dbus.Error {
+    b := pm.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for i, p := range b.profiles {
+        if p.Owner == string(sender) && p.Path == path {
+            b.profiles = append(b.profiles[:i], b.profiles[i+1:]...)
+            return nil
+        }
+    }
+    return bluezError("DoesNotExist", "profile not registered")
+}
+
+type agentManager struct{ b *BlueZ }
+
+func (am agentManager) RegisterAgent(sender dbus.Sender, path dbus.ObjectPath, capability string) *dbus.Error {
+    b := am.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for _, a := range b.agents {
+        if a.Owner == string(sender) {
+            return bluezError("AlreadyExists", "agent already registered")
+        }
+    }
+    b.agents = append(b.agents, Agent{Owner: string(sender), Path: path, Capability: capability})
+    return nil
+}
+
+func (am agentManager) UnregisterAgent(sender dbus.Sender, path dbus.ObjectPath) *dbus.Error {
+    b := am.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for i, a := range b.agents {
+        if a.Owner == string(sender) && a.Path == path {
+            b.agents = append(b.agents[:i], b.agents[i+1:]...)
+            return nil
+        }
+    }
+    return bluezError("DoesNotExist", "agent not registered")
+}
+
+func (am agentManager) RequestDefaultAgent(sender dbus.Sender, path dbus.ObjectPath) *dbus.Error {
+    b := am.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    found := false
+    for i := range b.agents {
+        match := b.agents[i].Owner == string(sender) && b.agents[i].Path == path
+        b.agents[i].Default = match
+        found = found || match
+    }
+    if !found {
+        return bluezError("DoesNotExist", "agent not registered")
+    }
+    return nil
+}
+
+type adapter struct {
+    b    *BlueZ
+    path dbus.ObjectPath
+}
+
+func (a *adapter) StartDiscovery() *dbus.Error {
+    if err := a.b.setProps(a.path, adapterIface, map[string]interface{}{"Discovering": true}); err != nil {
+        return dbus.MakeFailedError(err)
+    }
+    return nil
+}
+
+func (a *adapter) StopDiscovery() *dbus.Error {
+    if err := a.b.setProps(a.path, adapterIface, map[string]interface{}{"Discovering": false}); err != nil {
+        return dbus.MakeFailedError(err)
+    }
+    return nil
+}
+
+// discoveryFilterKeys lists the keys SetDiscoveryFilter accepts.
+var discoveryFilterKeys = map[string]bool{
+    "UUIDs": true, "RSSI": true, "Pathloss": true, "Transport": true,
+    "DuplicateData": true, "Discoverable": true, "Pattern": true,
+}
+
+func (a *adapter) SetDiscoveryFilter(filter map[string]dbus.Variant) *dbus.Error {
+    for k := range filter {
+        if !discoveryFilterKeys[k] {
+            return bluezError("InvalidArguments", "unknown filter key "+k)
+        }
+    }
+    _, rssi := filter["RSSI"]
+    _, pathloss := filter["Pathloss"]
+    if rssi && pathloss {
+        return bluezError("InvalidArguments", "RSSI and Pathloss are mutually exclusive")
+    }
+    a.b.mu.Lock()
+    defer a.b.mu.Unlock()
+    if len(filter) == 0 {
+        delete(a.b.filters, a.path)
+    } else {
+        a.b.filters[a.path] = filter
+    }
+    return nil
+}
+
+func (a *adapter) RemoveDevice(dev dbus.ObjectPath) *dbus.Error {
+    if !strings.HasPrefix(string(dev), string(a.path)+"/") {
+        return bluezError("DoesNotExist", "no such device")
+    }
+    if err := a.b.RemoveDevice(dev); err != nil {
+        return bluezError("DoesNotExist", err.Error())
+    }
+    return nil
+}
+
+type device struct {
+    b    *BlueZ
+    path dbus.ObjectPath
+}
+
+func (d *device) Pair() *dbus.Error {
+    b := d.b
+    b.mu.Lock()
+    st, ok := b.devices[d.path]
+    if !ok {
+        b.mu.Unlock()
+        return bluezError("DoesNotExist", "no such device")
+    }
+    if paired, _ := b.objects[d.path][deviceIface]["Paired"].Value().(bool); paired {
+        b.mu.Unlock()
+        return bluezError("AlreadyExists", "already paired")
+    }
+    if st.cancel != nil {
+        b.mu.Unlock()
+        return bluezError("InProgress", "pairing in progress")
+    }
+    spec := st.spec
+    cancel := make(chan struct{})
+    st.cancel = cancel
+    var agent *Agent
+    for i := range b.agents {
+        if b.agents[i].Default {
+            a := b.agents[i]
+            agent = &a
+        }
+    }
+    b.mu.Unlock()
+    defer func() {
+        b.mu.Lock()
+        if st.cancel == cancel {
+            st.cancel = nil
+        }
+        b.mu.Unlock()
+    }()
+
+    if spec.PairDelay > 0 {
+        t := time.NewTimer(spec.PairDelay)
+        defer t.Stop()
+        select {
+        case <-t.C:
+        case <-cancel:
+            return bluezError("AuthenticationCanceled", "pairing canceled")
+        }
+    }
+    if spec.PairError != "" {
+        return &dbus.Error{Name: spec.PairError, Body: []interface{}{"pairing failed"}}
+    }
+    if spec.Passkey != 0 && agent != nil {
+        obj := b.conn.Object(agent.Owner, agent.Path)
+        call := obj.Go(agentIface+".RequestConfirmation", 0, make(chan *dbus.Call, 1), d.path, spec.Passkey)
+        select {
+        case <-call.Done:
+        case <-cancel:
+            // Like bluetoothd: tell the agent, then drop its pending request.
+            _ = obj.Call(agentIface+".Cancel", 0).Err
+            return bluezError("AuthenticationCanceled", "pairing canceled")
+        }
+        if call.Err != nil {
+            return bluezError("AuthenticationFailed", "passkey rejected")
+        }
+    }
+    if err := b.setProps(d.path, deviceIface, map[string]interface{}{"Paired": true}); err != nil {
+        return dbus.MakeFailedError(err)
+    }
+    return nil
+}
+
+func (d *device) CancelPairing() *dbus.Error {
+    b := d.b
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    st, ok := b.devices[d.path]
+    if !ok || st.cancel == nil {
+        return bluezError("DoesNotExist", "no pairing in progress")
+    }
+    close(st.cancel)
+    st.cancel = nil
+    return nil
+}
+
+func (d *device) ConnectProfile(uuid string) *dbus.Error {
+    st, ok := d.b.device(d.path)
+    if !ok {
+        return bluezError("DoesNotExist", "no such device")
+    }
+    if st.spec.ConnectError != "" {
+        return &dbus.Error{Name: st.spec.ConnectError, Body: []interface{}{"connection failed"}}
+    }
+    p, ok := d.b.findProfile(uuid, "client")
+    if !ok {
+        return bluezError("NotAvailable", "no client profile for "+uuid)
+    }
+    f, err := d.b.deliver(d.path, p)
+    if err != nil {
+        return bluezError("Failed", err.Error())
+    }
+    _ = d.b.setProps(d.path, deviceIface, map[string]interface{}{"Connected": true})
+    select {
+    case d.b.links <- Link{Device: d.path, UUID: uuid, File: f}:
+    default:
+        _ = f.Close()
+    }
+    return nil
+}
+
+// Connect connects the first client profile matching one of the device's UUIDs.
+func (d *device) Connect() *dbus.Error {
+    d.b.mu.Lock()
+    uuids, _ := d.b.objects[d.path][deviceIface]["UUIDs"].Value().([]string)
+    d.b.mu.Unlock()
+    for _, u := range uuids {
+        if _, ok := d.b.findProfile(u, "client"); ok {
+            return d.ConnectProfile(u)
+        }
+    }
+    return bluezError("NotAvailable", "no matching client profile")
+}
+
+func (d *device) Disconnect() *dbus.Error {
+    if err := d.b.Disconnect(d.path); err != nil {
+        return bluezError("DoesNotExist", err.Error())
+    }
+    return nil
+}
+
+func (d *device) DisconnectProfile(uuid string) *dbus.Error {
+    return d.Disconnect()
+}
@@ -0,0 +1,90 @@
+// Package fakebluez is a stand-in for bluetoothd on a private D-Bus, so connmgr can be
+// exercised without a Bluetooth radio.
+//
+// It owns the org.bluez name and implements the subset of the BlueZ API used by connmgr:
+// ObjectManager on "/", ProfileManager1 and AgentManager1 on /org/bluez, Adapter1 and Device1
+// objects with org.freedesktop.DBus.Properties (Get/GetAll/Set and PropertiesChanged).
+// Tests populate adapters and devices, then drive connections: Device1.ConnectProfile and
+// Connect deliver one end of a Unix socketpair to the registered Profile1.NewConnection and
+// hand the other end to the test.
+//
+// A typical setup runs a private bus with StartDaemon and connects both sides to it:
+//
+//    d, _ := fakebluez.StartDaemon()
+//    bc, _ := d.Dial()
+//    bz, _ := fakebluez.New(bc)
+//    hci0, _ := bz.AddAdapter("hci0", "00:11:22:33:44:55")
+//    cc, _ := d.Dial()
+//    m := connmgr.New(connmgr.WithConn(cc))
+//
+// A private dbus-daemon is required: godbus peer-to-peer connections have no name
+// ownership or match rules, and cannot pass Unix FDs.
+package fakebluez
+
+import (
+    "os"
+    "time"
+
+    dbus "github.com/godbus/dbus/v5"
+)
+
+// Device describes a remote device added with AddDevice. The boolean and string fields
+// become Device1 properties; the remaining fields control how the fake behaves.
+type Device struct {
+    Address   string // "AA:BB:CC:DD:EE:FF"; required
+    Name      string
+    Alias     string // defaults to Name, or Address if Name is empty
+    RSSI      int16  // omitted from the properties when 0
+    Class     uint32 // omitted when 0
+    Icon      string // omitted when empty
+    UUIDs     []string
+    Paired    bool
+    Trusted   bool
+    Blocked   bool
+    Connected bool
+
+    // Passkey, if non-zero, makes Pair ask the default agent to confirm it with
+    // RequestConfirmation; a rejection fails Pair with org.bluez.Error.AuthenticationFailed.
+    // CancelPairing while the agent is asked calls its Cancel method.
+    Passkey uint32
+    // PairDelay keeps Pair pending for this long (CancelPairing ends it early).
+    PairDelay time.Duration
+    // PairError and ConnectError, if set, are D-Bus error names returned by Pair and
+    // ConnectProfile/Connect respectively, e.g. "org.bluez.Error.AuthenticationFailed".
+    PairError    string
+    ConnectError string
+    // Version, if non-zero, is passed as "Version" in the NewConnection fd_properties,
+    // like the profile version BlueZ reads from the peer's SDP record.
+    Version uint16
+}
+
+// Profile is a Profile1 object registered through ProfileManager1.RegisterProfile.
+type Profile struct {
+    Owner   string // unique bus name of the registering connection
+    Path    dbus.ObjectPath
+    UUID    string
+    Options map[string]dbus.Variant
+}
+
+// Role returns the "Role" option ("client", "server" or "" if unset).
+func (p Profile) Role() string {
+    r, _ := p.Options["Role"].Value().(string)
+    return r
+}
+
+// Agent is an Agent1 object registered through AgentManager1.RegisterAgent.
+type Agent struct {
+    Owner      string
+    Path       dbus.ObjectPath
+    Capability string
+    Default    bool // set by RequestDefaultAgent
+}
+
+// Link is a connection started by Device1.ConnectProfile or Connect. File is the remote
+// side of the socket handed to the client profile; reading and writing it talks to the
+// local application.
+type Link struct {
+    Device dbus.ObjectPath
+    UUID   string
+    File   *os.File
+}
@@ -0,0 +1,219 @@
+package framing
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+)
+
+// Binary frames are the alternative to LF-delimited lines, selected when both ends announce
+// BinaryCapability in the handshake. A frame carries any payload, including LF, and is laid
+// out as (integers big-endian):
+//
+//    offset  size  field
+//    0       2     sync marker 0xB1 0x7C
+//    2       1     type (FrameType; 0 is invalid)
+//    3       1     flags (FlagCRC; other bits are passed through)
+//    4       4     message ID
+//    8       4     payload length
+//    12      n     payload
+//    12+n    4     CRC-32 (IEEE) of bytes 0..12+n, present only with FlagCRC
+//
+// The FrameDecoder resynchronizes after corruption by searching for the next sync marker.
+// Only frames with FlagCRC are reliably detected as corrupt; without it a damaged length
+// field can swallow the frames that follow, up to the payload limit.
+
+// BinaryCapability is the handshake capability announcing support for binary frames.
+const BinaryCapability = "binary"
+
+// DefaultMaxPayload is the payload limit used when the options leave it 0.
+const DefaultMaxPayload = DefaultMaxLineLength
+
+// FrameHeaderLength is the size of a frame without payload and CRC.
+const FrameHeaderLength = 12
+
+// FlagCRC marks a frame followed by a CRC-32 trailer.
+const FlagCRC uint8 = 1 << 0
+
+var frameSync = [2]byte{0xB1, 0x7C}
+
+var (
+    // ErrFrameTooLarge is returned by the encoder for a payload above its limit.
+    ErrFrameTooLarge = errors.New("framing: frame payload too large")
+    // ErrFrameType is returned by the encoder for a frame with type 0.
+    ErrFrameType = errors.New("framing: invalid frame type")
+    // ErrCorrupt is returned by FrameDecoder.Feed when bytes had to be skipped to find the
+    // next valid frame. It is reported once per corrupt stretch and the decoder stays usable.
+    ErrCorrupt = errors.New("framing: corrupt frame data skipped")
+)
+
+// FrameType tells the receiver how to interpret a frame's payload.
+type FrameType uint8
+
+const (
+    FrameText       FrameType = 1 // UTF-8 chat message; may contain LF
+    FrameControl    FrameType = 2 // protocol control data (e.g. receipts)
+    FrameAttachment FrameType = 3 // opaque binary content
+)
+
+func (t FrameType) String() string {
+    switch t {
+    case FrameText:
+        return "text"
+    case FrameControl:
+        return "control"
+    case FrameAttachment:
+        return "attachment"
+    }
+    return fmt.Sprintf("type(%d)", uint8(t))
+}
+
+// Frame is one unit of the binary format.
+type Frame struct {
+    Type    FrameType
+    Flags   uint8  // FlagCRC is set by the encoder as configured and reported by the decoder
+    ID      uint32 // message ID chosen by the sender; 0 if unused
+    Payload []byte
+}
+
+// AppendFrame appends the encoding of f to dst. A CRC trailer is written iff f.Flags has FlagCRC.
+func AppendFrame(dst []byte, f Frame) ([]byte, error) {
+    if f.Type == 0 {
+        return dst, ErrFrameType
+    }
+    if uint64(len(f.Payload)) > 0xFFFFFFFF {
+        return dst, ErrFrameTooLarge
+    }
+    start := len(dst)
+    dst = append(dst, frameSync[0], frameSync[1], byte(f.Type), f.Flags)
+    dst = binary.BigEndian.AppendUint32(dst, f.ID)
+    dst = binary.BigEndian.AppendUint32(dst, uint32(len(f.Payload)))
+    dst = append(dst, f.Payload...)
+    if f.Flags&FlagCRC != 0 {
+        dst = binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start:]))
+    }
+    return dst, nil
+}
+
+// FrameOptions configures a FrameEncoder or FrameDecoder.
+type FrameOptions struct {
+    // MaxPayload bounds the payload of one frame. 0 means DefaultMaxPayload; negative means
+    // unlimited (up to 4 GiB - 1). The decoder treats a longer length as corruption.
+    MaxPayload int
+    // CRC makes the encoder append a CRC-32 trailer to every frame. The decoder checks the
+    // trailer of every frame that has one, whatever this setting.
+    CRC bool
+}
+
+func (o FrameOptions) maxPayload() int {
+    if o.MaxPayload == 0 {
+        return DefaultMaxPayload
+    }
+    return o.MaxPayload
+}
+
+// FrameEncoder writes binary frames to an io.Writer.
+type FrameEncoder struct {
+    w   io.Writer
+    max int
+    crc bool
+    buf []byte
+}
+
+// NewFrameEncoder returns a FrameEncoder writing to w.
+func NewFrameEncoder(w io.Writer, opts FrameOptions) *FrameEncoder {
+    return &FrameEncoder{w: w, max: opts.maxPayload(), crc: opts.CRC}
+}
+
+// Encode writes f in a single Write call. It is not safe for concurrent use.
+func (e *FrameEncoder) Encode(f Frame) error {
+    if e.max > 0 && len(f.Payload) > e.max {
+        return ErrFrameTooLarge
+    }
+    if e.crc {
+        f.Flags |= FlagCRC
+    }
+    b, err := AppendFrame(e.buf[:0], f)
+    if err != nil {
+        return err
+    }
+    e.buf = b
+    _, err = e.w.Write(b)
+    return err
+}
+
+// FrameDecoder splits a byte stream into binary frames. It is not safe for concurrent use.
+type FrameDecoder struct {
+    max     int
+    buf     []byte
+    corrupt bool // inside a corrupt stretch already reported
+}
+
+// NewFrameDecoder returns a FrameDecoder configured by opts.
+func NewFrameDecoder(opts FrameOptions) *FrameDecoder {
+    return &FrameDecoder{max: opts.maxPayload()}
+}
+
+// Feed consumes p and returns the frames it completed, in order. Payloads are copies and stay
+// valid. The returned frames are valid even when err is ErrCorrupt.
+func (d *FrameDecoder) Feed(p []byte) (frames []Frame, err error) {
+    d.buf = append(d.buf, p...)
+    off := 0
+    skip := func(n int) {
+        off += n
+        if !d.corrupt {
+            d.corrupt = true
+            err = ErrCorrupt
+        }
+    }
+    for {
+        i := bytes.Index(d.buf[off:], frameSync[:])
+        if i < 0 {
+            // Keep a trailing first sync byte; its second byte may be in the next read.
+            n := len(d.buf) - off
+            if n > 0 && d.buf[len(d.buf)-1] == frameSync[0] {
+                n--
+            }
+            if n > 0 {
+                skip(n)
+            }
+            break
+        }
+        if i > 0 {
+            skip(i)
+        }
+        h := d.buf[off:]
+        if len(h) < FrameHeaderLength {
+            break
+        }
+        f := Frame{Type: FrameType(h[2]), Flags: h[3], ID: binary.BigEndian.Uint32(h[4:8])}
+        n := binary.BigEndian.Uint32(h[8:12])
+        if f.Type == 0 || (d.max > 0 && uint64(n) > uint64(d.max)) {
+            skip(1)
+            continue
+        }
+        total := FrameHeaderLength + int(n)
+        if f.Flags&FlagCRC != 0 {
+            total += 4
+        }
+        if len(h) < total {
+            break
+        }
+        if f.Flags&FlagCRC != 0 && crc32.ChecksumIEEE(h[:total-4]) != binary.BigEndian.Uint32(h[total-4:total]) {
+            skip(1)
+            continue
+        }
+        f.Payload = append([]byte(nil), h[FrameHeaderLength:FrameHeaderLength+int(n)]...)
+        frames = append(frames, f)
+        off += total
+        d.corrupt = false
+    }
+    d.buf = append(d.buf[:0], d.buf[off:]...)
+    return frames, err
+}
+
+// Buffered returns the number of bytes held for an incomplete frame.
+func (d *FrameDecoder) Buffered() int { return len(d.buf) }
@@ -0,0 +1,168 @@
+package framing
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "testing"
+)
+
+func frame(t *testing.T, f Frame) []byte {
+    t.Helper()
+    b, err := AppendFrame(nil, f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+func payloads(frames []Frame) []string {
+    var out []string
+    for _, f := range frames {
+        out = append(out, string(f.Payload))
+    }
+    return out
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+    var buf bytes.Buffer
+    enc := NewFrameEncoder(&buf, FrameOptions{CRC: true})
+    in := []Frame{
+        {Type: FrameText, ID: 1, Payload: []byte("line one\nline two")},
+        {Type: FrameControl, ID: 2, Flags: 0x80, Payload: nil},
+        {Type: FrameAttachment, ID: 0xFFFFFFFF, Payload: []byte{0xB1, 0x7C, 0, 0}}, // sync marker in the payload
+    }
+    for _, f := range in {
+        if err := enc.Encode(f); err != nil {
+            t.Fatal(err)
+        }
+    }
+    out, err := NewFrameDecoder(FrameOptions{}).Feed(buf.Bytes())
+    if err != nil || len(out) != len(in) {
+        t.Fatalf("got %d frames, %v", len(out), err)
+    }
+    for i, f := range out {
+        want := in[i]
+        if f.Type != want.Type || f.ID != want.ID || f.Flags != want.Flags|FlagCRC || !bytes.Equal(f.Payload, want.Payload) {
+            t.Errorf("frame %d: got %+v, want %+v", i, f, want)
+        }
+    }
+}
+
+func TestFrameDecoderByteByByte(t *testing.T) {
+    // Every split point, including inside the sync marker, header and CRC.
+    data := append(frame(t, Frame{Type: FrameText, Flags: FlagCRC, Payload: []byte("hello")}),
+        frame(t, Frame{Type: FrameText, Payload: []byte("world")})...)
+    d := NewFrameDecoder(FrameOptions{})
+    var got []Frame
+    for i := range data {
+        fs, err := d.Feed(data[i : i+1])
+        if err != nil {
+            t.Fatalf("byte %d: %v", i, err)
+        }
+        got = append(got, fs...)
+    }
+    if p := payloads(got); len(p) != 2 || p[0] != "hello" || p[1] != "world" {
+        t.Fatalf("got %q", p)
+    }
+    if d.Buffered() != 0 {
+        t.Errorf("%d bytes left buffered", d.Buffered())
+    }
+}
+
+func TestFrameDecoderSyncSplitAfterGarbage(t *testing.T) {
+    // Garbage ending in the first sync byte; the second one arrives with the next read.
+    f := frame(t, Frame{Type: FrameText, Flags: FlagCRC, Payload: []byte("ok")})
+    d := NewFrameDecoder(FrameOptions{})
+    frames, err := d.Feed(append([]byte("junk"), f[0]))
+    if !errors.Is(err, ErrCorrupt) || len(frames) != 0 {
+        t.Fatalf("got %d frames, %v; want ErrCorrupt", len(frames), err)
+    }
+    if d.Buffered() != 1 {
+        t.Fatalf("buffered %d, want the first sync byte", d.Buffered())
+    }
+    frames, err = d.Feed(f[1:])
+    if err != nil || len(frames) != 1 || string(frames[0].Payload) != "ok" {
+        t.Fatalf("got %q, %v", payloads(frames), err)
+    }
+}
+
+func TestFrameDecoderBadCRC(t *testing.T) {
+    bad := frame(t, Frame{Type: FrameText, Flags: FlagCRC, ID: 1, Payload: []byte("damaged")})
+    bad[FrameHeaderLength] ^= 0xFF
+    good := frame(t, Frame{Type: FrameText, Flags: FlagCRC, ID: 2, Payload: []byte("fine")})
+    frames, err := NewFrameDecoder(FrameOptions{}).Feed(append(bad, good...))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+    if len(frames) != 1 || frames[0].ID != 2 || string(frames[0].Payload) != "fine" {
+        t.Fatalf("got %+v, want only the valid frame", frames)
+    }
+}
+
+func TestFrameDecoderLengthOverLimit(t *testing.T) {
+    opts := FrameOptions{MaxPayload: 16}
+    huge := frame(t, Frame{Type: FrameText, Payload: bytes.Repeat([]byte("x"), 17)})
+    good := frame(t, Frame{Type: FrameText, Payload: []byte("small")})
+    d := NewFrameDecoder(opts)
+    frames, err := d.Feed(append(huge, good...))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+    if p := payloads(frames); len(p) != 1 || p[0] != "small" {
+        t.Fatalf("got %q", p)
+    }
+
+    // A corrupt length must not make the decoder wait for gigabytes.
+    hdr := frame(t, Frame{Type: FrameText})
+    binary.BigEndian.PutUint32(hdr[8:12], 0xFFFFFFF0)
+    d = NewFrameDecoder(FrameOptions{})
+    if _, err := d.Feed(hdr); !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+    if d.Buffered() > 1 {
+        t.Errorf("%d bytes buffered for an impossible frame", d.Buffered())
+    }
+
+    if err := NewFrameEncoder(new(bytes.Buffer), opts).Encode(Frame{Type: FrameText, Payload: make([]byte, 17)}); !errors.Is(err, ErrFrameTooLarge) {
+        t.Errorf("encoder: got %v, want ErrFrameTooLarge", err)
+    }
+}
+
+func TestFrameDecoderTypeZero(t *testing.T) {
+    zero := frame(t, Frame{Type: FrameText, Payload: []byte("zero")})
+    zero[2] = 0
+    good := frame(t, Frame{Type: FrameText, Payload: []byte("good")})
+    frames, err := NewFrameDecoder(FrameOptions{}).Feed(append(zero, good...))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+    if p := payloads(frames); len(p) != 1 || p[0] != "good" {
+        t.Fatalf("got %q", p)
+    }
+    if _, err := AppendFrame(nil, Frame{}); !errors.Is(err, ErrFrameType) {
+        t.Errorf("AppendFrame: got %v, want ErrFrameType", err)
+    }
+}
+
+func TestFrameDecoderCorruptReportedOnce(t *testing.T) {
+    good := frame(t, Frame{Type: FrameText, Flags: FlagCRC, Payload: []byte("good")})
+    d := NewFrameDecoder(FrameOptions{})
+
+    // One corrupt stretch over three reads is reported once.
+    if _, err := d.Feed([]byte("garbage ")); !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("first read: got %v, want ErrCorrupt", err)
+    }
+    if _, err := d.Feed([]byte("more garbage ")); err != nil {
+        t.Fatalf("second read: got %v, want nil in the same stretch", err)
+    }
+    frames, err := d.Feed(append([]byte("end "), good...))
+    if err != nil || len(frames) != 1 {
+        t.Fatalf("third read: %d frames, %v", len(frames), err)
+    }
+
+    // A valid frame ends the stretch: the next corruption is reported again.
+    if _, err := d.Feed([]byte("again")); !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("after a valid frame: got %v, want ErrCorrupt", err)
+    }
+}
@@ -0,0 +1,131 @@
+// Package framing converts between chat messages and the byte stream carried over the
+// transport (DESIGN.md layer C). Two formats exist: LF-delimited lines (Encoder/Decoder),
+// the default, and binary frames (FrameEncoder/FrameDecoder, see binary.go), used when both
+// ends announce BinaryCapability in the handshake.
+//
+// In the line format each message is sent as its bytes followed by exactly one LF; there is
+// no escaping, so a message must not contain LF itself. Both decoders are incremental: Feed
+// accepts whatever a Read returned and yields the messages completed so far, keeping a
+// trailing partial message until the rest arrives.
+package framing
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "strings"
+)
+
+// DefaultMaxLineLength is the line limit used when DecoderOptions.MaxLineLength is 0.
+const DefaultMaxLineLength = 64 * 1024
+
+var (
+    // ErrNewline is returned by Encode for a message containing LF.
+    ErrNewline = errors.New("framing: message contains LF")
+    // ErrLineTooLong is returned by Feed when a line exceeded the maximum length. The line is
+    // dropped (up to and including its LF) and decoding continues with the next line.
+    ErrLineTooLong = errors.New("framing: line too long")
+)
+
+// Encode returns msg followed by LF. It reports ErrNewline if msg contains LF.
+func Encode(msg string) ([]byte, error) {
+    if strings.IndexByte(msg, '\n') >= 0 {
+        return nil, ErrNewline
+    }
+    b := make([]byte, 0, len(msg)+1)
+    b = append(b, msg...)
+    return append(b, '\n'), nil
+}
+
+// Encoder writes LF-terminated messages to an io.Writer.
+type Encoder struct {
+    w io.Writer
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder { return &Encoder{w: w} }
+
+// Encode writes msg and its LF in a single Write call, so concurrent Encoders on the same
+// stream-oriented writer do not interleave within a line.
+func (e *Encoder) Encode(msg string) error {
+    b, err := Encode(msg)
+    if err != nil {
+        return err
+    }
+    _, err = e.w.Write(b)
+    return err
+}
+
+// DecoderOptions configures a Decoder.
+type DecoderOptions struct {
+    // MaxLineLength bounds the bytes buffered for one line, excluding the LF.
+    // 0 means DefaultMaxLineLength; negative means unlimited.
+    MaxLineLength int
+    // StripCR removes one trailing CR from each line, for peers that send CRLF.
+    StripCR bool
+}
+
+// Decoder splits an LF-delimited byte stream into messages. It is not safe for concurrent use.
+type Decoder struct {
+    max        int
+    stripCR    bool
+    buf        []byte // partial line
+    discarding bool   // inside an oversized line; skip up to the next LF
+}
+
+// NewDecoder returns a Decoder configured by opts.
+func NewDecoder(opts DecoderOptions) *Decoder {
+    max := opts.MaxLineLength
+    if max == 0 {
+        max = DefaultMaxLineLength
+    }
+    return &Decoder{max: max, stripCR: opts.StripCR}
+}
+
+// Feed consumes p and returns the messages it completed, in order. The returned messages
+// are valid even when err is ErrLineTooLong; the error is reported once per oversized line
+// and the Decoder stays usable.
+func (d *Decoder) Feed(p []byte) (msgs []string, err error) {
+    for len(p) > 0 {
+        i := bytes.IndexByte(p, '\n')
+        if i < 0 {
+            if !d.discarding {
+                if d.tooLong(len(p)) {
+                    d.buf = d.buf[:0]
+                    d.discarding = true
+                    err = ErrLineTooLong
+                } else {
+                    d.buf = append(d.buf, p...)
+                }
+            }
+            return msgs, err
+        }
+        line := p[:i]
+        p = p[i+1:]
+        if d.discarding {
+            d.discarding = false
+            continue
+        }
+        if d.tooLong(len(line)) {
+            d.buf = d.buf[:0]
+            err = ErrLineTooLong
+            continue
+        }
+        d.buf = append(d.buf, line...)
+        msg := d.buf
+        if d.stripCR && len(msg) > 0 && msg[len(msg)-1] == '\r' {
+            msg = msg[:len(msg)-1]
+        }
+        msgs = append(msgs, string(msg))
+        d.buf = d.buf[:0]
+    }
+    return msgs, err
+}
+
+// tooLong reports whether adding n bytes to the partial line exceeds the limit.
+func (d *Decoder) tooLong(n int) bool {
+    return d.max > 0 && len(d.buf)+n > d.max
+}
+
+// Buffered returns the number of bytes held for an incomplete line.
+func (d *Decoder) Buffered() int { return len(d.buf) }
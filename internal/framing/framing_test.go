@@ -0,0 +1,112 @@
+package framing
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestEncode(t *testing.T) {
+    // DESIGN.md 9.3: exactly one LF is appended, nothing is escaped.
+    for msg, want := range map[string]string{"abc": "abc\n", "": "\n", "tab\there\r": "tab\there\r\n"} {
+        got, err := Encode(msg)
+        if err != nil || string(got) != want {
+            t.Errorf("Encode(%q) = %q, %v; want %q", msg, got, err, want)
+        }
+    }
+    if _, err := Encode("a\nb"); !errors.Is(err, ErrNewline) {
+        t.Errorf("Encode with LF: got %v, want ErrNewline", err)
+    }
+
+    var buf bytes.Buffer
+    enc := NewEncoder(&buf)
+    enc.Encode("one")
+    enc.Encode("two")
+    if err := enc.Encode("x\ny"); !errors.Is(err, ErrNewline) {
+        t.Errorf("Encoder with LF: got %v, want ErrNewline", err)
+    }
+    if buf.String() != "one\ntwo\n" {
+        t.Errorf("Encoder wrote %q", buf.String())
+    }
+}
+
+func TestDecode(t *testing.T) {
+    // DESIGN.md 9.3: "a\nb\n\nc" yields "a", "b", ""; "c" waits for its LF.
+    d := NewDecoder(DecoderOptions{})
+    msgs, err := d.Feed([]byte("a\nb\n\nc"))
+    if err != nil || strings.Join(msgs, "|") != "a|b|" || len(msgs) != 3 {
+        t.Fatalf("got %q, %v", msgs, err)
+    }
+    if d.Buffered() != 1 {
+        t.Fatalf("buffered %d, want 1", d.Buffered())
+    }
+    msgs, err = d.Feed([]byte("d\n"))
+    if err != nil || len(msgs) != 1 || msgs[0] != "cd" {
+        t.Fatalf("got %q, %v; want \"cd\"", msgs, err)
+    }
+}
+
+func TestDecodeSplitReads(t *testing.T) {
+    // A long line arriving in small pieces is reassembled.
+    line := strings.Repeat("0123456789", 1000)
+    data := []byte(line + "\n" + "short\n")
+    d := NewDecoder(DecoderOptions{})
+    var got []string
+    for len(data) > 0 {
+        n := min(7, len(data))
+        msgs, err := d.Feed(data[:n])
+        if err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, msgs...)
+        data = data[n:]
+    }
+    if len(got) != 2 || got[0] != line || got[1] != "short" {
+        t.Fatalf("got %d messages", len(got))
+    }
+}
+
+func TestDecodeCR(t *testing.T) {
+    // CR is data unless StripCR is set.
+    msgs, _ := NewDecoder(DecoderOptions{}).Feed([]byte("a\r\n"))
+    if len(msgs) != 1 || msgs[0] != "a\r" {
+        t.Errorf("got %q, want CR kept", msgs)
+    }
+    msgs, _ = NewDecoder(DecoderOptions{StripCR: true}).Feed([]byte("a\r\n\r\r\n"))
+    if len(msgs) != 2 || msgs[0] != "a" || msgs[1] != "\r" {
+        t.Errorf("got %q, want one CR stripped per line", msgs)
+    }
+}
+
+func TestDecodeLineTooLong(t *testing.T) {
+    d := NewDecoder(DecoderOptions{MaxLineLength: 4})
+    // Exactly at the limit is fine.
+    if msgs, err := d.Feed([]byte("1234\n")); err != nil || len(msgs) != 1 {
+        t.Fatalf("got %q, %v", msgs, err)
+    }
+    // An oversized line split over reads is dropped up to its LF, reported once.
+    if _, err := d.Feed([]byte("12345")); !errors.Is(err, ErrLineTooLong) {
+        t.Fatalf("got %v, want ErrLineTooLong", err)
+    }
+    if d.Buffered() != 0 {
+        t.Errorf("buffered %d bytes of a dropped line", d.Buffered())
+    }
+    if msgs, err := d.Feed([]byte("678")); err != nil || len(msgs) != 0 {
+        t.Fatalf("rest of the long line: %q, %v", msgs, err)
+    }
+    msgs, err := d.Feed([]byte("9\nok\n"))
+    if err != nil || len(msgs) != 1 || msgs[0] != "ok" {
+        t.Fatalf("after the long line: %q, %v", msgs, err)
+    }
+    // A complete oversized line inside one read does not hide its neighbours.
+    msgs, err = d.Feed([]byte("a\ntoolong\nb\n"))
+    if !errors.Is(err, ErrLineTooLong) || strings.Join(msgs, "|") != "a|b" {
+        t.Fatalf("got %q, %v", msgs, err)
+    }
+    // Unlimited.
+    msgs, err = NewDecoder(DecoderOptions{MaxLineLength: -1}).Feed([]byte(strings.Repeat("x", DefaultMaxLineLength+1) + "\n"))
+    if err != nil || len(msgs) != 1 {
+        t.Fatalf("unlimited: %d messages, %v", len(msgs), err)
+    }
+}
@@ -0,0 +1,321 @@
+// Package handshake implements the hello exchange that opens every chat connection, before
+// any message is framed (DESIGN.md layer C).
+//
+// Right after Accept/Connect hand over the FD, both ends send one hello line while reading
+// the peer's, so neither side waits for the other to speak first:
+//
+//    BTCHAT/<version> minver=<n> frame=<bytes> caps=<cap1,cap2> nick=<nickname>
+//
+// version is the highest protocol version the sender speaks and minver the lowest. Values
+// are query-escaped and unknown keys are ignored, so later versions can add fields. The
+// negotiated version is the highest both ends speak, the frame limit the smaller of the
+// two, and the capabilities those both ends announced.
+//
+// A peer that sends anything else, nothing within the timeout, or a line longer than
+// MaxHelloLength is not a chat client (ErrNotChat) — typically a serial device picked from
+// a scan. The caller should close the connection.
+package handshake
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+const (
+    // Magic starts every hello line.
+    Magic = "BTCHAT"
+    // Version and MinVersion are the protocol versions this package speaks.
+    Version    = 1
+    MinVersion = 1
+
+    // DefaultTimeout bounds the exchange when Run is given no timeout.
+    DefaultTimeout = 10 * time.Second
+    // DefaultMaxFrame is the frame limit announced when Hello.MaxFrame is 0 (the framing
+    // package's default line limit).
+    DefaultMaxFrame = 64 * 1024
+    // MinFrame is the smallest frame limit a hello may announce.
+    MinFrame = 256
+    // MaxHelloLength bounds the hello line, excluding its LF.
+    MaxHelloLength = 1024
+    // MaxNicknameLength bounds Hello.Nickname in bytes.
+    MaxNicknameLength = 64
+)
+
+var (
+    // ErrNotChat is returned when the peer does not answer with a valid hello.
+    ErrNotChat = errors.New("handshake: peer is not a chat client")
+    // ErrVersion is returned when the peer is a chat client without a protocol version in common.
+    ErrVersion = errors.New("handshake: no common protocol version")
+)
+
+// Hello is what one end announces about itself.
+type Hello struct {
+    Version    int      // highest protocol version spoken; 0 means Version
+    MinVersion int      // lowest protocol version spoken; 0 means MinVersion
+    Nickname   string   // shown to the peer; at most MaxNicknameLength bytes, no control characters
+    Caps       []string // optional features, e.g. "binary"; lower-case letters, digits, '.', '_' and '-'
+    MaxFrame   int      // largest frame accepted, in bytes; 0 means DefaultMaxFrame
+}
+
+// Result is the outcome of a successful handshake.
+type Result struct {
+    Peer     Hello    // as announced by the peer, with defaults filled in
+    Version  int      // negotiated protocol version
+    Caps     []string // capabilities announced by both ends, in local order
+    MaxFrame int      // smaller of the two frame limits
+
+    // The hello lines as sent and as received, without their LF, for layers that
+    // authenticate the negotiation afterwards (see secure.Handshake).
+    LocalLine, PeerLine string
+}
+
+// Has reports whether both ends announced capability c.
+func (r Result) Has(c string) bool {
+    for _, have := range r.Caps {
+        if have == c {
+            return true
+        }
+    }
+    return false
+}
+
+// Conn is the connection the handshake runs on; *transport.Conn and net.Conn satisfy it.
+type Conn interface {
+    io.ReadWriter
+    SetDeadline(t time.Time) error
+}
+
+// Run sends local, reads the peer's hello and negotiates. The exchange must complete within
+// timeout (DefaultTimeout if 0) and before ctx ends; the connection deadline is cleared
+// again before Run returns. Bytes the peer sends after its hello are left unread.
+func Run(ctx context.Context, conn Conn, local Hello, timeout time.Duration) (Result, error) {
+    local = local.withDefaults()
+    line, err := Encode(local)
+    if err != nil {
+        return Result{}, err
+    }
+    if timeout <= 0 {
+        timeout = DefaultTimeout
+    }
+    if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+        return Result{}, fmt.Errorf("handshake: %w", err)
+    }
+    defer conn.SetDeadline(time.Time{})
+    // Cancellation interrupts blocked I/O by moving the deadline to now.
+    stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+    defer stop()
+
+    // Send while reading, so the exchange also works over unbuffered pipes.
+    sent := make(chan error, 1)
+    go func() {
+        _, err := conn.Write(line)
+        sent <- err
+    }()
+    peerLine, err := readLine(conn)
+    if err != nil {
+        _ = conn.SetDeadline(time.Now()) // unblock the writer
+        <-sent
+        return Result{}, ioError(ctx, "read hello", timeout, err)
+    }
+    if err := <-sent; err != nil {
+        return Result{}, ioError(ctx, "send hello", timeout, err)
+    }
+    peer, err := Parse(peerLine)
+    if err != nil {
+        return Result{}, err
+    }
+    res, err := negotiate(local, peer)
+    if err != nil {
+        return Result{}, err
+    }
+    res.LocalLine, res.PeerLine = strings.TrimSuffix(string(line), "\n"), peerLine
+    return res, nil
+}
+
+// ioError turns a failed read or write into the error reported by Run.
+func ioError(ctx context.Context, op string, timeout time.Duration, err error) error {
+    if ctx.Err() != nil {
+        return fmt.Errorf("handshake: %s: %w", op, ctx.Err())
+    }
+    if errors.Is(err, os.ErrDeadlineExceeded) {
+        return fmt.Errorf("%w: no hello within %s", ErrNotChat, timeout)
+    }
+    return fmt.Errorf("handshake: %s: %w", op, err)
+}
+
+// readLine reads one LF-terminated line a byte at a time, so nothing after it is consumed.
+func readLine(r io.Reader) (string, error) {
+    var (
+        line []byte
+        b    [1]byte
+    )
+    for {
+        n, err := r.Read(b[:])
+        if n == 1 {
+            if b[0] == '\n' {
+                return strings.TrimSuffix(string(line), "\r"), nil
+            }
+            if len(line) == MaxHelloLength {
+                return "", fmt.Errorf("%w: hello longer than %d bytes", ErrNotChat, MaxHelloLength)
+            }
+            line = append(line, b[0])
+        }
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                err = io.ErrUnexpectedEOF
+            }
+            return "", err
+        }
+    }
+}
+
+func (h Hello) withDefaults() Hello {
+    if h.Version == 0 {
+        h.Version = Version
+    }
+    if h.MinVersion == 0 {
+        h.MinVersion = MinVersion
+    }
+    if h.MaxFrame == 0 {
+        h.MaxFrame = DefaultMaxFrame
+    }
+    return h
+}
+
+// Encode returns the hello line for h, including its LF. Zero fields take their defaults.
+func Encode(h Hello) ([]byte, error) {
+    h = h.withDefaults()
+    if err := h.validate(); err != nil {
+        return nil, err
+    }
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s/%d minver=%d frame=%d", Magic, h.Version, h.MinVersion, h.MaxFrame)
+    if len(h.Caps) > 0 {
+        b.WriteString(" caps=" + url.QueryEscape(strings.Join(h.Caps, ",")))
+    }
+    if h.Nickname != "" {
+        b.WriteString(" nick=" + url.QueryEscape(h.Nickname))
+    }
+    if b.Len() > MaxHelloLength {
+        return nil, fmt.Errorf("handshake: hello longer than %d bytes", MaxHelloLength)
+    }
+    b.WriteByte('\n')
+    return []byte(b.String()), nil
+}
+
+// Parse decodes a hello line (without its LF). Anything that is not a well-formed hello
+// returns an error wrapping ErrNotChat.
+func Parse(line string) (Hello, error) {
+    fields := strings.Fields(line)
+    if len(fields) == 0 || !strings.HasPrefix(fields[0], Magic+"/") {
+        return Hello{}, fmt.Errorf("%w: unexpected greeting %q", ErrNotChat, truncate(line, 32))
+    }
+    var (
+        h   Hello
+        err error
+    )
+    if h.Version, err = strconv.Atoi(strings.TrimPrefix(fields[0], Magic+"/")); err != nil || h.Version <= 0 {
+        return Hello{}, fmt.Errorf("%w: bad version in %q", ErrNotChat, fields[0])
+    }
+    for _, f := range fields[1:] {
+        key, raw, ok := strings.Cut(f, "=")
+        if !ok {
+            return Hello{}, fmt.Errorf("%w: malformed field %q", ErrNotChat, truncate(f, 32))
+        }
+        value, err := url.QueryUnescape(raw)
+        if err != nil {
+            return Hello{}, fmt.Errorf("%w: malformed field %q", ErrNotChat, truncate(f, 32))
+        }
+        switch key {
+        case "minver":
+            h.MinVersion, err = strconv.Atoi(value)
+        case "frame":
+            h.MaxFrame, err = strconv.Atoi(value)
+        case "caps":
+            if value != "" {
+                h.Caps = strings.Split(value, ",")
+            }
+        case "nick":
+            h.Nickname = value
+        }
+        if err != nil {
+            return Hello{}, fmt.Errorf("%w: malformed field %q", ErrNotChat, truncate(f, 32))
+        }
+    }
+    if h.MinVersion == 0 {
+        h.MinVersion = h.Version
+    }
+    if h.MaxFrame == 0 {
+        h.MaxFrame = DefaultMaxFrame
+    }
+    if err := h.validate(); err != nil {
+        return Hello{}, fmt.Errorf("%w: %v", ErrNotChat, err)
+    }
+    return h, nil
+}
+
+func (h Hello) validate() error {
+    if h.MinVersion <= 0 || h.MinVersion > h.Version {
+        return fmt.Errorf("handshake: invalid version range %d-%d", h.MinVersion, h.Version)
+    }
+    if h.MaxFrame < MinFrame {
+        return fmt.Errorf("handshake: frame limit %d below %d", h.MaxFrame, MinFrame)
+    }
+    if len(h.Nickname) > MaxNicknameLength {
+        return fmt.Errorf("handshake: nickname longer than %d bytes", MaxNicknameLength)
+    }
+    if !utf8.ValidString(h.Nickname) || strings.IndexFunc(h.Nickname, unicode.IsControl) >= 0 {
+        return errors.New("handshake: nickname must be UTF-8 without control characters")
+    }
+    for _, c := range h.Caps {
+        if !validCap(c) {
+            return fmt.Errorf("handshake: invalid capability %q", c)
+        }
+    }
+    return nil
+}
+
+func validCap(c string) bool {
+    if c == "" {
+        return false
+    }
+    for _, r := range c {
+        if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+            return false
+        }
+    }
+    return true
+}
+
+// negotiate combines both hellos; local has its defaults filled in.
+func negotiate(local, peer Hello) (Result, error) {
+    v := min(local.Version, peer.Version)
+    if v < max(local.MinVersion, peer.MinVersion) {
+        return Result{}, fmt.Errorf("%w: local speaks %d-%d, peer %d-%d",
+            ErrVersion, local.MinVersion, local.Version, peer.MinVersion, peer.Version)
+    }
+    res := Result{Peer: peer, Version: v, MaxFrame: min(local.MaxFrame, peer.MaxFrame)}
+    peerCaps := Result{Caps: peer.Caps}
+    for _, c := range local.Caps {
+        if peerCaps.Has(c) {
+            res.Caps = append(res.Caps, c)
+        }
+    }
+    return res, nil
+}
+
+func truncate(s string, n int) string {
+    if len(s) > n {
+        return s[:n] + "..."
+    }
+    return s
+}
@@ -0,0 +1,191 @@
+package handshake
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+type result struct {
+    res Result
+    err error
+}
+
+// runPair runs Run on both ends of a pipe.
+func runPair(t *testing.T, a, b Hello) (result, result) {
+    t.Helper()
+    ca, cb := net.Pipe()
+    t.Cleanup(func() { ca.Close(); cb.Close() })
+    ch := make(chan result, 1)
+    go func() {
+        res, err := Run(context.Background(), cb, b, time.Second)
+        ch <- result{res, err}
+    }()
+    res, err := Run(context.Background(), ca, a, time.Second)
+    return result{res, err}, <-ch
+}
+
+// runAgainst runs Run against a peer that drains the local hello, sends raw and, if hangUp
+// is set, closes its end.
+func runAgainst(t *testing.T, raw string, hangUp bool, timeout time.Duration) (Result, error) {
+    t.Helper()
+    local, peer := net.Pipe()
+    t.Cleanup(func() { local.Close(); peer.Close() })
+    go func() {
+        if _, err := bufio.NewReader(peer).ReadString('\n'); err != nil {
+            return
+        }
+        peer.Write([]byte(raw))
+        if hangUp {
+            peer.Close()
+        }
+    }()
+    return Run(context.Background(), local, Hello{Nickname: "me"}, timeout)
+}
+
+func TestRun(t *testing.T) {
+    ra, rb := runPair(t,
+        Hello{Nickname: "alice", Caps: []string{"binary", "e2e", "receipts"}, MaxFrame: 4096},
+        Hello{Nickname: "bob ✓", Caps: []string{"receipts", "binary"}, Version: 3, MinVersion: 1})
+    if ra.err != nil || rb.err != nil {
+        t.Fatalf("Run: %v / %v", ra.err, rb.err)
+    }
+    if ra.res.Peer.Nickname != "bob ✓" || rb.res.Peer.Nickname != "alice" {
+        t.Errorf("nicknames %q / %q", ra.res.Peer.Nickname, rb.res.Peer.Nickname)
+    }
+    for _, r := range []Result{ra.res, rb.res} {
+        if r.Version != 1 {
+            t.Errorf("version %d, want 1", r.Version)
+        }
+        if r.MaxFrame != 4096 {
+            t.Errorf("MaxFrame %d, want the smaller 4096", r.MaxFrame)
+        }
+        if r.Has("e2e") || !r.Has("binary") || !r.Has("receipts") {
+            t.Errorf("caps %q, want binary and receipts only", r.Caps)
+        }
+    }
+    // Common capabilities are listed in local order.
+    if strings.Join(ra.res.Caps, ",") != "binary,receipts" || strings.Join(rb.res.Caps, ",") != "receipts,binary" {
+        t.Errorf("caps %q / %q", ra.res.Caps, rb.res.Caps)
+    }
+    if ra.res.LocalLine != rb.res.PeerLine || ra.res.PeerLine != rb.res.LocalLine {
+        t.Error("hello lines differ between the ends")
+    }
+}
+
+func TestRunLeavesTrailingBytes(t *testing.T) {
+    local, peer := net.Pipe()
+    defer local.Close()
+    defer peer.Close()
+    go func() {
+        bufio.NewReader(peer).ReadString('\n')
+        peer.Write([]byte("BTCHAT/1 nick=x\r\nfirst message\n"))
+    }()
+    res, err := Run(context.Background(), local, Hello{}, time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if res.PeerLine != "BTCHAT/1 nick=x" || res.Peer.MinVersion != 1 || res.Peer.MaxFrame != DefaultMaxFrame {
+        t.Errorf("peer %+v from %q", res.Peer, res.PeerLine)
+    }
+    line, err := bufio.NewReader(local).ReadString('\n')
+    if err != nil || line != "first message\n" {
+        t.Errorf("after the hello: %q, %v", line, err)
+    }
+}
+
+func TestRunNotChat(t *testing.T) {
+    tests := []struct {
+        name string
+        raw  string
+    }{
+        {"bad magic", "AT+BRSF=0\r\n"},
+        {"lower-case magic", "btchat/1 nick=x\n"},
+        {"bad version", "BTCHAT/x nick=x\n"},
+        {"malformed field", "BTCHAT/1 nick\n"},
+        {"bad frame", "BTCHAT/1 frame=12\n"},
+        {"bad capability", "BTCHAT/1 caps=Binary\n"},
+        {"oversize hello", "BTCHAT/1 nick=" + strings.Repeat("x", MaxHelloLength) + "\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := runAgainst(t, tt.raw, true, time.Second); !errors.Is(err, ErrNotChat) {
+                t.Fatalf("got %v, want ErrNotChat", err)
+            }
+        })
+    }
+
+    // A peer hanging up without a hello is an I/O error, not a verdict on the peer.
+    if _, err := runAgainst(t, "", true, time.Second); err == nil || errors.Is(err, ErrNotChat) {
+        t.Errorf("hang-up: got %v, want an I/O error", err)
+    }
+}
+
+func TestRunVersion(t *testing.T) {
+    _, err := runAgainst(t, "BTCHAT/3 minver=2\n", false, time.Second)
+    if !errors.Is(err, ErrVersion) {
+        t.Fatalf("got %v, want ErrVersion", err)
+    }
+    if errors.Is(err, ErrNotChat) {
+        t.Error("a chat client of another version reported as not a chat client")
+    }
+    // A newer peer that still speaks version 1 is fine.
+    res, err := runAgainst(t, "BTCHAT/3 minver=1 future=yes\n", false, time.Second)
+    if err != nil || res.Version != 1 || res.Peer.Version != 3 {
+        t.Errorf("got version %d (peer %d), %v; want 1", res.Version, res.Peer.Version, err)
+    }
+}
+
+func TestRunSilentPeer(t *testing.T) {
+    start := time.Now()
+    _, err := runAgainst(t, "", false, 100*time.Millisecond)
+    if !errors.Is(err, ErrNotChat) {
+        t.Fatalf("got %v, want ErrNotChat", err)
+    }
+    if d := time.Since(start); d > 2*time.Second {
+        t.Errorf("timed out after %s", d)
+    }
+}
+
+func TestRunCancel(t *testing.T) {
+    local, peer := net.Pipe()
+    defer local.Close()
+    defer peer.Close()
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(50*time.Millisecond, cancel)
+    if _, err := Run(ctx, local, Hello{}, time.Minute); !errors.Is(err, context.Canceled) {
+        t.Fatalf("got %v, want context.Canceled", err)
+    }
+}
+
+func TestEncodeParse(t *testing.T) {
+    h := Hello{Version: 2, MinVersion: 1, Nickname: "a b=c%", Caps: []string{"binary", "e2e"}, MaxFrame: 1024}
+    line, err := Encode(h)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := Parse(strings.TrimSuffix(string(line), "\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Version != 2 || got.MinVersion != 1 || got.Nickname != h.Nickname || got.MaxFrame != 1024 || strings.Join(got.Caps, ",") != "binary,e2e" {
+        t.Errorf("round trip: %+v from %q", got, line)
+    }
+
+    for _, bad := range []Hello{
+        {MinVersion: 2, Version: 1},
+        {MaxFrame: MinFrame - 1},
+        {Nickname: strings.Repeat("n", MaxNicknameLength+1)},
+        {Nickname: "a\nb"},
+        {Caps: []string{""}},
+        {Caps: []string{"a b"}},
+    } {
+        if _, err := Encode(bad); err == nil {
+            t.Errorf("Encode(%+v): no error", bad)
+        }
+    }
+}
@@ -0,0 +1,296 @@
+// Package outbox keeps chat messages on disk until the peer confirms them, so they survive a
+// dropped link or a restart and can be sent again on the next connection.
+//
+// A Store is a directory with one log file per peer, named after its Bluetooth address. The
+// log holds one JSON object per line:
+//
+//    {"op":"add","id":"…","time":1700000000000,"text":"…"}   message queued for the peer
+//    {"op":"ack","id":"…"}                                    peer confirmed delivery
+//    {"op":"recv","id":"…"}                                   message received from the peer
+//
+// Pending returns the queued messages not yet acknowledged, in the order they were added; the
+// "recv" entries let the receiving side recognize messages the peer sends again because its
+// receipt got lost (Seen). Logs are rewritten without the finished entries when they grow.
+package outbox
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "bluetooth-chat/internal/delivery"
+)
+
+// SeenSize is the number of received message IDs remembered per peer for deduplication.
+const SeenSize = 1024
+
+// compactSlack is how many finished log lines are tolerated before a log is rewritten.
+const compactSlack = 256
+
+// Store is a directory of per-peer outboxes.
+type Store struct {
+    dir string
+}
+
+// OpenStore returns the store in dir, creating the directory (mode 0700) if needed.
+func OpenStore(dir string) (*Store, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, fmt.Errorf("outbox: %w", err)
+    }
+    return &Store{dir: dir}, nil
+}
+
+// Peer opens the outbox of the peer with the given Bluetooth address. Only one Outbox per
+// peer may be open at a time.
+func (s *Store) Peer(mac string) (*Outbox, error) {
+    if mac == "" {
+        return nil, errors.New("outbox: empty peer address")
+    }
+    name := strings.ReplaceAll(strings.ToUpper(mac), ":", "-") + ".log"
+    return Open(filepath.Join(s.dir, name))
+}
+
+type record struct {
+    Op   string `json:"op"`
+    ID   string `json:"id"`
+    Time int64  `json:"time,omitempty"` // Unix ms
+    Text string `json:"text,omitempty"`
+}
+
+// Outbox is the persistent message queue of one peer. It is safe for concurrent use.
+type Outbox struct {
+    path string
+
+    mu      sync.Mutex
+    f       *os.File
+    pending []delivery.Message // not acknowledged, in order
+    index   map[string]int     // ID -> position in pending
+    seen    []string           // received IDs, oldest first, at most SeenSize
+    seenSet map[string]bool
+    lines   int // records in the log file
+}
+
+// Open opens (creating if needed) the outbox log at path and loads its state.
+func Open(path string) (*Outbox, error) {
+    o := &Outbox{path: path, index: make(map[string]int), seenSet: make(map[string]bool)}
+    data, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("outbox: %w", err)
+    }
+    if err := o.load(data); err != nil {
+        return nil, err
+    }
+    if err := o.compactLocked(); err != nil {
+        return nil, err
+    }
+    return o, nil
+}
+
+// load replays the log. A truncated last line (crash while appending) is ignored.
+func (o *Outbox) load(data []byte) error {
+    // Every record is written with its LF in one Write; a last line without LF is torn.
+    data = data[:bytes.LastIndexByte(data, '\n')+1]
+    acked := make(map[string]bool)
+    var added []delivery.Message
+    sc := bufio.NewScanner(bytes.NewReader(data))
+    sc.Buffer(nil, 1<<24)
+    for line := 1; sc.Scan(); line++ {
+        if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+            continue
+        }
+        var r record
+        if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+            return fmt.Errorf("outbox: %s:%d: %w", o.path, line, err)
+        }
+        switch r.Op {
+        case "add":
+            added = append(added, delivery.Message{ID: r.ID, Time: time.UnixMilli(r.Time), Text: r.Text})
+        case "ack":
+            acked[r.ID] = true
+        case "recv":
+            o.rememberLocked(r.ID)
+        default:
+            return fmt.Errorf("outbox: %s:%d: unknown op %q", o.path, line, r.Op)
+        }
+    }
+    if err := sc.Err(); err != nil {
+        return fmt.Errorf("outbox: %s: %w", o.path, err)
+    }
+    for _, m := range added {
+        if _, dup := o.index[m.ID]; !dup && !acked[m.ID] {
+            o.index[m.ID] = len(o.pending)
+            o.pending = append(o.pending, m)
+        }
+    }
+    return nil
+}
+
+// Add queues m. It is written to disk before Add returns; adding an ID already pending is a
+// no-op.
+func (o *Outbox) Add(m delivery.Message) error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if _, ok := o.index[m.ID]; ok {
+        return nil
+    }
+    if err := o.appendLocked(record{Op: "add", ID: m.ID, Time: m.Time.UnixMilli(), Text: m.Text}); err != nil {
+        return err
+    }
+    o.index[m.ID] = len(o.pending)
+    o.pending = append(o.pending, m)
+    return nil
+}
+
+// Ack removes the message with the given ID from the queue. Unknown IDs are ignored.
+func (o *Outbox) Ack(id string) error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    i, ok := o.index[id]
+    if !ok {
+        return nil
+    }
+    if err := o.appendLocked(record{Op: "ack", ID: id}); err != nil {
+        return err
+    }
+    o.pending = append(o.pending[:i], o.pending[i+1:]...)
+    delete(o.index, id)
+    for j := i; j < len(o.pending); j++ {
+        o.index[o.pending[j].ID] = j
+    }
+    return o.maybeCompactLocked()
+}
+
+// Pending returns the messages not acknowledged yet, oldest first.
+func (o *Outbox) Pending() []delivery.Message {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return append([]delivery.Message(nil), o.pending...)
+}
+
+// Seen records that the message with the given ID was received from the peer and reports
+// whether it had been received before, in which case it should not be shown again.
+func (o *Outbox) Seen(id string) (bool, error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.seenSet[id] {
+        return true, nil
+    }
+    if err := o.appendLocked(record{Op: "recv", ID: id}); err != nil {
+        return false, err
+    }
+    o.rememberLocked(id)
+    return false, o.maybeCompactLocked()
+}
+
+func (o *Outbox) rememberLocked(id string) {
+    if o.seenSet[id] {
+        return
+    }
+    o.seen = append(o.seen, id)
+    o.seenSet[id] = true
+    if len(o.seen) > SeenSize {
+        delete(o.seenSet, o.seen[0])
+        o.seen = o.seen[1:]
+    }
+}
+
+// Close closes the log file.
+func (o *Outbox) Close() error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.f == nil {
+        return nil
+    }
+    err := o.f.Close()
+    o.f = nil
+    if err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    return nil
+}
+
+// appendLocked writes r to the log and syncs it.
+func (o *Outbox) appendLocked(r record) error {
+    if o.f == nil {
+        return errors.New("outbox: closed")
+    }
+    b, err := json.Marshal(r)
+    if err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if _, err := o.f.Write(append(b, '\n')); err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if err := o.f.Sync(); err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    o.lines++
+    return nil
+}
+
+func (o *Outbox) maybeCompactLocked() error {
+    if o.lines <= len(o.pending)+len(o.seen)+compactSlack {
+        return nil
+    }
+    return o.compactLocked()
+}
+
+// compactLocked rewrites the log with only the live entries (temporary file + rename) and
+// reopens it for appending.
+func (o *Outbox) compactLocked() error {
+    var b bytes.Buffer
+    enc := json.NewEncoder(&b)
+    for _, id := range o.seen {
+        enc.Encode(record{Op: "recv", ID: id})
+    }
+    for _, m := range o.pending {
+        enc.Encode(record{Op: "add", ID: m.ID, Time: m.Time.UnixMilli(), Text: m.Text})
+    }
+    tmp := o.path + ".tmp"
+    if err := writeSync(tmp, b.Bytes()); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, o.path); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if o.f != nil {
+        o.f.Close()
+    }
+    f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        o.f = nil
+        return fmt.Errorf("outbox: %w", err)
+    }
+    o.f = f
+    o.lines = len(o.seen) + len(o.pending)
+    return nil
+}
+
+func writeSync(path string, data []byte) error {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+    if err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return fmt.Errorf("outbox: %w", err)
+    }
+    if err := f.Close(); err != nil {
+        return fmt.Errorf("outbox: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,215 @@
+package outbox
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "bluetooth-chat/internal/delivery"
+)
+
+func msg(id, text string) delivery.Message {
+    return delivery.Message{ID: id, Time: time.UnixMilli(1700000000000), Text: text}
+}
+
+func open(t *testing.T, path string) *Outbox {
+    t.Helper()
+    o, err := Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { o.Close() })
+    return o
+}
+
+func ids(ms []delivery.Message) string {
+    var b bytes.Buffer
+    for i, m := range ms {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        b.WriteString(m.ID)
+    }
+    return b.String()
+}
+
+func lines(t *testing.T, path string) int {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return bytes.Count(data, []byte{'\n'})
+}
+
+func TestAddAck(t *testing.T) {
+    o := open(t, filepath.Join(t.TempDir(), "peer.log"))
+    for _, id := range []string{"a", "b", "c", "d"} {
+        if err := o.Add(msg(id, "text "+id)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := o.Add(msg("b", "again")); err != nil {
+        t.Fatal(err)
+    }
+    for _, id := range []string{"b", "x", "d"} {
+        if err := o.Ack(id); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := o.Pending()
+    if ids(got) != "a,c" {
+        t.Fatalf("pending %s, want a,c", ids(got))
+    }
+    if got[1] != msg("c", "text c") {
+        t.Errorf("got %+v", got[1])
+    }
+    // Indexes stay right after removals from the middle.
+    if err := o.Add(msg("e", "")); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Ack("c"); err != nil {
+        t.Fatal(err)
+    }
+    if got := ids(o.Pending()); got != "a,e" {
+        t.Errorf("pending %s, want a,e", got)
+    }
+}
+
+func TestReopen(t *testing.T) {
+    s, err := OpenStore(filepath.Join(t.TempDir(), "outbox"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    o, err := s.Peer("aa:bb:cc:dd:ee:ff")
+    if err != nil {
+        t.Fatal(err)
+    }
+    o.Add(msg("1", "one\ntwo"))
+    o.Add(msg("2", "two"))
+    o.Add(msg("3", "three"))
+    o.Ack("2")
+    if dup, err := o.Seen("r1"); dup || err != nil {
+        t.Fatalf("first Seen: %v, %v", dup, err)
+    }
+    if err := o.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err := o.Add(msg("4", "")); err == nil {
+        t.Error("Add after Close succeeded")
+    }
+
+    // The address is matched case-insensitively.
+    o, err = s.Peer("AA:BB:CC:DD:EE:FF")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer o.Close()
+    got := o.Pending()
+    if ids(got) != "1,3" || got[0] != msg("1", "one\ntwo") {
+        t.Errorf("pending after reopening: %+v", got)
+    }
+    if dup, err := o.Seen("r1"); !dup || err != nil {
+        t.Errorf("Seen after reopening: %v, %v; want a repeat", dup, err)
+    }
+    if _, err := s.Peer(""); err == nil {
+        t.Error("empty address accepted")
+    }
+}
+
+func TestSeen(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "peer.log")
+    o := open(t, path)
+    for i := 0; i < SeenSize+1; i++ {
+        if dup, err := o.Seen(fmt.Sprint(i)); dup || err != nil {
+            t.Fatalf("Seen(%d): %v, %v", i, dup, err)
+        }
+    }
+    if dup, _ := o.Seen("5"); !dup {
+        t.Error("recent ID not recognized")
+    }
+    // Only the last SeenSize IDs are remembered.
+    if dup, _ := o.Seen("0"); dup {
+        t.Error("oldest ID still remembered")
+    }
+}
+
+func TestTornLastLine(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "peer.log")
+    o := open(t, path)
+    o.Add(msg("a", "kept"))
+    o.Close()
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString(`{"op":"add","id":"b","te`)
+    f.Close()
+
+    o = open(t, path)
+    if got := ids(o.Pending()); got != "a" {
+        t.Fatalf("pending %s, want a", got)
+    }
+    // The torn line is gone, so later records start on a line of their own.
+    o.Add(msg("c", "after"))
+    o.Close()
+    o = open(t, path)
+    if got := ids(o.Pending()); got != "a,c" {
+        t.Errorf("pending %s, want a,c", got)
+    }
+}
+
+func TestCorruptLine(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "peer.log")
+    if err := os.WriteFile(path, []byte("{\"op\":\"add\",\"id\":\"a\"}\nnot json\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := Open(path); err == nil {
+        t.Error("corrupt line in the middle accepted")
+    }
+    if err := os.WriteFile(path, []byte("{\"op\":\"drop\",\"id\":\"a\"}\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := Open(path); err == nil {
+        t.Error("unknown op accepted")
+    }
+}
+
+func TestCompact(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "peer.log")
+    o := open(t, path)
+    o.Add(msg("keep", "still pending"))
+    o.Seen("r")
+    for i := 0; i < 2*compactSlack; i++ {
+        id := fmt.Sprint("m", i)
+        if err := o.Add(msg(id, "")); err != nil {
+            t.Fatal(err)
+        }
+        if err := o.Ack(id); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Without compaction the log would hold 2 + 4*compactSlack lines.
+    if n := lines(t, path); n > 2+compactSlack+1 {
+        t.Errorf("log has %d lines after %d finished messages", n, 2*compactSlack)
+    }
+    o.Close()
+
+    o = open(t, path)
+    if got := ids(o.Pending()); got != "keep" {
+        t.Errorf("pending %s after compaction, want keep", got)
+    }
+    if dup, _ := o.Seen("r"); !dup {
+        t.Error("received ID lost by compaction")
+    }
+    // Open compacts as well: only live entries remain.
+    if n := lines(t, path); n != 2 {
+        t.Errorf("log has %d lines after reopening, want 2", n)
+    }
+    if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+        t.Errorf("temporary file left behind: %v", err)
+    }
+}
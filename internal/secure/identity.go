@@ -0,0 +1,106 @@
+package secure
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+const pemType = "PRIVATE KEY"
+
+// Identity is a long-term Ed25519 key pair identifying this end across sessions.
+type Identity struct {
+    key ed25519.PrivateKey
+}
+
+// NewIdentity generates a fresh identity.
+func NewIdentity() (*Identity, error) {
+    _, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    return &Identity{key: key}, nil
+}
+
+// LoadIdentity reads the identity stored at path (PKCS#8, PEM), creating and saving a new one
+// with mode 0600 if the file does not exist. created reports whether that happened.
+func LoadIdentity(path string) (id *Identity, created bool, err error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        if id, err = NewIdentity(); err != nil {
+            return nil, false, err
+        }
+        if err = id.save(path); err != nil {
+            return nil, false, err
+        }
+        return id, true, nil
+    }
+    if err != nil {
+        return nil, false, fmt.Errorf("secure: %w", err)
+    }
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != pemType {
+        return nil, false, fmt.Errorf("secure: %s: no %s PEM block", path, pemType)
+    }
+    k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, false, fmt.Errorf("secure: %s: %w", path, err)
+    }
+    key, ok := k.(ed25519.PrivateKey)
+    if !ok {
+        return nil, false, fmt.Errorf("secure: %s: not an Ed25519 key", path)
+    }
+    return &Identity{key: key}, false, nil
+}
+
+// save writes the key to path without replacing an existing file.
+func (id *Identity) save(path string) error {
+    der, err := x509.MarshalPKCS8PrivateKey(id.key)
+    if err != nil {
+        return fmt.Errorf("secure: %w", err)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return fmt.Errorf("secure: %w", err)
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+    if err != nil {
+        return fmt.Errorf("secure: %w", err)
+    }
+    if err := pem.Encode(f, &pem.Block{Type: pemType, Bytes: der}); err != nil {
+        f.Close()
+        return fmt.Errorf("secure: %s: %w", path, err)
+    }
+    if err := f.Close(); err != nil {
+        return fmt.Errorf("secure: %s: %w", path, err)
+    }
+    return nil
+}
+
+// Public returns the identity's public key.
+func (id *Identity) Public() ed25519.PublicKey {
+    return id.key.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint returns the Fingerprint of the identity's public key.
+func (id *Identity) Fingerprint() string { return Fingerprint(id.Public()) }
+
+// Fingerprint renders the first 128 bits of SHA-256(pub) as eight groups of four hex digits,
+// e.g. "3f2a 9c01 ...", short enough to read aloud for out-of-band verification.
+func Fingerprint(pub ed25519.PublicKey) string {
+    sum := sha256.Sum256(pub)
+    h := hex.EncodeToString(sum[:16])
+    groups := make([]string, 0, len(h)/4)
+    for i := 0; i < len(h); i += 4 {
+        groups = append(groups, h[i:i+4])
+    }
+    return strings.Join(groups, " ")
+}
@@ -0,0 +1,127 @@
+package secure
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// ErrKeyChanged is returned by KnownPeers.Check when a peer presents an identity other than
+// the one pinned for its address: either the peer was reinstalled or someone is impersonating it.
+var ErrKeyChanged = errors.New("secure: peer identity changed")
+
+// KnownPeers pins peer fingerprints per Bluetooth address (trust on first use). The file holds
+// one "MAC fingerprint" line per peer; blank lines and lines starting with '#' are ignored.
+type KnownPeers struct {
+    path string
+
+    mu    sync.Mutex
+    peers map[string]string // upper-case MAC -> fingerprint
+}
+
+// OpenKnownPeers loads the pins from path; a missing file is an empty store.
+func OpenKnownPeers(path string) (*KnownPeers, error) {
+    k := &KnownPeers{path: path, peers: make(map[string]string)}
+    f, err := os.Open(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return k, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    for line := 1; sc.Scan(); line++ {
+        text := strings.TrimSpace(sc.Text())
+        if text == "" || strings.HasPrefix(text, "#") {
+            continue
+        }
+        mac, fp, ok := strings.Cut(text, " ")
+        if !ok {
+            return nil, fmt.Errorf("secure: %s:%d: want \"MAC fingerprint\"", path, line)
+        }
+        k.peers[strings.ToUpper(mac)] = strings.TrimSpace(fp)
+    }
+    if err := sc.Err(); err != nil {
+        return nil, fmt.Errorf("secure: %s: %w", path, err)
+    }
+    return k, nil
+}
+
+// Lookup returns the fingerprint pinned for mac.
+func (k *KnownPeers) Lookup(mac string) (string, bool) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    fp, ok := k.peers[strings.ToUpper(mac)]
+    return fp, ok
+}
+
+// Check compares fingerprint with the one pinned for mac. An unknown peer is pinned and saved
+// (pinned is true); a different fingerprint yields ErrKeyChanged and leaves the pin untouched.
+// Use Forget to accept a new key after verifying it out of band.
+func (k *KnownPeers) Check(mac, fingerprint string) (pinned bool, err error) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    mac = strings.ToUpper(mac)
+    if fp, ok := k.peers[mac]; ok {
+        if fp != fingerprint {
+            return false, fmt.Errorf("%w: %s pinned %s, got %s", ErrKeyChanged, mac, fp, fingerprint)
+        }
+        return false, nil
+    }
+    k.peers[mac] = fingerprint
+    if err := k.save(); err != nil {
+        delete(k.peers, mac)
+        return false, err
+    }
+    return true, nil
+}
+
+// Forget removes the pin for mac, so the next Check pins whatever key the peer presents.
+func (k *KnownPeers) Forget(mac string) error {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    mac = strings.ToUpper(mac)
+    fp, ok := k.peers[mac]
+    if !ok {
+        return nil
+    }
+    delete(k.peers, mac)
+    if err := k.save(); err != nil {
+        k.peers[mac] = fp
+        return err
+    }
+    return nil
+}
+
+// save rewrites the file atomically (temporary file + rename).
+func (k *KnownPeers) save() error {
+    if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+        return fmt.Errorf("secure: %w", err)
+    }
+    macs := make([]string, 0, len(k.peers))
+    for mac := range k.peers {
+        macs = append(macs, mac)
+    }
+    slices.Sort(macs)
+    var b strings.Builder
+    b.WriteString("# peer identities pinned on first use: MAC fingerprint\n")
+    for _, mac := range macs {
+        fmt.Fprintf(&b, "%s %s\n", mac, k.peers[mac])
+    }
+    tmp := k.path + ".tmp"
+    if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+        return fmt.Errorf("secure: %w", err)
+    }
+    if err := os.Rename(tmp, k.path); err != nil {
+        os.Remove(tmp)
+        return fmt.Errorf("secure: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,62 @@
+package secure
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+)
+
+func TestKnownPeers(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "known_peers")
+    k, err := OpenKnownPeers(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    const mac = "AA:BB:CC:DD:EE:FF"
+    fp1 := Fingerprint(newID(t).Public())
+    fp2 := Fingerprint(newID(t).Public())
+
+    if pinned, err := k.Check(mac, fp1); !pinned || err != nil {
+        t.Fatalf("first contact: pinned %v, %v", pinned, err)
+    }
+    if pinned, err := k.Check("aa:bb:cc:dd:ee:ff", fp1); pinned || err != nil {
+        t.Fatalf("same key: pinned %v, %v", pinned, err)
+    }
+    if _, err := k.Check(mac, fp2); !errors.Is(err, ErrKeyChanged) {
+        t.Fatalf("other key: got %v, want ErrKeyChanged", err)
+    }
+
+    // The pin survives a restart, and the rejected key did not replace it.
+    k, err = OpenKnownPeers(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fp, ok := k.Lookup(mac); !ok || fp != fp1 {
+        t.Fatalf("after reopen: %q, %v; want %q", fp, ok, fp1)
+    }
+    if _, err := k.Check(mac, fp2); !errors.Is(err, ErrKeyChanged) {
+        t.Fatalf("after reopen: got %v, want ErrKeyChanged", err)
+    }
+
+    if err := k.Forget(mac); err != nil {
+        t.Fatal(err)
+    }
+    if pinned, err := k.Check(mac, fp2); !pinned || err != nil {
+        t.Fatalf("after Forget: pinned %v, %v", pinned, err)
+    }
+}
+
+func TestLoadIdentity(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "dir", "identity.pem")
+    id, created, err := LoadIdentity(path)
+    if err != nil || !created {
+        t.Fatalf("first load: created %v, %v", created, err)
+    }
+    again, created, err := LoadIdentity(path)
+    if err != nil || created {
+        t.Fatalf("second load: created %v, %v", created, err)
+    }
+    if again.Fingerprint() != id.Fingerprint() {
+        t.Error("reloaded identity differs")
+    }
+}
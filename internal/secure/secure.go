@@ -0,0 +1,308 @@
+// Package secure adds an end-to-end encrypted session layer on top of the RFCOMM transport,
+// independent of BlueZ link encryption (which depends on how the devices were paired).
+//
+// Each end has a long-term Ed25519 identity (see LoadIdentity). Handshake runs an
+// authenticated ephemeral key exchange over the connection, symmetric like the chat hello,
+// so neither end has to speak first:
+//
+//  1. both send  "BTE1" || ephemeral X25519 public key (32 bytes)
+//  2. both send  identity public key (32) || Ed25519 signature (64) over
+//     transcript || own ephemeral
+//
+// where the transcript is label || lower ephemeral || higher ephemeral, followed by the
+// messages both ends exchanged before the key exchange (the chat hellos), each with a 2-byte
+// length and in the same order as the ephemeral keys. A negotiation tampered with on the way,
+// e.g. a hello stripped of the "e2e" capability, therefore makes the handshake fail.
+//
+// Both sides then derive one ChaCha20-Poly1305 key per direction with HKDF-SHA256 from the
+// X25519 shared secret, salted with the transcript. The signatures bind the peer's identity
+// to this session; whether that identity is the expected one is up to the caller, e.g. by
+// pinning its Fingerprint per peer address with KnownPeers (trust on first use) and comparing
+// fingerprints out of band.
+//
+// Afterwards Conn carries data in records: a 2-byte big-endian length followed by that many
+// bytes of ciphertext (plaintext plus a 16-byte tag), with a per-direction counter as nonce.
+// A record that fails authentication breaks the connection (ErrAuth).
+package secure
+
+import (
+    "bytes"
+    "context"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/ed25519"
+    "crypto/hkdf"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+    // Capability is the handshake capability announcing support for this layer.
+    Capability = "e2e"
+
+    // DefaultTimeout bounds Handshake when it is given no timeout.
+    DefaultTimeout = 10 * time.Second
+
+    // MaxRecordPayload is the most plaintext one record carries; longer writes are split.
+    MaxRecordPayload = 16 * 1024
+)
+
+const (
+    helloMagic = "BTE1"
+    label      = "bluetooth-chat e2e v1"
+)
+
+var (
+    // ErrHandshake is returned when the peer's key exchange messages are malformed or
+    // their signature does not verify.
+    ErrHandshake = errors.New("secure: handshake failed")
+    // ErrAuth is returned by Read when a record fails authentication; the connection must
+    // be closed.
+    ErrAuth = errors.New("secure: message authentication failed")
+)
+
+// Transport is the connection Handshake runs on; *transport.Conn and net.Conn satisfy it.
+type Transport interface {
+    io.ReadWriter
+    SetDeadline(t time.Time) error
+}
+
+// Conn is an encrypted session. Read and Write may be called concurrently with each other.
+type Conn struct {
+    t       Transport
+    peerKey ed25519.PublicKey
+
+    rmu   sync.Mutex
+    rAEAD cipher.AEAD
+    rSeq  uint64
+    rBuf  []byte // decrypted data not yet returned by Read
+    rErr  error  // sticky read error
+    rRec  []byte
+
+    wmu   sync.Mutex
+    wAEAD cipher.AEAD
+    wSeq  uint64
+    wBuf  []byte
+}
+
+// Handshake authenticates both ends with their identities and returns the encrypted session.
+// local and peer are what this end sent and received before the key exchange; both ends must
+// pass the same pair (each from its own point of view), or the handshake fails. The exchange
+// must complete within timeout (DefaultTimeout if 0) and before ctx ends; the transport
+// deadline is cleared again before Handshake returns.
+func Handshake(ctx context.Context, t Transport, id *Identity, local, peer []byte, timeout time.Duration) (*Conn, error) {
+    if len(local) > 0xffff || len(peer) > 0xffff {
+        return nil, fmt.Errorf("secure: handshake context longer than %d bytes", 0xffff)
+    }
+    if timeout <= 0 {
+        timeout = DefaultTimeout
+    }
+    if err := t.SetDeadline(time.Now().Add(timeout)); err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    defer t.SetDeadline(time.Time{})
+    stop := context.AfterFunc(ctx, func() { _ = t.SetDeadline(time.Now()) })
+    defer stop()
+
+    eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    ourEph := eph.PublicKey().Bytes()
+
+    // Message 1: ephemeral keys.
+    msg1 := append([]byte(helloMagic), ourEph...)
+    peer1, err := exchange(ctx, t, msg1, len(msg1))
+    if err != nil {
+        return nil, err
+    }
+    if !bytes.HasPrefix(peer1, []byte(helloMagic)) {
+        return nil, fmt.Errorf("%w: unexpected key exchange message", ErrHandshake)
+    }
+    peerEph := peer1[len(helloMagic):]
+    if bytes.Equal(peerEph, ourEph) {
+        return nil, fmt.Errorf("%w: own key reflected", ErrHandshake)
+    }
+    peerPub, err := ecdh.X25519().NewPublicKey(peerEph)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+    }
+    shared, err := eph.ECDH(peerPub)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+    }
+
+    // Message 2: identities, each signing the transcript and its own ephemeral key.
+    lowFirst := bytes.Compare(ourEph, peerEph) < 0
+    transcript := []byte(label)
+    if lowFirst {
+        transcript = append(append(transcript, ourEph...), peerEph...)
+        transcript = appendContext(appendContext(transcript, local), peer)
+    } else {
+        transcript = append(append(transcript, peerEph...), ourEph...)
+        transcript = appendContext(appendContext(transcript, peer), local)
+    }
+    sig := ed25519.Sign(id.key, append(transcript[:len(transcript):len(transcript)], ourEph...))
+    msg2 := append(append([]byte(nil), id.Public()...), sig...)
+    peer2, err := exchange(ctx, t, msg2, len(msg2))
+    if err != nil {
+        return nil, err
+    }
+    peerKey := ed25519.PublicKey(peer2[:ed25519.PublicKeySize])
+    if !ed25519.Verify(peerKey, append(transcript[:len(transcript):len(transcript)], peerEph...), peer2[ed25519.PublicKeySize:]) {
+        return nil, fmt.Errorf("%w: bad signature", ErrHandshake)
+    }
+
+    // One key per direction: "low" is the end with the lower ephemeral key.
+    keys, err := hkdf.Key(sha256.New, shared, transcript, label+" keys", 2*chacha20poly1305.KeySize)
+    if err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    lowKey, highKey := keys[:chacha20poly1305.KeySize], keys[chacha20poly1305.KeySize:]
+    sendKey, recvKey := highKey, lowKey
+    if lowFirst {
+        sendKey, recvKey = lowKey, highKey
+    }
+    c := &Conn{t: t, peerKey: append(ed25519.PublicKey(nil), peerKey...)}
+    if c.wAEAD, err = chacha20poly1305.New(sendKey); err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    if c.rAEAD, err = chacha20poly1305.New(recvKey); err != nil {
+        return nil, fmt.Errorf("secure: %w", err)
+    }
+    return c, nil
+}
+
+// appendContext appends b with its 2-byte length.
+func appendContext(dst, b []byte) []byte {
+    return append(binary.BigEndian.AppendUint16(dst, uint16(len(b))), b...)
+}
+
+// exchange sends msg while reading the peer's message of n bytes, so it also works over
+// unbuffered pipes.
+func exchange(ctx context.Context, t Transport, msg []byte, n int) ([]byte, error) {
+    sent := make(chan error, 1)
+    go func() {
+        _, err := t.Write(msg)
+        sent <- err
+    }()
+    buf := make([]byte, n)
+    if _, err := io.ReadFull(t, buf); err != nil {
+        _ = t.SetDeadline(time.Now()) // unblock the writer
+        <-sent
+        return nil, handshakeIOError(ctx, err)
+    }
+    if err := <-sent; err != nil {
+        return nil, handshakeIOError(ctx, err)
+    }
+    return buf, nil
+}
+
+func handshakeIOError(ctx context.Context, err error) error {
+    if ctx.Err() != nil {
+        return fmt.Errorf("secure: handshake: %w", ctx.Err())
+    }
+    if errors.Is(err, os.ErrDeadlineExceeded) {
+        return fmt.Errorf("%w: peer did not complete the key exchange in time", ErrHandshake)
+    }
+    return fmt.Errorf("secure: handshake: %w", err)
+}
+
+// PeerKey returns the peer's identity public key.
+func (c *Conn) PeerKey() ed25519.PublicKey { return c.peerKey }
+
+// PeerFingerprint returns the Fingerprint of the peer's identity.
+func (c *Conn) PeerFingerprint() string { return Fingerprint(c.peerKey) }
+
+// Read returns decrypted data. Record boundaries are not preserved.
+func (c *Conn) Read(p []byte) (int, error) {
+    c.rmu.Lock()
+    defer c.rmu.Unlock()
+    for len(c.rBuf) == 0 {
+        if c.rErr != nil {
+            return 0, c.rErr
+        }
+        if err := c.readRecord(); err != nil {
+            c.rErr = err
+        }
+    }
+    n := copy(p, c.rBuf)
+    c.rBuf = c.rBuf[n:]
+    return n, nil
+}
+
+// readRecord reads and decrypts one record into rBuf.
+func (c *Conn) readRecord() error {
+    var hdr [2]byte
+    if _, err := io.ReadFull(c.t, hdr[:]); err != nil {
+        return err
+    }
+    n := int(binary.BigEndian.Uint16(hdr[:]))
+    if n < c.rAEAD.Overhead() {
+        return ErrAuth
+    }
+    if cap(c.rRec) < n {
+        c.rRec = make([]byte, n)
+    }
+    rec := c.rRec[:n]
+    if _, err := io.ReadFull(c.t, rec); err != nil {
+        if errors.Is(err, io.EOF) {
+            err = io.ErrUnexpectedEOF
+        }
+        return err
+    }
+    plain, err := c.rAEAD.Open(rec[:0], nonce(c.rSeq), rec, hdr[:])
+    if err != nil {
+        return ErrAuth
+    }
+    c.rSeq++
+    c.rBuf = plain
+    return nil
+}
+
+// Write encrypts p into one or more records, each sent with a single Write.
+func (c *Conn) Write(p []byte) (int, error) {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    written := 0
+    for len(p) > 0 {
+        chunk := p[:min(len(p), MaxRecordPayload)]
+        n := len(chunk) + c.wAEAD.Overhead()
+        buf := append(c.wBuf[:0], byte(n>>8), byte(n))
+        buf = c.wAEAD.Seal(buf, nonce(c.wSeq), chunk, buf[:2])
+        c.wSeq++
+        c.wBuf = buf
+        if _, err := c.t.Write(buf); err != nil {
+            return written, err
+        }
+        written += len(chunk)
+        p = p[len(chunk):]
+    }
+    return written, nil
+}
+
+// Close closes the underlying transport if it has a Close method.
+func (c *Conn) Close() error {
+    if cl, ok := c.t.(io.Closer); ok {
+        return cl.Close()
+    }
+    return nil
+}
+
+// SetDeadline sets the deadline of the underlying transport.
+func (c *Conn) SetDeadline(t time.Time) error { return c.t.SetDeadline(t) }
+
+func nonce(seq uint64) []byte {
+    n := make([]byte, chacha20poly1305.NonceSize)
+    binary.BigEndian.PutUint64(n[4:], seq)
+    return n
+}
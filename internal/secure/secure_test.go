@@ -0,0 +1,202 @@
+package secure
+
+import (
+    "bytes"
+    "context"
+    "crypto/ecdh"
+    "crypto/rand"
+    "errors"
+    "io"
+    "net"
+    "testing"
+    "time"
+)
+
+const (
+    helloA = "BTCHAT/1 minver=1 frame=65536 caps=binary%2Ce2e nick=a"
+    helloB = "BTCHAT/1 minver=1 frame=65536 caps=binary%2Ce2e nick=b"
+)
+
+func newID(t *testing.T) *Identity {
+    t.Helper()
+    id, err := NewIdentity()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return id
+}
+
+type result struct {
+    c   *Conn
+    err error
+}
+
+// handshakePair runs Handshake on both ends of a pipe, each passing its own view of the hellos.
+func handshakePair(t *testing.T, a, b *Identity, aView, bView [2]string) (result, result) {
+    t.Helper()
+    ca, cb := net.Pipe()
+    t.Cleanup(func() { ca.Close(); cb.Close() })
+    ch := make(chan result, 1)
+    go func() {
+        c, err := Handshake(context.Background(), cb, b, []byte(bView[0]), []byte(bView[1]), time.Second)
+        if err != nil {
+            cb.Close() // unblock the other end
+        }
+        ch <- result{c, err}
+    }()
+    c, err := Handshake(context.Background(), ca, a, []byte(aView[0]), []byte(aView[1]), time.Second)
+    if err != nil {
+        ca.Close()
+    }
+    return result{c, err}, <-ch
+}
+
+func TestHandshake(t *testing.T) {
+    a, b := newID(t), newID(t)
+    ra, rb := handshakePair(t, a, b, [2]string{helloA, helloB}, [2]string{helloB, helloA})
+    if ra.err != nil || rb.err != nil {
+        t.Fatalf("handshake: %v / %v", ra.err, rb.err)
+    }
+    if got := ra.c.PeerFingerprint(); got != b.Fingerprint() {
+        t.Errorf("a sees %s, want %s", got, b.Fingerprint())
+    }
+    if got := rb.c.PeerFingerprint(); got != a.Fingerprint() {
+        t.Errorf("b sees %s, want %s", got, a.Fingerprint())
+    }
+
+    // Both directions, including a write spanning several records.
+    long := bytes.Repeat([]byte("x"), 2*MaxRecordPayload+1)
+    go func() {
+        ra.c.Write([]byte("hello"))
+        ra.c.Write(long)
+    }()
+    got := make([]byte, 5+len(long))
+    if _, err := io.ReadFull(rb.c, got); err != nil {
+        t.Fatal(err)
+    }
+    if string(got[:5]) != "hello" || !bytes.Equal(got[5:], long) {
+        t.Error("a->b data corrupted")
+    }
+    go rb.c.Write([]byte("back"))
+    buf := make([]byte, 4)
+    if _, err := io.ReadFull(ra.c, buf); err != nil || string(buf) != "back" {
+        t.Errorf("b->a: %q, %v", buf, err)
+    }
+}
+
+func TestHandshakeHelloMismatch(t *testing.T) {
+    // Someone removed "e2e" from b's hello on its way to a: the ends disagree on what was said.
+    stripped := "BTCHAT/1 minver=1 frame=65536 caps=binary nick=b"
+    ra, rb := handshakePair(t, newID(t), newID(t), [2]string{helloA, stripped}, [2]string{helloB, helloA})
+    if !errors.Is(ra.err, ErrHandshake) || !errors.Is(rb.err, ErrHandshake) {
+        t.Fatalf("got %v / %v, want ErrHandshake on both ends", ra.err, rb.err)
+    }
+}
+
+// fakePeer answers Handshake on conn with reply, given the message 1 it received.
+func fakePeer(t *testing.T, id *Identity, reply func(msg1 []byte) (m1, m2 []byte)) error {
+    t.Helper()
+    ca, cb := net.Pipe()
+    defer ca.Close()
+    defer cb.Close()
+    go func() {
+        msg1 := make([]byte, len(helloMagic)+32)
+        if _, err := io.ReadFull(cb, msg1); err != nil {
+            return
+        }
+        m1, m2 := reply(msg1)
+        go io.Copy(io.Discard, cb)
+        cb.Write(m1)
+        cb.Write(m2)
+    }()
+    _, err := Handshake(context.Background(), ca, id, nil, nil, time.Second)
+    return err
+}
+
+func TestHandshakeBadSignature(t *testing.T) {
+    peer := newID(t)
+    err := fakePeer(t, newID(t), func([]byte) ([]byte, []byte) {
+        eph, _ := ecdh.X25519().GenerateKey(rand.Reader)
+        m2 := append(append([]byte(nil), peer.Public()...), make([]byte, 64)...)
+        return append([]byte(helloMagic), eph.PublicKey().Bytes()...), m2
+    })
+    if !errors.Is(err, ErrHandshake) {
+        t.Fatalf("got %v, want ErrHandshake", err)
+    }
+}
+
+func TestHandshakeReflectedKey(t *testing.T) {
+    err := fakePeer(t, newID(t), func(msg1 []byte) ([]byte, []byte) {
+        return msg1, nil
+    })
+    if !errors.Is(err, ErrHandshake) {
+        t.Fatalf("got %v, want ErrHandshake", err)
+    }
+}
+
+// buffer is a Transport that stores what is written and reads it back.
+type buffer struct{ bytes.Buffer }
+
+func (*buffer) SetDeadline(time.Time) error { return nil }
+
+// recordPair returns two connected sessions whose records go through buffers instead.
+func recordPair(t *testing.T) (w, r *Conn) {
+    t.Helper()
+    ra, rb := handshakePair(t, newID(t), newID(t), [2]string{helloA, helloB}, [2]string{helloB, helloA})
+    if ra.err != nil || rb.err != nil {
+        t.Fatalf("handshake: %v / %v", ra.err, rb.err)
+    }
+    ra.c.t, rb.c.t = new(buffer), new(buffer)
+    return ra.c, rb.c
+}
+
+// records splits the records written to c.
+func records(c *Conn) [][]byte {
+    data := c.t.(*buffer).Bytes()
+    var out [][]byte
+    for len(data) > 0 {
+        n := 2 + (int(data[0])<<8 | int(data[1]))
+        out = append(out, append([]byte(nil), data[:n]...))
+        data = data[n:]
+    }
+    return out
+}
+
+func TestReadRejectsTamperedRecord(t *testing.T) {
+    w, r := recordPair(t)
+    w.Write([]byte("pay 10"))
+    rec := records(w)[0]
+    rec[len(rec)-20] ^= 1 // a ciphertext bit
+    r.t.(*buffer).Write(rec)
+    if _, err := r.Read(make([]byte, 16)); !errors.Is(err, ErrAuth) {
+        t.Fatalf("got %v, want ErrAuth", err)
+    }
+    // The error sticks.
+    if _, err := r.Read(make([]byte, 16)); !errors.Is(err, ErrAuth) {
+        t.Fatalf("second read: got %v, want ErrAuth", err)
+    }
+}
+
+func TestReadRejectsReorderedRecords(t *testing.T) {
+    w, r := recordPair(t)
+    w.Write([]byte("first"))
+    w.Write([]byte("second"))
+    recs := records(w)
+    r.t.(*buffer).Write(recs[1])
+    r.t.(*buffer).Write(recs[0])
+    if _, err := r.Read(make([]byte, 16)); !errors.Is(err, ErrAuth) {
+        t.Fatalf("got %v, want ErrAuth", err)
+    }
+}
+
+func TestReadRejectsReflectedRecord(t *testing.T) {
+    // A record sent back to its author must not verify under the other direction's key.
+    w, _ := recordPair(t)
+    w.Write([]byte("echo"))
+    rec := records(w)[0]
+    w.t = new(buffer)
+    w.t.(*buffer).Write(rec)
+    if _, err := w.Read(make([]byte, 16)); !errors.Is(err, ErrAuth) {
+        t.Fatalf("got %v, want ErrAuth", err)
+    }
+}
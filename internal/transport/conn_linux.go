@@ -0,0 +1,118 @@
+//go:build linux
+
+package transport
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// Conn is a byte stream over an RFCOMM socket. It is safe for concurrent use
+// (one reader and one writer at a time, as for net.Conn).
+type Conn struct {
+    f      *os.File
+    local  Addr
+    remote Addr
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// Open wraps fd (as returned by connmgr Accept/Connect). On success the Conn owns fd and
+// the caller must not use or close it directly; on error fd is left untouched.
+func Open(fd int) (*Conn, error) {
+    if err := syscall.SetNonblock(fd, true); err != nil {
+        return nil, fmt.Errorf("transport: set nonblocking: %w", err)
+    }
+    // A non-blocking FD makes os.NewFile register it with the runtime poller.
+    f := os.NewFile(uintptr(fd), "rfcomm")
+    if f == nil {
+        return nil, fmt.Errorf("transport: invalid fd %d", fd)
+    }
+    c := &Conn{f: f}
+    c.local = sockAddr(unix.Getsockname(fd))
+    c.remote = sockAddr(unix.Getpeername(fd))
+    return c, nil
+}
+
+// sockAddr converts an RFCOMM socket address; anything else (e.g. AF_UNIX) yields the zero Addr.
+func sockAddr(sa unix.Sockaddr, err error) Addr {
+    if err != nil {
+        return Addr{}
+    }
+    rc, ok := sa.(*unix.SockaddrRFCOMM)
+    if !ok {
+        return Addr{}
+    }
+    // The kernel stores bdaddr little-endian; display order is reversed.
+    b := rc.Addr
+    return Addr{
+        MAC:     fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[5], b[4], b[3], b[2], b[1], b[0]),
+        Channel: rc.Channel,
+    }
+}
+
+// Read implements net.Conn. It returns io.EOF after the peer closed the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+    n, err := c.f.Read(p)
+    return n, closedError("read", err)
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+    n, err := c.f.Write(p)
+    return n, closedError("write", err)
+}
+
+// closedError reports use of a closed Conn as net.ErrClosed, like other net.Conns; os.File
+// returns os.ErrClosed, which callers checking net.ErrClosed would not recognize.
+func closedError(op string, err error) error {
+    if errors.Is(err, os.ErrClosed) {
+        return &net.OpError{Op: op, Net: "rfcomm", Err: net.ErrClosed}
+    }
+    return err
+}
+
+// Close closes the socket and unblocks pending Read/Write calls.
+func (c *Conn) Close() error { return c.f.Close() }
+
+// CloseWrite shuts down the sending side (see the package comment for RFCOMM caveats).
+func (c *Conn) CloseWrite() error { return c.shutdown(syscall.SHUT_WR) }
+
+// CloseRead shuts down the receiving side (see the package comment for RFCOMM caveats).
+func (c *Conn) CloseRead() error { return c.shutdown(syscall.SHUT_RD) }
+
+func (c *Conn) shutdown(how int) error {
+    rc, err := c.f.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var serr error
+    if err := rc.Control(func(fd uintptr) { serr = syscall.Shutdown(int(fd), how) }); err != nil {
+        return err
+    }
+    if serr != nil {
+        return os.NewSyscallError("shutdown", serr)
+    }
+    return nil
+}
+
+// LocalAddr returns the local adapter address and RFCOMM channel.
+func (c *Conn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr returns the peer address and RFCOMM channel.
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error { return c.f.SetDeadline(t) }
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.f.SetReadDeadline(t) }
+
+// SetWriteDeadline implements net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.f.SetWriteDeadline(t) }
@@ -0,0 +1,138 @@
+//go:build linux
+
+package transport
+
+import (
+    "errors"
+    "io"
+    "net"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+)
+
+// pair returns two Conns over a Unix socketpair, closed with the test.
+func pair(t *testing.T) (*Conn, *Conn) {
+    t.Helper()
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    a, err := Open(fds[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    b, err := Open(fds[1])
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { a.Close(); b.Close() })
+    return a, b
+}
+
+func TestReadWrite(t *testing.T) {
+    a, b := pair(t)
+    msg := []byte("hello\x00world\n")
+    if n, err := a.Write(msg); err != nil || n != len(msg) {
+        t.Fatalf("Write = %d, %v", n, err)
+    }
+    got := make([]byte, len(msg))
+    if _, err := io.ReadFull(b, got); err != nil || string(got) != string(msg) {
+        t.Fatalf("Read %q, %v", got, err)
+    }
+    if a.LocalAddr().String() != "" || a.RemoteAddr().String() != "" {
+        t.Errorf("socketpair addresses %q %q, want zero", a.LocalAddr(), a.RemoteAddr())
+    }
+}
+
+func TestPeerClose(t *testing.T) {
+    a, b := pair(t)
+    a.Write([]byte("last"))
+    a.Close()
+
+    // Buffered data first, then EOF.
+    got, err := io.ReadAll(b)
+    if err != nil || string(got) != "last" {
+        t.Fatalf("ReadAll %q, %v", got, err)
+    }
+    if _, err := b.Read(make([]byte, 1)); err != io.EOF {
+        t.Fatalf("Read after EOF: %v", err)
+    }
+    // Writing to a closed peer fails instead of raising SIGPIPE.
+    var werr error
+    for i := 0; i < 10 && werr == nil; i++ {
+        _, werr = b.Write([]byte("x"))
+    }
+    if !errors.Is(werr, syscall.EPIPE) && !errors.Is(werr, syscall.ECONNRESET) {
+        t.Fatalf("Write to closed peer: %v, want EPIPE or ECONNRESET", werr)
+    }
+}
+
+func TestDeadline(t *testing.T) {
+    a, _ := pair(t)
+    if err := a.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+        t.Fatal(err)
+    }
+    _, err := a.Read(make([]byte, 1))
+    if !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatalf("got %v, want os.ErrDeadlineExceeded", err)
+    }
+    var ne net.Error
+    if !errors.As(err, &ne) || !ne.Timeout() {
+        t.Errorf("%v does not report Timeout", err)
+    }
+    // Clearing the deadline makes the Conn usable again.
+    if err := a.SetDeadline(time.Time{}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+    a, _ := pair(t)
+    done := make(chan error, 1)
+    go func() {
+        _, err := a.Read(make([]byte, 1))
+        done <- err
+    }()
+    time.Sleep(20 * time.Millisecond)
+    a.Close()
+    select {
+    case err := <-done:
+        if !errors.Is(err, net.ErrClosed) {
+            t.Fatalf("got %v, want net.ErrClosed", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("Read not unblocked by Close")
+    }
+    if _, err := a.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+        t.Fatalf("Write after Close: got %v, want net.ErrClosed", err)
+    }
+}
+
+func TestCloseWrite(t *testing.T) {
+    // Over a socketpair, half-close works as usual (RFCOMM would drop the link).
+    a, b := pair(t)
+    a.Write([]byte("req"))
+    if err := a.CloseWrite(); err != nil {
+        t.Fatal(err)
+    }
+    got, err := io.ReadAll(b)
+    if err != nil || string(got) != "req" {
+        t.Fatalf("ReadAll %q, %v", got, err)
+    }
+    b.Write([]byte("resp"))
+    buf := make([]byte, 4)
+    if _, err := io.ReadFull(a, buf); err != nil || string(buf) != "resp" {
+        t.Fatalf("reply %q, %v", buf, err)
+    }
+    if err := b.CloseRead(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestOpenInvalidFD(t *testing.T) {
+    if _, err := Open(-1); err == nil {
+        t.Fatal("Open(-1) succeeded")
+    }
+}
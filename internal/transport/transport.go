@@ -0,0 +1,37 @@
+// Package transport turns the RFCOMM socket FD handed out by connmgr into a net.Conn
+// (DESIGN.md layer B).
+//
+// The FD is switched to non-blocking mode and registered with the Go runtime poller, so
+// Read/Write block only the calling goroutine and SetDeadline/SetReadDeadline/SetWriteDeadline
+// work as for TCP connections (a timed-out call returns an error wrapping
+// os.ErrDeadlineExceeded whose Timeout method reports true).
+//
+// EOF and close semantics:
+//   - Read returns io.EOF once the peer has closed or shut down its side and all
+//     buffered data has been consumed.
+//   - Write after the peer has gone returns an error (EPIPE/ECONNRESET); no SIGPIPE is raised.
+//   - CloseWrite and CloseRead call shutdown(2). RFCOMM has no true half-close: the kernel
+//     disconnects the DLC on any shutdown, so the peer sees EOF and further I/O in both
+//     directions fails. Over a Unix socketpair (used for tests) half-close behaves as usual.
+//   - Close unblocks pending Read/Write calls, which then return net.ErrClosed.
+package transport
+
+import "strconv"
+
+// Addr is a Bluetooth RFCOMM endpoint. It implements net.Addr.
+// The zero value means "unknown" (e.g. a socketpair used in tests).
+type Addr struct {
+    MAC     string // "AA:BB:CC:DD:EE:FF"
+    Channel uint8  // RFCOMM channel; 0 if unknown
+}
+
+// Network returns "rfcomm".
+func (a Addr) Network() string { return "rfcomm" }
+
+// String returns "MAC/channel", e.g. "AA:BB:CC:DD:EE:FF/22", or "" for the zero Addr.
+func (a Addr) String() string {
+    if a.MAC == "" && a.Channel == 0 {
+        return ""
+    }
+    return a.MAC + "/" + strconv.Itoa(int(a.Channel))
+}
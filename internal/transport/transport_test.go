@@ -0,0 +1,13 @@
+package transport
+
+import "testing"
+
+func TestAddr(t *testing.T) {
+    a := Addr{MAC: "AA:BB:CC:DD:EE:FF", Channel: 22}
+    if a.Network() != "rfcomm" || a.String() != "AA:BB:CC:DD:EE:FF/22" {
+        t.Errorf("got %s %q", a.Network(), a.String())
+    }
+    if (Addr{}).String() != "" {
+        t.Errorf("zero Addr: %q", Addr{}.String())
+    }
+}